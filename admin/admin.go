@@ -0,0 +1,106 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+const DefaultLimit = 100 //分页时每页默认的条数
+const MaxLimit = 1000
+
+// 一页键
+type Page struct {
+	Total  int         //键的总数
+	Offset int         //本页第一条的下标
+	Items  interface{} //本页的各条
+}
+
+// 由请求的offset与limit参数得到本页在全部total条中的范围[begin, end)
+func PageRange(r *http.Request, total int) (begin, end int, err error) {
+	begin, err = IntParam(r, "offset", 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	limit, err := IntParam(r, "limit", DefaultLimit)
+	if err != nil {
+		return 0, 0, err
+	}
+	if begin < 0 || limit < 0 {
+		return 0, 0, errors.New("offset and limit should not be negative")
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+	if begin > total {
+		begin = total
+	}
+	end = begin + limit
+	if end > total {
+		end = total
+	}
+	return begin, end, nil
+}
+
+// 整数参数，缺省时为def
+func IntParam(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	out, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New("invalid " + name + ": " + value)
+	}
+	return out, nil
+}
+
+// 以JSON格式应答
+func WriteJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// 以JSON格式应答错误
+func WriteError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// 只接受GET请求的处理函数(接口只读)
+func Get(handler func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			WriteError(w, http.StatusMethodNotAllowed, errors.New("read-only endpoint"))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// 在addr上启动HTTP服务，关闭返回的listener即停止服务
+func Serve(addr string, handler http.Handler) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	return &serverListener{listener, server}, nil
+}
+
+// 关闭时同时关闭已建立的连接(包括keep-alive的连接)
+type serverListener struct {
+	net.Listener
+	server *http.Server
+}
+
+func (listener *serverListener) Close() error {
+	return listener.server.Close()
+}
@@ -0,0 +1,64 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPageRange(t *testing.T) {
+	cases := []struct {
+		query      string
+		total      int
+		begin, end int
+		invalid    bool
+	}{
+		{"", 250, 0, DefaultLimit, false},
+		{"offset=240&limit=20", 250, 240, 250, false},
+		{"offset=300", 250, 250, 250, false}, //越过末尾时得到空页
+		{"limit=0", 250, 0, 0, false},
+		{"limit=5000", 3000, 0, MaxLimit, false}, //超过上限时按上限
+		{"limit=-1", 250, 0, 0, true},
+		{"offset=-1", 250, 0, 0, true},
+		{"limit=ten", 250, 0, 0, true},
+	}
+	for _, c := range cases {
+		request := httptest.NewRequest(http.MethodGet, "/keys?"+c.query, nil)
+		begin, end, err := PageRange(request, c.total)
+		if c.invalid {
+			if err == nil {
+				t.Errorf("%q: should be rejected", c.query)
+			}
+			continue
+		}
+		if err != nil || begin != c.begin || end != c.end {
+			t.Errorf("%q: PageRange = %d, %d, %v, want %d, %d", c.query, begin, end, err, c.begin, c.end)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	handler := Get(func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, Page{Total: 1, Items: []string{"key"}})
+	})
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/keys", nil))
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("GET: status %d, content type %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(recorder.Body.String(), `"key"`) {
+		t.Fatalf("wrong body %s", recorder.Body.String())
+	}
+	recorder = httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodPost, "/keys", nil))
+	if recorder.Code != http.StatusMethodNotAllowed || recorder.Header().Get("Allow") != "GET, HEAD" {
+		t.Fatalf("POST: status %d, Allow %q", recorder.Code, recorder.Header().Get("Allow"))
+	}
+	recorder = httptest.NewRecorder()
+	WriteError(recorder, http.StatusBadRequest, errors.New("bad"))
+	if recorder.Code != http.StatusBadRequest || strings.TrimSpace(recorder.Body.String()) != `{"error":"bad"}` {
+		t.Fatalf("WriteError: status %d, body %s", recorder.Code, recorder.Body.String())
+	}
+}
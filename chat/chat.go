@@ -1,16 +1,25 @@
 package chat
 
 import (
+	"context"
+	"crypto/tls"
 	"dht/chord"
+	"dht/rpc"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"sync"
 	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 const groupIdValidTime = 5 * time.Minute
+const logFile = "dht-chat.log"
 const trySendCircle = 5 * time.Second
+const presenceTTL = 3 * trySendCircle       //在线状态的过期时间，在线时每trySendCircle刷新，异常退出后过期即视为离线
+const chatInfoKeepTime = 7 * 24 * time.Hour //群聊消息的保留时长
 
 type ChatNode struct {
 	node                  *chord.Node
@@ -30,8 +39,10 @@ type ChatNode struct {
 	sentInvitation        map[string]([]InvitationPair) // 发出但对方还未接收的群聊邀请
 	sentInvitationLock    sync.RWMutex
 	online                bool
+	tlsConfig             *tls.Config // 不为空时，节点间(含Chat服务)的连接使用TLS双向认证
 }
 
+// 账户记录(键为用户名)，Online只为兼容旧记录而保留，在线状态以presenceKey的记录为准
 type AccountRecord struct {
 	Online      bool
 	IP          string
@@ -78,13 +89,24 @@ type InvitationPair struct {
 	GroupStartTime time.Time
 }
 
-// 初始化
-func init() {
+// 初始化控制台(进入聊天程序时调用)，节点的日志(logrus的标准日志)写入logFile，不打乱聊天界面
+func initConsole() {
 	err := setConsoleWidth()
 	if err != nil {
 		fmt.Println("Get console width error.")
 	}
 	setStrings()
+	f, err := os.Create(logFile)
+	if err != nil {
+		fmt.Println("Create log file error.")
+	} else {
+		logrus.SetOutput(f)
+	}
+}
+
+// 用户的日志(使用chord节点的日志)
+func (chatNode *ChatNode) log() *logrus.Entry {
+	return chatNode.node.Logger().WithField("user", chatNode.name)
 }
 
 // 登录
@@ -94,6 +116,7 @@ func (chatNode *ChatNode) Login(name, ip, password, knownIp string, register boo
 	if !ok {
 		return errors.New("Init error.")
 	}
+	chatNode.node.RPC.TLSConfig = chatNode.tlsConfig
 	chatNode.node.Run()
 	chatNode.node.RPC.Register("Chat", &RPCWrapper{chatNode})
 	if register {
@@ -125,14 +148,19 @@ func (chatNode *ChatNode) Login(name, ip, password, knownIp string, register boo
 		}
 	}
 	time.Sleep(500 * time.Millisecond) //使得数据转移
+	jsonInfo, _ := json.Marshal(AccountRecord{true, ip, chatNode.accountSeed})
 	if register {
 		for true {
-			ok, _ := chatNode.node.Get(name)
-			if ok {
+			err := chatNode.node.PutIfAbsent(context.Background(), name, string(jsonInfo)) //原子地占用用户名
+			if errors.Is(err, rpc.ErrConditionFailed) {
 				PrintCentre("Name existed, please change a new one.", "red")
 				PrintCentre("Type:", "yellow")
 				name = Scan('\n')
 				chatNode.name = name
+			} else if err != nil {
+				chatNode.log().WithError(err).Error("Registering account error.")
+				chatNode.node.Quit()
+				return errors.New("Account put error.")
 			} else {
 				break
 			}
@@ -145,11 +173,10 @@ func (chatNode *ChatNode) Login(name, ip, password, knownIp string, register boo
 				err := json.Unmarshal([]byte(accountString), &accountRecord)
 				if err != nil {
 					chatNode.node.Quit()
-					fmt.Println(err)
-					fmt.Println(accountRecord)
+					chatNode.log().WithField("account", name).WithError(err).Error("Parsing account record error.")
 					return errors.New("Account get error.")
 				}
-				if accountRecord.Online {
+				if _, online := chatNode.getPresence(name); online {
 					chatNode.node.Quit()
 					return errors.New("The account is online on other device now!")
 				}
@@ -169,8 +196,7 @@ func (chatNode *ChatNode) Login(name, ip, password, knownIp string, register boo
 				err := json.Unmarshal([]byte(chatNodeRecordString), &chatNodeRecord)
 				if err != nil {
 					chatNode.node.Quit()
-					fmt.Println(err)
-					fmt.Println(chatNodeRecordString)
+					chatNode.log().WithError(err).Error("Parsing account info error.")
 					return errors.New("Account info get error.")
 				}
 				chatNode.password = password
@@ -187,32 +213,68 @@ func (chatNode *ChatNode) Login(name, ip, password, knownIp string, register boo
 				password = Scan('\n')
 			}
 		}
+		jsonInfo, _ = json.Marshal(AccountRecord{true, ip, chatNode.accountSeed})
+		ok = chatNode.node.Put(name, string(jsonInfo))
+		if !ok {
+			chatNode.log().Error("Putting account record error.")
+			chatNode.node.Quit()
+			return errors.New("Account put error.")
+		}
 	}
-	jsonInfo, _ := json.Marshal(AccountRecord{true, ip, chatNode.accountSeed})
-	ok = chatNode.node.Put(name, string(jsonInfo))
-	if !ok {
-		chatNode.node.Quit()
-		return errors.New("Account put error.")
-	}
+	chatNode.log().Info("User logs in.")
 	chatNode.online = true
+	chatNode.putPresence()
+	go chatNode.keepPresence()
 	chatNode.RestartSendTry()
 	return nil
 }
 
+// 写入在线状态(用户当前的IP)，经过presenceTTL后过期
+func (chatNode *ChatNode) putPresence() {
+	err := chatNode.node.PutTTL(context.Background(), presenceKey(chatNode.name), chatNode.node.IP, presenceTTL)
+	if err != nil {
+		chatNode.log().WithError(err).Warn("Putting presence error.")
+	}
+}
+
+// 在线时定期刷新在线状态
+func (chatNode *ChatNode) keepPresence() {
+	for {
+		time.Sleep(trySendCircle)
+		if !chatNode.online {
+			return
+		}
+		chatNode.putPresence()
+	}
+}
+
+// 查询用户的在线状态，在线时给出其IP
+func (chatNode *ChatNode) getPresence(name string) (string, bool) {
+	ok, ip := chatNode.node.Get(presenceKey(name))
+	return ip, ok
+}
+
 // 登出
 func (chatNode *ChatNode) LogOut() {
 	chatNode.online = false
+	err := chatNode.node.DeleteContext(context.Background(), presenceKey(chatNode.name))
+	if err != nil && !errors.Is(err, rpc.ErrNotFound) {
+		chatNode.log().WithError(err).Warn("Deleting presence error.")
+	}
 	jsonInfo, _ := json.Marshal(AccountRecord{false, chatNode.node.IP, chatNode.accountSeed})
 	ok := chatNode.node.Put(chatNode.name, string(jsonInfo))
 	if !ok {
+		chatNode.log().Error("Saving account record error.")
 		PrintCentre("Save account error!", "red")
 	}
 	jsonInfo, _ = json.Marshal(ChatNodeRecord{chatNode.friendList, chatNode.groups,
 		chatNode.friendRequest, chatNode.sentFriendRequest, chatNode.invitation, chatNode.sentInvitation})
 	ok = chatNode.node.Put(chatNode.accountSeed+chatNode.password, string(jsonInfo))
 	if !ok {
+		chatNode.log().Error("Saving account info error.")
 		PrintCentre("Save account error!", "red")
 	}
+	chatNode.log().Info("User logs out.")
 	time.Sleep(1 * time.Second)
 	chatNode.node.Quit()
 }
@@ -355,6 +417,9 @@ func (chatNode *ChatNode) TrySendFriendRequest(friendName string) {
 			continue
 		}
 		err = chatNode.node.RPC.RemoteCall(friendIp, "Chat.AcceptFriendRequest", chatNode.name, &Null{})
+		if err != nil {
+			chatNode.log().WithField("friend", friendName).WithError(err).Debug("Sending friend request error.")
+		}
 		if err == nil {
 			chatNode.sentFriendRequestLock.Lock()
 			chatNode.sentFriendRequest[friendName] = "To be confirmed"
@@ -377,6 +442,9 @@ func (chatNode *ChatNode) TrySendBackFriendRequest(friendName string, pair SendB
 			continue
 		}
 		err = chatNode.node.RPC.RemoteCall(friendIp, "Chat.SendBackFriendRequest", pair, &Null{})
+		if err != nil {
+			chatNode.log().WithField("friend", friendName).WithError(err).Debug("Sending back friend request error.")
+		}
 		if err == nil {
 			chatNode.friendRequestLock.Lock()
 			delete(chatNode.friendRequest, friendName)
@@ -537,6 +605,9 @@ func (chatNode *ChatNode) TryInvite(pair InvitationPair) error {
 		return err
 	}
 	err = chatNode.node.RPC.RemoteCall(friendIp, "Chat.AcceptInvitation", pair, &Null{})
+	if err != nil {
+		chatNode.log().WithFields(logrus.Fields{"friend": pair.ToName, "group": pair.GroupChatName}).WithError(err).Debug("Sending invitation error.")
+	}
 	if err == nil || err.Error() == "User has been in that group chat already." ||
 		err.Error() == "User has received the invitation. Please wait him/her to confirm." {
 		chatNode.sentInvitationLock.Lock()
@@ -563,7 +634,7 @@ func (chatNode *ChatNode) TryInvite(pair InvitationPair) error {
 func (chatNode *ChatNode) SendChatInfo(info string, groupChat GroupChatRecord) error {
 	sendTime := time.Now()
 	jsonInfo, _ := json.Marshal(InfoRecord{chatNode.name, sendTime, info})
-	ok := chatNode.node.PutMode(getGroupIp(groupChat.GroupSeed, groupChat.GroupStartTime, sendTime), string(jsonInfo)+"\n", "append")
+	ok := chatNode.node.PutModeTTL(getGroupIp(groupChat.GroupSeed, groupChat.GroupStartTime, sendTime), string(jsonInfo)+"\n", "append", chatInfoKeepTime)
 	if !ok {
 		return errors.New("Send info error.")
 	}
@@ -587,10 +658,10 @@ func (chatNode *ChatNode) GetChatInfo(groupChat GroupChatRecord, beginTime, endT
 	return infos, nil
 }
 
-// 得到群聊更早的有聊天记录的时间(群聊信息是按时间一段段存储的)
+// 得到群聊更早的有聊天记录的时间(群聊信息是按时间一段段存储的，超过chatInfoKeepTime的已过期)
 func (chatNode *ChatNode) GetEarlierChatInfoTime(groupChat GroupChatRecord, beginTime time.Time) (time.Time, error) {
-	timeNow := beginTime.Add(-groupIdValidTime)
-	for timeNow.After(groupChat.GroupStartTime) {
+	oldest := time.Now().Add(-chatInfoKeepTime - groupIdValidTime)
+	for timeNow := beginTime.Add(-groupIdValidTime); timeNow.After(groupChat.GroupStartTime) && timeNow.After(oldest); timeNow = timeNow.Add(-groupIdValidTime) {
 		ok, _ := chatNode.node.GetMode(getGroupIp(groupChat.GroupSeed, groupChat.GroupStartTime, timeNow), "append")
 		if ok {
 			return timeNow, nil
@@ -1,6 +1,7 @@
 package chat
 
 import (
+	"crypto/tls"
 	"fmt"
 	"strconv"
 	"time"
@@ -8,6 +9,7 @@ import (
 
 // 主程序
 func Chat() {
+	initConsole()
 	var err error
 	node := new(ChatNode)
 	cursorIndex := 0
@@ -74,6 +76,19 @@ func Chat() {
 	}
 }
 
+// 以给定的账户直接登录(knownIp为空时创建新的网络，tlsConfig不为空时使用TLS)，之后进入交互页面，登录失败时返回错误
+func Run(name, ip, password, knownIp string, register bool, tlsConfig *tls.Config) error {
+	initConsole()
+	node := &ChatNode{tlsConfig: tlsConfig}
+	err := node.Login(name, ip, password, knownIp, register)
+	if err != nil {
+		return err
+	}
+	node.interactive()
+	node.LogOut()
+	return nil
+}
+
 // 交互页面间的状态转移
 func (chatNode *ChatNode) interactive() {
 	next := "homepage"
@@ -215,7 +215,7 @@ func moveCursor(len, cursorIndex int, control string) int {
 	}
 }
 
-// 得到用户账号的IP和上线情况
+// 得到用户账号的IP和上线情况(在线时IP取自在线状态)
 func (chatNode *ChatNode) getUserAccount(friendName string) (string, bool, error) {
 	friendAccount := AccountRecord{}
 	ok, friendAccountString := chatNode.node.Get(friendName)
@@ -226,7 +226,15 @@ func (chatNode *ChatNode) getUserAccount(friendName string) (string, bool, error
 	if err != nil {
 		return "", false, errors.New("Parse error!")
 	}
-	return friendAccount.IP, friendAccount.Online, nil
+	if ip, online := chatNode.getPresence(friendName); online {
+		return ip, true, nil
+	}
+	return friendAccount.IP, false, nil
+}
+
+// 用户在线状态的键(用户名不含'\x00'，不会与其他用户名相同)
+func presenceKey(name string) string {
+	return name + "\x00presence"
 }
 
 // 输入Y/y，返回true；输入N/n，返回false
@@ -0,0 +1,221 @@
+package chord
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"dht/admin"
+)
+
+const RingWalkTimeOut = 10 * time.Second //一次环遍历的时限
+const DefaultRingWalkLimit = 1000        //环遍历最多经过的节点数
+
+// 节点的路由状态(只读快照)
+type RoutingState struct {
+	IP          string
+	ID          string //十六进制
+	Online      bool
+	Predecessor string
+	Successors  []string
+}
+
+// finger表中指向同一节点的一段连续表项[First, Last]
+type FingerRange struct {
+	First int
+	Last  int
+	Start string //第First项的起点(十六进制)
+	IP    string
+}
+
+// 节点状态
+type NodeState struct {
+	RoutingState
+	Replica    int      //复制因子r
+	Replicas   []string //负责备份主数据的后继
+	Fingers    []FingerRange
+	Iterative  bool
+	Ordered    bool
+	DataKeys   int
+	BackupKeys int
+}
+
+// 一个存储的键
+type KeyInfo struct {
+	Key        string
+	Mode       string
+	Versions   int        //并发写入产生的版本数
+	ExpireTime *time.Time `json:",omitempty"`
+}
+
+// 环遍历中经过的一个节点
+type RingStep struct {
+	RoutingState
+	Err string `json:",omitempty"` //无法得到该节点的状态时的错误
+}
+
+// 环遍历的结果：从起点沿后继依次经过的节点
+type RingWalk struct {
+	Complete bool //是否回到了起点
+	Nodes    []RingStep
+}
+
+func formatId(id *big.Int) string {
+	if id == nil {
+		return ""
+	}
+	return fmt.Sprintf("%040x", id)
+}
+
+// 节点当前的路由状态(不进行远端调用)
+func (node *Node) Routing() RoutingState {
+	node.preLock.RLock()
+	predecessor := node.predecessor
+	node.preLock.RUnlock()
+	return RoutingState{node.IP, formatId(node.ID), node.Online, predecessor, node.GetSuccessorList()}
+}
+
+// 节点当前的状态(不进行远端调用)
+func (node *Node) State() NodeState {
+	state := NodeState{RoutingState: node.Routing(), Iterative: node.iterative, Ordered: node.ordered}
+	node.sucLock.RLock()
+	state.Replica = node.replica
+	node.sucLock.RUnlock()
+	state.Replicas = node.replicaList()
+	node.fingerLock.RLock()
+	for i := 2; i <= 160; i++ {
+		last := len(state.Fingers) - 1
+		if last >= 0 && state.Fingers[last].IP == node.finger[i] {
+			state.Fingers[last].Last = i
+		} else {
+			state.Fingers = append(state.Fingers, FingerRange{i, i, formatId(node.fingerStart[i]), node.finger[i]})
+		}
+	}
+	node.fingerLock.RUnlock()
+	node.dataLock.RLock()
+	state.DataKeys = node.data.Size()
+	node.dataLock.RUnlock()
+	node.dataBackupLock.RLock()
+	state.BackupKeys = node.dataBackup.Size()
+	node.dataBackupLock.RUnlock()
+	return state
+}
+
+// 主数据(backup为false)或备份数据中的键(不包括已过期的)，按键排序
+func (node *Node) storedKeys(backup bool) []KeyInfo {
+	var data []DataPair
+	if backup {
+		node.dataBackupLock.RLock()
+		data = node.dataBackup.All()
+		node.dataBackupLock.RUnlock()
+	} else {
+		node.dataLock.RLock()
+		data = node.data.All()
+		node.dataLock.RUnlock()
+	}
+	out := make([]KeyInfo, 0, len(data))
+	now := time.Now()
+	for _, dataPair := range data {
+		if dataPair.Values.expired(now) { //尚未清除的过期数据
+			continue
+		}
+		info := KeyInfo{Key: dataPair.Key, Mode: dataPair.Values.Mode, Versions: len(dataPair.Values.Versions)}
+		if !dataPair.Values.ExpireTime.IsZero() {
+			expireTime := dataPair.Values.ExpireTime
+			info.ExpireTime = &expireTime
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Key != out[j].Key {
+			return out[i].Key < out[j].Key
+		}
+		return out[i].Mode < out[j].Mode
+	})
+	return out
+}
+
+// 从当前节点出发沿后继遍历整个环(最多limit个节点)，后继无应答时改用后继列表中的下一个
+func (node *Node) WalkRing(ctx context.Context, limit int) RingWalk {
+	walk := RingWalk{}
+	visited := make(map[string]bool)
+	candidates := []string{node.IP}
+	for len(walk.Nodes) < limit && len(candidates) > 0 && ctx.Err() == nil {
+		ip := candidates[0]
+		candidates = candidates[1:]
+		if ip == node.IP && len(walk.Nodes) > 0 {
+			walk.Complete = true
+			break
+		}
+		if ip == "" || ip == "OFFLINE" || visited[ip] {
+			continue
+		}
+		visited[ip] = true
+		state := RoutingState{}
+		err := error(nil)
+		if ip == node.IP {
+			state = node.Routing()
+		} else {
+			err = node.RPC.RemoteCallContext(ctx, ip, "Chord.Routing", Null{}, &state)
+		}
+		if err != nil {
+			walk.Nodes = append(walk.Nodes, RingStep{RoutingState{IP: ip}, err.Error()})
+			continue //跳过无应答的节点，尝试上一个节点后继列表中的下一个
+		}
+		walk.Nodes = append(walk.Nodes, RingStep{RoutingState: state})
+		candidates = state.Successors
+	}
+	return walk
+}
+
+// 管理接口(均为GET，返回JSON)：
+// /state 节点状态；/keys?store=data|backup&offset=&limit= 分页给出存储的键；/ring?limit= 沿后继遍历整个环
+func (node *Node) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/state", admin.Get(func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, node.State())
+	}))
+	mux.HandleFunc("/keys", admin.Get(func(w http.ResponseWriter, r *http.Request) {
+		store := r.URL.Query().Get("store")
+		if store != "" && store != "data" && store != "backup" {
+			admin.WriteError(w, http.StatusBadRequest, errors.New("store should be data or backup"))
+			return
+		}
+		keys := node.storedKeys(store == "backup")
+		begin, end, err := admin.PageRange(r, len(keys))
+		if err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		admin.WriteJSON(w, admin.Page{Total: len(keys), Offset: begin, Items: keys[begin:end]})
+	}))
+	mux.HandleFunc("/ring", admin.Get(func(w http.ResponseWriter, r *http.Request) {
+		limit, err := admin.IntParam(r, "limit", DefaultRingWalkLimit)
+		if err != nil || limit <= 0 {
+			admin.WriteError(w, http.StatusBadRequest, errors.New("limit should be a positive integer"))
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), RingWalkTimeOut)
+		defer cancel()
+		admin.WriteJSON(w, node.WalkRing(ctx, limit))
+	}))
+	return mux
+}
+
+// 在addr上启动管理接口的HTTP服务，节点退出时停止，返回实际监听的地址
+func (node *Node) ServeAdmin(addr string) (net.Addr, error) {
+	listener, err := admin.Serve(addr, node.AdminHandler())
+	if err != nil {
+		node.log.WithField("address", addr).WithError(err).Error("Serving admin error.")
+		return nil, err
+	}
+	node.httpLock.Lock()
+	node.httpListeners = append(node.httpListeners, listener)
+	node.httpLock.Unlock()
+	return listener.Addr(), nil
+}
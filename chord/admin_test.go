@@ -0,0 +1,135 @@
+package chord
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"dht/admin"
+	"dht/rpc"
+)
+
+// 经管理接口读取，应答解析到out中，返回状态码
+func adminGet(t *testing.T, node *Node, path string, out interface{}) int {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	node.AdminHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	if recorder.Code == http.StatusOK {
+		if err := json.Unmarshal(recorder.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	return recorder.Code
+}
+
+func TestAdminState(t *testing.T) {
+	nodes := newTestRingWith(t, rpc.NewMemoryTransport(), 4, func(i int, node *Node) {
+		node.SetReplica(3)
+	})
+	for i := 0; i < 30; i++ {
+		if !nodes[0].Put("key"+strconv.Itoa(i), "value") {
+			t.Fatalf("putting key%d error", i)
+		}
+	}
+	state := NodeState{}
+	if code := adminGet(t, nodes[1], "/state", &state); code != http.StatusOK {
+		t.Fatalf("/state: status %d", code)
+	}
+	if state.IP != nodes[1].IP || !state.Online || state.Replica != 3 || len(state.Successors) != successorListSize(3) {
+		t.Fatalf("/state: %+v", state)
+	}
+	if state.Predecessor == "" || state.ID != formatId(nodes[1].ID) || len(state.Fingers) == 0 {
+		t.Fatalf("/state routing: %+v", state.RoutingState)
+	}
+	if state.DataKeys != nodes[1].State().DataKeys {
+		t.Fatalf("/state: %d keys, want %d", state.DataKeys, nodes[1].State().DataKeys)
+	}
+}
+
+func TestAdminKeysPaging(t *testing.T) {
+	nodes := newTestRing(t, 1)
+	for i := 0; i < admin.MaxLimit+10; i++ {
+		if !nodes[0].Put("key"+strconv.Itoa(i), "value") {
+			t.Fatalf("putting key%d error", i)
+		}
+	}
+	//已过期但尚未清除的键不列出
+	if err := nodes[0].PutTTL(context.Background(), "expired", "value", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	total := admin.MaxLimit + 10
+	cases := []struct {
+		query        string
+		offset, size int
+	}{
+		{"", 0, admin.DefaultLimit},
+		{"?offset=5&limit=3", 5, 3},
+		{"?offset=" + strconv.Itoa(total-2) + "&limit=10", total - 2, 2},
+		{"?offset=" + strconv.Itoa(total+5), total, 0}, //越过末尾
+		{"?limit=" + strconv.Itoa(admin.MaxLimit+5), 0, admin.MaxLimit},
+		{"?store=backup", 0, 0}, //只有一个节点，没有备份
+	}
+	for _, c := range cases {
+		page := struct {
+			Total  int
+			Offset int
+			Items  []KeyInfo
+		}{}
+		if code := adminGet(t, nodes[0], "/keys"+c.query, &page); code != http.StatusOK {
+			t.Errorf("/keys%s: status %d", c.query, code)
+			continue
+		}
+		wantTotal := total
+		if c.query == "?store=backup" {
+			wantTotal = 0
+		}
+		if page.Total != wantTotal || page.Offset != c.offset || len(page.Items) != c.size {
+			t.Errorf("/keys%s: total %d, offset %d, %d items, want %d, %d, %d", c.query, page.Total, page.Offset, len(page.Items), wantTotal, c.offset, c.size)
+		}
+		for i := 1; i < len(page.Items); i++ {
+			if page.Items[i-1].Key >= page.Items[i].Key {
+				t.Errorf("/keys%s: keys not sorted", c.query)
+				break
+			}
+		}
+	}
+	for _, query := range []string{"?limit=-1", "?offset=-3", "?limit=x", "?store=all"} {
+		if code := adminGet(t, nodes[0], "/keys"+query, nil); code != http.StatusBadRequest {
+			t.Errorf("/keys%s: status %d, want %d", query, code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAdminRingAfterForceQuit(t *testing.T) {
+	nodes := newTestRing(t, 6)
+	walk := RingWalk{}
+	if code := adminGet(t, nodes[0], "/ring", &walk); code != http.StatusOK || !walk.Complete || len(walk.Nodes) != 6 {
+		t.Fatalf("/ring: status %d, %+v", code, walk)
+	}
+	quit := nodeByIP(nodes, nodes[0].GetSuccessorList()[0]) //强制退出起点的后继，遍历需绕过它
+	quit.ForceQuit()
+	walk = RingWalk{}
+	if code := adminGet(t, nodes[0], "/ring", &walk); code != http.StatusOK || !walk.Complete {
+		t.Fatalf("/ring after force quitting: status %d, %+v", code, walk)
+	}
+	online := 0
+	for _, step := range walk.Nodes {
+		if step.Err == "" {
+			online++
+			if step.IP == quit.IP {
+				t.Fatalf("/ring: %s answered after force quitting", quit.IP)
+			}
+		}
+	}
+	if online != 5 {
+		t.Fatalf("/ring: %d nodes answered, want 5: %+v", online, walk.Nodes)
+	}
+	if code := adminGet(t, nodes[0], "/ring?limit=0", nil); code != http.StatusBadRequest {
+		t.Fatalf("/ring?limit=0: status %d", code)
+	}
+}
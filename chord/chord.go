@@ -1,17 +1,36 @@
 package chord
 
 import (
+	"context"
+	"crypto/ed25519"
+	"errors"
 	"math/big"
 	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
+	"dht/config"
+	"dht/logging"
+	"dht/metrics"
 	"dht/rpc"
+	"dht/trace"
+	"dht/version"
+
+	"github.com/sirupsen/logrus"
 )
 
 type ValuePair struct {
-	Value string
-	KeyId *big.Int
+	Value      string
+	KeyId      *big.Int
+	Versions   version.Siblings //各版本，Value为按合并函数得到的值；写入请求中为空表示盲写
+	ExpireTime time.Time        //过期时间(绝对时间，随复制与转移一同传递，使各副本同时过期)，零值表示永不过期
+	Mode       string           //数据模式
+	Format     int              //数据格式版本
 }
 
 type DataPair struct {
@@ -24,8 +43,69 @@ type IpPair struct {
 	IpPrePre string
 }
 
+type IdDeadlinePair struct {
+	Id       *big.Int
+	Deadline time.Time //为零值时表示无截止时间
+}
+
+type LevelDataPairs struct {
+	Data  []DataPair
+	Level rpc.Consistency
+}
+
+type LevelKeyPair struct {
+	Key   string
+	Mode  string
+	Level rpc.Consistency
+}
+
+type KeyModePair struct {
+	Key  string
+	Mode string
+}
+
+type ConditionPair struct {
+	Key       string
+	KeyId     *big.Int
+	Condition Condition
+	Expected  string //IF_EQUAL时要求的当前值
+	Value     string
+	Delta     int64 //INCREMENT时的增量
+}
+
+type ScanRange struct {
+	StartKey string
+	EndKey   string   //为空表示无上界
+	StartId  *big.Int //询问的节点负责的ID区间[StartId, EndId]
+	EndId    *big.Int
+	Limit    int //不为正时不限数量
+}
+
+type ScanPair struct {
+	Key   string
+	Value string
+	Mode  string
+}
+
+type PathReply struct {
+	IP   string
+	Hops []trace.Hop //经过的各跳(不包括被询问的节点自身)
+	Err  string      //查找失败时之后各跳给出的错误，经过的各跳仍然返回
+}
+
+type LookupReply struct {
+	Fingers    []string //路由表中在目标位置前最近的若干节点(由近到远)
+	Successors []string
+}
+
 type Null struct{}
 
+const DefaultReplica = config.DefaultReplica //默认复制因子：主数据复制到前2个后继，相邻两节点同时下线也不丢失数据，可由config.Chord设置
+const minSuccessorListSize = 3
+const ExpireCircleTime = time.Second //清除过期数据的周期
+const LookupHopTimeOut = time.Second //迭代查找中每一跳的时限，超时即绕开该节点
+const lookupFingers = 3              //迭代查找时每一跳给出的候选节点数
+
 type Node struct {
 	Online         bool
 	RPC            rpc.NodeRpc
@@ -33,16 +113,36 @@ type Node struct {
 	ID             *big.Int
 	predecessor    string
 	preLock        sync.RWMutex
-	successorList  [3]string
+	successorList  []string //长度为max(3, r+1)
+	replica        int      //复制因子r
+	replicas       []string //已复制主数据的后继
+	merge          version.MergeFunc
+	ordered        bool            //键ID保持键的顺序(用于Scan)
+	iterative      bool            //由当前节点迭代地查找，而不是逐跳递归转发
+	config         config.Chord    //周期性维护的配置
+	stabilizeSched *schedule       //stabilize的自适应间隔
+	fixFingerSched *schedule       //fixFinger的自适应间隔
+	maintainStats  maintainStats   //维护的轮数及节省的轮数与消息
+	hops           trace.Histogram //由当前节点发起的查找的跳数
+	stabilizeTime  metrics.Summary
+	fixFingerTime  metrics.Summary
+	registry       *metrics.Registry
+	httpListeners  []net.Listener //指标与管理接口的HTTP服务，退出时关闭
+	httpLock       sync.Mutex
+	logger         *logrus.Logger //为空时使用logrus的标准日志
+	log            *logrus.Entry  //带有节点地址与ID的日志
 	sucLock        sync.RWMutex
 	finger         [161]string
 	fingerLock     sync.RWMutex
-	data           map[string]ValuePair
+	data           Storage //主数据
 	dataLock       sync.RWMutex
-	dataBackup     map[string]ValuePair
+	dataBackup     Storage //备份数据
 	dataBackupLock sync.RWMutex
 	fixIndex       int
 	fingerStart    [161]*big.Int
+	key            ed25519.PrivateKey  //节点私钥，ID由其公钥确定
+	ids            map[string]*big.Int //已验证的其他节点ID
+	idLock         sync.RWMutex
 	block          sync.Mutex
 	start          chan bool
 	quit           chan bool
@@ -50,42 +150,181 @@ type Node struct {
 
 // 整体初始化
 func init() {
-	//f, _ := os.Create("DHT.log")
-	//logrus.SetOutput(f)
 	initCal()
 }
 
-// 节点初始化
+// 节点初始化(生成新的私钥作为节点身份)
 func (node *Node) Init(ip string) bool {
+	key, err := rpc.GenerateKey()
+	if err != nil {
+		logging.ForNode(node.logger, ip, nil).WithError(err).Error("Generating key error.")
+		return false
+	}
+	return node.InitWithKey(ip, key)
+}
+
+// 使用给定私钥初始化节点，节点ID由公钥确定，与地址无关
+func (node *Node) InitWithKey(ip string, key ed25519.PrivateKey) bool {
+	return node.InitWithConfig(ip, key, config.Default())
+}
+
+// 使用给定私钥与配置初始化节点(配置中的RPC与Chord部分，包括复制因子)，配置不合法时返回false
+func (node *Node) InitWithConfig(ip string, key ed25519.PrivateKey, config config.Config) bool {
+	err := config.Validate()
+	if err != nil {
+		logging.ForNode(node.logger, ip, nil).WithError(err).Error("Invalid config.")
+		return false
+	}
+	node.config = config.Chord
+	node.RPC.Config = config.RPC
 	node.Online = false
 	node.start = make(chan bool, 1)
 	node.quit = make(chan bool, 1)
 	node.predecessor = ""
 	node.IP = ip
-	node.ID = getHash(ip)
+	node.setKey(key)
+	node.idLock.Lock()
+	node.ids = make(map[string]*big.Int)
+	node.idLock.Unlock()
+	node.merge = version.LastWriteWins
+	node.iterative = node.config.IterativeLookup
 	node.fixIndex = 2
-	for i := 2; i <= 160; i++ {
-		node.fingerStart[i] = cal(node.ID, i-1)
-	}
 	node.fingerLock.Lock()
 	for i := range node.finger {
 		node.finger[i] = node.IP
 	}
 	node.fingerLock.Unlock()
 	node.sucLock.Lock()
+	node.replica = node.config.Replica
+	node.replicas = nil
+	node.successorList = make([]string, successorListSize(node.replica))
 	for i := range node.successorList {
 		node.successorList[i] = node.IP
 	}
 	node.sucLock.Unlock()
 	node.dataLock.Lock()
-	node.data = make(map[string]ValuePair)
+	node.data = NewMemoryStorage()
 	node.dataLock.Unlock()
 	node.dataBackupLock.Lock()
-	node.dataBackup = make(map[string]ValuePair)
+	node.dataBackup = NewMemoryStorage()
 	node.dataBackupLock.Unlock()
+	node.stabilizeSched = newSchedule("stabilize", time.Duration(node.config.StabilizeInterval), time.Duration(node.config.MaxStabilizeInterval), stabilizeSettleRounds, stabilizeMessages, &node.maintainStats)
+	node.fixFingerSched = newSchedule("fix_finger", time.Duration(node.config.FixFingerInterval), time.Duration(node.config.MaxFixFingerInterval), fixFingerSettleRounds, fixFingerMessages, &node.maintainStats)
+	node.registry = node.newRegistry()
 	return true
 }
 
+// 设置复制因子r(需在Init之后、Run之前调用，也可由config.Chord.Replica给出)，主数据复制到前r个后继，网络中各节点应使用相同的r
+func (node *Node) SetReplica(r int) error {
+	if r < 1 {
+		return errors.New("Replication factor should be positive.")
+	}
+	node.sucLock.Lock()
+	node.config.Replica = r
+	node.replica = r
+	node.successorList = make([]string, successorListSize(r))
+	for i := range node.successorList {
+		node.successorList[i] = node.IP
+	}
+	node.sucLock.Unlock()
+	return nil
+}
+
+// 后继列表长度：至少为3，且比r多1(正常退出时，后继需要把数据复制到第r+1个后继)
+func successorListSize(r int) int {
+	if r+1 > minSuccessorListSize {
+		return r + 1
+	}
+	return minSuccessorListSize
+}
+
+// 设置合并函数(需在Init之后调用)，用于由并发写入产生的多个版本得到一个值，默认取写入时间最晚的值
+func (node *Node) SetMerge(merge version.MergeFunc) {
+	node.merge = merge
+}
+
+// 由当前节点发起的成功查找的跳数直方图
+func (node *Node) Hops() *trace.Histogram {
+	return &node.hops
+}
+
+// 查找后继时使用迭代模式(只影响由当前节点发起的查找，也可由config.Chord.IterativeLookup给出)：由当前节点依次询问每一跳，无应答的节点被绕开，而不会阻塞整条转发链
+func (node *Node) SetIterativeLookup(iterative bool) {
+	node.config.IterativeLookup = iterative
+	node.iterative = iterative
+}
+
+// 使用保持顺序的键空间(需在Init之后、Run之前调用，网络中所有节点需相同)：键ID由键的前20字节得到而非hash值，
+// 相邻的键存放在相邻的节点上，可以通过Scan按顺序读取；代价是数据在节点之间的分布可能不均匀
+func (node *Node) SetOrderPreserving(ordered bool) {
+	node.ordered = ordered
+}
+
+// 设置节点的日志(Init之前或之后调用均可)，日志带有节点地址与ID字段，为空时使用logrus的标准日志
+func (node *Node) SetLogger(logger *logrus.Logger) {
+	node.logger = logger
+	if node.ID != nil {
+		node.log = logging.ForNode(logger, node.IP, node.ID)
+		node.RPC.Log = node.log
+	}
+}
+
+// 节点的日志
+func (node *Node) Logger() *logrus.Entry {
+	return logging.OrDefault(node.log)
+}
+
+// 得到键的ID
+func (node *Node) keyId(key string) *big.Int {
+	if node.ordered {
+		return orderedId(key)
+	}
+	return getHash(key)
+}
+
+// 更换节点的存储(需在Init之后、Run之前调用)，存储中已有的数据会被保留
+func (node *Node) SetStorage(data, dataBackup Storage) {
+	node.dataLock.Lock()
+	node.data.Close()
+	node.data = data
+	node.dataLock.Unlock()
+	node.dataBackupLock.Lock()
+	node.dataBackup.Close()
+	node.dataBackup = dataBackup
+	node.dataBackupLock.Unlock()
+}
+
+// 使用dir中的磁盘存储(需在Init之后、Run之前调用)。私钥同样保存在dir中(不存在时生成)，
+// 同一地址的节点重启后ID不变，重新载入的主数据与备份数据仍属于其负责的范围
+func (node *Node) UseDiskStorage(dir string) error {
+	if node.ID == nil {
+		return errors.New("Node should be initialized before using disk storage.")
+	}
+	if node.Online {
+		return errors.New("Disk storage should be used before Run.")
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	key, err := rpc.LoadOrGenerateKey(filepath.Join(dir, KeyFile))
+	if err != nil {
+		return err
+	}
+	data, err := NewDiskStorage(filepath.Join(dir, "data"))
+	if err != nil {
+		return err
+	}
+	dataBackup, err := NewDiskStorage(filepath.Join(dir, "backup"))
+	if err != nil {
+		data.Close()
+		return err
+	}
+	node.setKey(key)
+	node.SetStorage(data, dataBackup)
+	return nil
+}
+
 // 节点开始运作
 func (node *Node) Run() {
 	node.Online = true
@@ -96,15 +335,16 @@ func (node *Node) Run() {
 // 创建DHT网络(加入第一个节点)
 func (node *Node) Create() {
 	rand.Seed(time.Now().UnixNano())
-	//logrus.Infof("Create a new DHT net.")
+	node.log.Info("Create a new DHT net.")
 	node.preLock.Lock()
 	node.predecessor = node.IP
 	node.preLock.Unlock()
 	select { // 阻塞直至run()完成
 	case <-node.start:
-		//logrus.Infof("New node (IP = %s, ID = %v) joins in.", node.IP, node.ID)
+		node.log.Info("New node joins in.")
 	}
 	node.maintain()
+	go node.migrate()
 }
 
 // 向DHT网络中加入节点
@@ -115,20 +355,63 @@ func (node *Node) Join(ip string) bool {
 	successor := ""
 	select { // 阻塞直至run()完成
 	case <-node.start:
-		//logrus.Infof("New node (IP = %s, ID = %v) joins in.", node.IP, node.ID)
+		node.log.Info("New node joins in.")
 	}
 	err := node.RPC.RemoteCall(ip, "Chord.FindSuccessor", node.ID, &successor)
 	if err != nil {
-		//logrus.Errorf("Join error (IP = %s): %v.", node.IP, err)
+		node.log.WithField("target", ip).WithError(err).Error("Join error.")
 		return false
 	}
 	node.sucLock.Lock()
 	node.successorList[0] = successor
 	node.sucLock.Unlock()
 	node.maintain()
+	go node.migrate()
 	return true
 }
 
+// 将磁盘存储中旧格式(模式编码在键的末尾)的数据迁移为显式的模式字段：旧格式的主数据去掉键末尾的模式编码后重新存入
+// (负责节点可能改变)，成功后删去；旧格式的备份数据直接删去，由新的主节点重新复制
+func (node *Node) migrate() {
+	node.dataBackupLock.Lock()
+	for _, dataPair := range node.dataBackup.All() {
+		if dataPair.Values.Format == 0 {
+			node.dataBackup.Delete(dataPair.Key)
+		}
+	}
+	node.dataBackupLock.Unlock()
+	legacy := []DataPair{}
+	node.dataLock.RLock()
+	for _, dataPair := range node.data.All() {
+		if dataPair.Values.Format == 0 {
+			legacy = append(legacy, dataPair)
+		}
+	}
+	node.dataLock.RUnlock()
+	for _, dataPair := range legacy {
+		key := dataPair.Key
+		mode, ok := "", false
+		if key != "" {
+			mode, ok = legacyModes[key[len(key)-1:]]
+		}
+		if !ok {
+			continue //无法识别的数据保留原样
+		}
+		valuePair := ValuePair{Value: dataPair.Values.Value, Versions: dataPair.Values.Versions, ExpireTime: dataPair.Values.ExpireTime}
+		err := node.putMode(context.Background(), key[:len(key)-1], mode, valuePair, rpc.ONE)
+		if err != nil {
+			node.log.WithField("key", key).WithError(err).Error("Migrating data error.")
+			continue
+		}
+		node.dataLock.Lock()
+		valueNow, ok := node.data.Get(key)
+		if ok && valueNow.Format == 0 {
+			node.data.Delete(key)
+		}
+		node.dataLock.Unlock()
+	}
+}
+
 // 正常退出DHT网络(通知其他节点)
 func (node *Node) Quit() {
 	if !node.Online {
@@ -138,13 +421,8 @@ func (node *Node) Quit() {
 	node.preLock.RLock()
 	predecessor := node.predecessor
 	node.preLock.RUnlock()
-	node.updateSuccessorList()
-	var successorList [3]string
-	node.sucLock.RLock()
-	successorList[0] = node.successorList[0]
-	successorList[1] = node.successorList[1]
-	successorList[2] = node.successorList[2]
-	node.sucLock.RUnlock()
+	node.updateSuccessorList(context.Background())
+	successorList := node.GetSuccessorList()
 	var wg sync.WaitGroup
 	wg.Add(4)
 	go func() {
@@ -163,27 +441,28 @@ func (node *Node) Quit() {
 		node.RPC.RemoteCall(successorList[0], "Chord.ChangePredecessor", predecessor, &Null{})
 		wg.Done()
 	}()
-	go func() { //转移主数据
+	go func() { //转移主数据：后继成为主节点，第r+1个后继补上一份备份
 		node.dataLock.RLock()
-		data := []DataPair{}
+		data := node.data.All()
+		node.dataLock.RUnlock()
 		keys := []string{}
-		for key, valuePair := range node.data {
-			data = append(data, DataPair{key, valuePair})
-			keys = append(keys, key)
+		for _, dataPair := range data {
+			keys = append(keys, dataPair.Key)
 		}
-		node.dataLock.RUnlock()
-		node.RPC.RemoteCall(successorList[0], "Chord.PutInAll", data, &Null{})
+		node.RPC.RemoteCall(successorList[0], "Chord.SetIn", data, &Null{})
 		node.RPC.RemoteCall(successorList[0], "Chord.DeleteOffBackup", keys, &Null{})
+		if successorList[node.replica] != node.IP && successorList[node.replica] != successorList[0] {
+			node.RPC.RemoteCall(successorList[node.replica], "Chord.SetInBackup", data, &Null{})
+		}
 		wg.Done()
 	}()
-	go func() { //转移备份
+	go func() { //转移备份：前r个后继均补上一份(多余的备份不影响正确性)
 		node.dataBackupLock.RLock()
-		dataBackup := []DataPair{}
-		for key, valuePair := range node.dataBackup {
-			dataBackup = append(dataBackup, DataPair{key, valuePair})
-		}
+		dataBackup := node.dataBackup.All()
 		node.dataBackupLock.RUnlock()
-		node.RPC.RemoteCall(successorList[0], "Chord.PutInBackup", dataBackup, &Null{})
+		for _, ip := range distinctReplicas(successorList[:node.replica], node.IP) {
+			node.RPC.RemoteCall(ip, "Chord.SetInBackup", dataBackup, &Null{})
+		}
 		wg.Done()
 	}()
 	wg.Wait()
@@ -195,7 +474,17 @@ func (node *Node) Quit() {
 	}
 	node.Online = false
 	close(node.quit)
-	//logrus.Infof("Node (IP = %s, ID = %v) quits.", node.IP, node.ID)
+	if successorList[0] != node.IP { //数据已转移给后继，清空本地存储，防止重启后载入过期数据
+		node.dataLock.Lock()
+		node.data.Clear()
+		node.dataLock.Unlock()
+		node.dataBackupLock.Lock()
+		node.dataBackup.Clear()
+		node.dataBackupLock.Unlock()
+	}
+	node.closeStorage()
+	node.closeHTTP()
+	node.log.Info("Node quits.")
 }
 
 // 强制退出DHT网络(未通知其他节点)
@@ -205,217 +494,715 @@ func (node *Node) ForceQuit() {
 	}
 	node.Online = false
 	close(node.quit)
-	//logrus.Infof("Node (IP = %s, ID = %v) force quits.", node.IP, node.ID)
+	node.closeStorage()
+	node.closeHTTP()
+	node.log.Info("Node force quits.")
+}
+
+// 关闭存储(磁盘存储会将日志写入磁盘)
+func (node *Node) closeStorage() {
+	node.dataLock.Lock()
+	node.data.Close()
+	node.dataLock.Unlock()
+	node.dataBackupLock.Lock()
+	node.dataBackup.Close()
+	node.dataBackupLock.Unlock()
 }
 
 // 存入数据（默认Put，覆盖）
 func (node *Node) Put(key, value string) bool {
-	key = key + OVERWRITE
-	id := getHash(key)
-	ip, _ := node.FindSuccessor(id)
-	err := node.RPC.RemoteCall(ip, "Chord.PutInAll", []DataPair{{key, ValuePair{value, id}}}, &Null{})
-	if err != nil {
-		//logrus.Errorf("Node (IP = %s) putting in data error (key = %s, value = %s): %v.", node.IP, key, value, err)
-		return false
-	}
-	//logrus.Infof("Node (IP = %s) puts in data : key = %s, value = %s.", node.IP, key, value)
-	return true
+	return node.putMode(context.Background(), key, modeOverwrite, ValuePair{Value: value}, rpc.ONE) == nil
+}
+
+// 存入数据(覆盖)，整个操作服从ctx的截止时间与取消
+func (node *Node) PutContext(ctx context.Context, key, value string) error {
+	return node.putMode(ctx, key, modeOverwrite, ValuePair{Value: value}, rpc.ONE)
+}
+
+// 存入数据(覆盖)，经过ttl后过期(ttl不为正时永不过期)
+func (node *Node) PutTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	return node.putMode(ctx, key, modeOverwrite, ValuePair{Value: value, ExpireTime: expireTime(ttl)}, rpc.ONE)
+}
+
+// 存入数据(覆盖)，主节点与其前r个后继中至少有level要求数量的副本写入成功。
+// 不满足时返回错误，但已写入的副本不会回滚，之后的读取仍可能读到该值
+func (node *Node) PutConsistency(ctx context.Context, key, value string, level rpc.Consistency) error {
+	return node.putMode(ctx, key, modeOverwrite, ValuePair{Value: value}, level)
+}
+
+// 基于读取时得到的时钟context写入新版本(覆盖模式)，与其他节点的并发写入互不覆盖，读取时得到多个版本
+func (node *Node) PutVersion(ctx context.Context, key, value string, context version.Clock) error {
+	versions := version.Siblings{{Value: value, Clock: context.Increment(node.IP), Time: time.Now().UnixNano()}}
+	return node.putMode(ctx, key, modeOverwrite, ValuePair{Value: value, Versions: versions}, rpc.ONE)
 }
 
 // 存入数据（可设置模式 覆盖overwrite/添加append）
 func (node *Node) PutMode(key, value, mode string) bool {
-	key = key + GetMode(mode)
-	id := getHash(key)
-	ip, _ := node.FindSuccessor(id)
-	err := node.RPC.RemoteCall(ip, "Chord.PutInAll", []DataPair{{key, ValuePair{value, id}}}, &Null{})
+	return node.putMode(context.Background(), key, CheckMode(mode), ValuePair{Value: value}, rpc.ONE) == nil
+}
+
+// 存入数据(可设置模式)，整个操作服从ctx的截止时间与取消
+func (node *Node) PutModeContext(ctx context.Context, key, value, mode string) error {
+	return node.putMode(ctx, key, CheckMode(mode), ValuePair{Value: value}, rpc.ONE)
+}
+
+// 存入数据(可设置模式)，经过ttl后整条数据过期(ttl不为正时永不过期)
+func (node *Node) PutModeTTL(key, value, mode string, ttl time.Duration) bool {
+	return node.putMode(context.Background(), key, CheckMode(mode), ValuePair{Value: value, ExpireTime: expireTime(ttl)}, rpc.ONE) == nil
+}
+
+// 键不存在时存入数据(覆盖模式)，否则返回rpc.ErrConditionFailed，由主节点原子地判断与写入
+func (node *Node) PutIfAbsent(ctx context.Context, key, value string) error {
+	_, err := node.putCondition(ctx, ConditionPair{Key: key, Condition: IF_ABSENT, Value: value})
+	return err
+}
+
+// 当前值为expected时存入value(覆盖模式)，否则返回rpc.ErrConditionFailed
+func (node *Node) CompareAndSwap(ctx context.Context, key, expected, value string) error {
+	_, err := node.putCondition(ctx, ConditionPair{Key: key, Condition: IF_EQUAL, Expected: expected, Value: value})
+	return err
+}
+
+// 将计数器加上delta(键不存在时视为0)，返回加后的值
+func (node *Node) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	value, err := node.putCondition(ctx, ConditionPair{Key: key, Condition: INCREMENT, Delta: delta})
 	if err != nil {
-		//logrus.Errorf("Node (IP = %s) putting in data error (key = %s, value = %s): %v.", node.IP, key, value, err)
-		return false
+		return 0, err
 	}
-	//logrus.Infof("Node (IP = %s) puts in data : key = %s, value = %s.", node.IP, key, value)
-	return true
+	return strconv.ParseInt(value, 10, 64)
 }
 
 // 查询数据 (默认模式，覆盖）
-func (node *Node) Get(key string) (ok bool, value string) {
-	key = key + OVERWRITE
-	id := getHash(key)
-	node.preLock.RLock()
-	pre := node.predecessor
-	node.preLock.RUnlock()
-	if belong(false, true, getHash(pre), node.ID, id) {
-		value, ok = node.GetOut(key)
-	} else {
-		ip, _ := node.FindSuccessor(id)
-		err := node.RPC.RemoteCall(ip, "Chord.GetOut", key, &value)
-		if err != nil {
-			//logrus.Errorf("Node (IP = %s) getting out data error (key = %s, value = %s): %v.", node.IP, key, value, err)
-			return false, ""
-		}
-		ok = true
-	}
-	if ok {
-		//logrus.Infof("Node (IP = %s) gets out data : key = %s, value = %s.", node.IP, key, value)
-	}
-	return ok, value
+func (node *Node) Get(key string) (bool, string) {
+	value, err := node.getMode(context.Background(), key, modeOverwrite, rpc.ONE)
+	return err == nil, value
+}
+
+// 查询数据(覆盖)，整个操作服从ctx的截止时间与取消
+func (node *Node) GetContext(ctx context.Context, key string) (string, error) {
+	return node.getMode(ctx, key, modeOverwrite, rpc.ONE)
+}
+
+// 查询数据(覆盖)，读取主节点与其前r个后继，应答的副本数满足level要求时，合并各副本返回的所有版本，按合并函数给出结果
+func (node *Node) GetConsistency(ctx context.Context, key string, level rpc.Consistency) (string, error) {
+	return node.getMode(ctx, key, modeOverwrite, level)
+}
+
+// 查询数据的各个版本(覆盖模式)，其时钟的合并可作为PutVersion的context
+func (node *Node) GetVersions(ctx context.Context, key string) (version.Siblings, error) {
+	return node.getVersions(ctx, key, modeOverwrite, rpc.ONE)
 }
 
 // 查询数据（可设置模式 覆盖overwrite/添加append）
-func (node *Node) GetMode(key, mode string) (ok bool, value string) {
-	key = key + GetMode(mode)
-	id := getHash(key)
-	node.preLock.RLock()
-	pre := node.predecessor
-	node.preLock.RUnlock()
-	if belong(false, true, getHash(pre), node.ID, id) {
-		value, ok = node.GetOut(key)
-	} else {
-		ip, _ := node.FindSuccessor(id)
-		err := node.RPC.RemoteCall(ip, "Chord.GetOut", key, &value)
-		if err != nil {
-			//logrus.Errorf("Node (IP = %s) getting out data error (key = %s, value = %s): %v.", node.IP, key, value, err)
-			return false, ""
-		}
-		ok = true
-	}
-	if ok {
-		//logrus.Infof("Node (IP = %s) gets out data : key = %s, value = %s.", node.IP, key, value)
-	}
-	return ok, value
+func (node *Node) GetMode(key, mode string) (bool, string) {
+	value, err := node.getMode(context.Background(), key, CheckMode(mode), rpc.ONE)
+	return err == nil, value
+}
+
+// 查询数据(可设置模式)，整个操作服从ctx的截止时间与取消
+func (node *Node) GetModeContext(ctx context.Context, key, mode string) (string, error) {
+	return node.getMode(ctx, key, CheckMode(mode), rpc.ONE)
 }
 
 // 删除数据（默认模式 覆盖overwrite）
 func (node *Node) Delete(key string) bool {
-	key = key + OVERWRITE
-	id := getHash(key)
-	ip, _ := node.FindSuccessor(id)
-	err := node.RPC.RemoteCall(ip, "Chord.DeleteOffAll", []string{key}, &Null{})
-	if err != nil {
-		//logrus.Errorf("Node (IP = %s) deleting off data error (key = %s): %v.", node.IP, key, err)
-		return false
-	}
-	//logrus.Infof("Node (IP = %s) delete off data : key = %s.", node.IP, key)
-	return true
+	return node.deleteMode(context.Background(), key, modeOverwrite) == nil
+}
+
+// 删除数据(覆盖)，整个操作服从ctx的截止时间与取消
+func (node *Node) DeleteContext(ctx context.Context, key string) error {
+	return node.deleteMode(ctx, key, modeOverwrite)
 }
 
 // 删除数据（可设置模式 覆盖overwrite/添加append）
 func (node *Node) DeleteMode(key, mode string) bool {
-	key = key + GetMode(mode)
-	id := getHash(key)
-	ip, _ := node.FindSuccessor(id)
-	err := node.RPC.RemoteCall(ip, "Chord.DeleteOffAll", []string{key}, &Null{})
-	if err != nil {
-		//logrus.Errorf("Node (IP = %s) deleting off data error (key = %s): %v.", node.IP, key, err)
-		return false
-	}
-	//logrus.Infof("Node (IP = %s) delete off data : key = %s.", node.IP, key)
-	return true
+	return node.deleteMode(context.Background(), key, CheckMode(mode)) == nil
 }
 
-// 找某个地址id的后继节点
-func (node *Node) FindSuccessor(id *big.Int) (ip string, err error) {
-	if id.Cmp(node.ID) == 0 { //当前节点即位目标后继，结束
-		ip = node.IP
-		return ip, nil
+// 删除数据(可设置模式)，整个操作服从ctx的截止时间与取消
+func (node *Node) DeleteModeContext(ctx context.Context, key, mode string) error {
+	return node.deleteMode(ctx, key, CheckMode(mode))
+}
+
+// 批量存入数据(覆盖)，按负责节点分组，每个节点只调用一次，返回每个键的结果(成功为nil)
+func (node *Node) MultiPut(ctx context.Context, data map[string]string) map[string]error {
+	keys := []string{}
+	for key := range data {
+		keys = append(keys, key)
 	}
-	pre, err := node.FindPredecessor(id)
+	return node.multiCall(ctx, keys, func(ip string, keys []string) []error {
+		pairs := []DataPair{}
+		for _, key := range keys {
+			pairs = append(pairs, DataPair{key, ValuePair{Value: data[key], KeyId: node.keyId(key), Mode: modeOverwrite}})
+		}
+		messages := []string{}
+		err := node.RPC.RemoteCallContext(ctx, ip, "Chord.PutInMulti", pairs, &messages)
+		return decodeErrors(messages, len(keys), err)
+	})
+}
+
+// 批量查询数据(覆盖)，返回查到的值与每个键的结果(成功为nil)
+func (node *Node) MultiGet(ctx context.Context, keys []string) (map[string]string, map[string]error) {
+	values := make(map[string]string)
+	var lock sync.Mutex
+	errs := node.multiCall(ctx, keys, func(ip string, keys []string) []error {
+		pairs := []KeyModePair{}
+		for _, key := range keys {
+			pairs = append(pairs, KeyModePair{key, modeOverwrite})
+		}
+		found := []version.Siblings{}
+		err := node.RPC.RemoteCallContext(ctx, ip, "Chord.GetOutMulti", pairs, &found)
+		out := decodeErrors(nil, len(keys), err)
+		if err != nil {
+			return out
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		for i, key := range keys {
+			if i >= len(found) || len(found[i]) == 0 {
+				out[i] = rpc.ErrNotFound
+			} else {
+				values[key] = found[i].Resolve(node.merge)
+			}
+		}
+		return out
+	})
+	return values, errs
+}
+
+// 批量删除数据(覆盖)，返回每个键的结果(成功为nil)
+func (node *Node) MultiDelete(ctx context.Context, keys []string) map[string]error {
+	return node.multiCall(ctx, keys, func(ip string, keys []string) []error {
+		pairs := []KeyModePair{}
+		for _, key := range keys {
+			pairs = append(pairs, KeyModePair{key, modeOverwrite})
+		}
+		messages := []string{}
+		err := node.RPC.RemoteCallContext(ctx, ip, "Chord.DeleteOffMulti", pairs, &messages)
+		return decodeErrors(messages, len(keys), err)
+	})
+}
+
+// 按模式与一致性级别存入数据
+func (node *Node) putMode(ctx context.Context, key, mode string, valuePair ValuePair, level rpc.Consistency) error {
+	valuePair.KeyId = node.keyId(key)
+	valuePair.Mode = mode
+	ip, err := node.FindSuccessorContext(ctx, valuePair.KeyId)
 	if err != nil {
-		//logrus.Errorf("FindSuccessor error (IP = %s): %v.", node.IP, err)
-		return "", err
+		node.log.WithField("key", key).WithError(err).Warn("Putting in data error.")
+		return rpc.RouteError(err)
 	}
-	err = node.RPC.RemoteCall(pre, "Chord.GetSuccessor", Null{}, &ip)
+	err = node.RPC.RemoteCallContext(ctx, ip, "Chord.PutInAllLevel", LevelDataPairs{[]DataPair{{key, valuePair}}, level}, &Null{})
 	if err != nil {
-		//logrus.Errorf("FindSuccessor error (IP = %s): %v.", node.IP, err)
-		return "", err
+		node.log.WithField("key", key).WithError(err).Warn("Putting in data error.")
+		return err
 	}
-	return ip, nil
+	node.log.WithField("key", key).Debug("Puts in data.")
+	return nil
 }
 
-// 找某个地址id的前驱节点
-func (node *Node) FindPredecessor(id *big.Int) (ip string, err error) {
-	ip = node.IP
-	node.sucLock.RLock()
-	successorId := getHash(node.successorList[0])
-	node.sucLock.RUnlock()
-	if !belong(false, true, node.ID, successorId, id) {
-		err = node.RPC.RemoteCall(node.closestPrecedingFinger(id), "Chord.FindPredecessor", id, &ip)
+// 按键的顺序读取[startKey, endKey)中的数据(endKey为空时无上界)，至多limit条(不为正时不限数量)，需使用保持顺序的键空间。
+// 从负责startKey的节点开始沿后继依次询问，每个节点只给出其负责的ID区间内的数据，因此结果已按键排序
+func (node *Node) Scan(ctx context.Context, startKey, endKey string, limit int) ([]ScanPair, error) {
+	if !node.ordered {
+		return nil, errors.New("Scan requires the order-preserving key space.")
+	}
+	out := []ScanPair{}
+	id := orderedId(startKey)
+	endId := new(big.Int).Sub(exp[160], big.NewInt(1))
+	if endKey != "" {
+		if endKey <= startKey {
+			return out, nil
+		}
+		endId = orderedId(endKey)
+	}
+	ip, err := node.FindSuccessorContext(ctx, id)
+	if err != nil {
+		node.log.WithField("start", startKey).WithError(err).Warn("Scanning error.")
+		return out, rpc.RouteError(err)
+	}
+	for {
+		segmentEnd, err := node.getId(ip)
+		if err != nil {
+			node.log.WithField("target", ip).WithError(err).Warn("Scanning error.")
+			return out, err
+		}
+		if segmentEnd.Cmp(id) < 0 { //越过环的零点，该节点负责之后的全部ID
+			segmentEnd = new(big.Int).Sub(exp[160], big.NewInt(1))
+		}
+		if segmentEnd.Cmp(endId) > 0 {
+			segmentEnd = endId
+		}
+		rest := 0
+		if limit > 0 {
+			rest = limit - len(out)
+		}
+		pairs := []ScanPair{}
+		err = node.RPC.RemoteCallContext(ctx, ip, "Chord.ScanOut", ScanRange{startKey, endKey, id, segmentEnd, rest}, &pairs)
 		if err != nil {
-			//logrus.Errorf("FindPredecessor error (IP = %s): %v.", node.IP, err)
-			return "", err
+			node.log.WithField("target", ip).WithError(err).Warn("Scanning error.")
+			return out, err
+		}
+		out = append(out, pairs...)
+		if (limit > 0 && len(out) >= limit) || segmentEnd.Cmp(endId) >= 0 {
+			return out, nil
+		}
+		id = new(big.Int).Add(segmentEnd, big.NewInt(1))
+		err = node.RPC.RemoteCallContext(ctx, ip, "Chord.GetSuccessor", Null{}, &ip)
+		if err != nil {
+			node.log.WithField("target", ip).WithError(err).Warn("Scanning error.")
+			return out, err
 		}
 	}
-	return ip, nil
 }
 
-// 得到某节点的后继(同时顺带更新successorList)
-func (node *Node) GetSuccessor() (ip string, err error) {
-	node.updateSuccessorList()
-	node.sucLock.Lock()
-	defer node.sucLock.Unlock()
-	return node.successorList[0], nil
+// 按键的顺序读取以prefix为前缀的数据，至多limit条
+func (node *Node) ScanPrefix(ctx context.Context, prefix string, limit int) ([]ScanPair, error) {
+	return node.Scan(ctx, prefix, prefixEnd(prefix), limit)
 }
 
-// 得到某节点的前驱(同时顺带监测前驱是否异常下线，若发现则利用节点的备份数据进行数据恢复)
-func (node *Node) GetPredecessor() (string, error) {
+// 由负责该键的主节点执行条件写入，返回写入后的值
+func (node *Node) putCondition(ctx context.Context, pair ConditionPair) (value string, err error) {
+	pair.KeyId = node.keyId(pair.Key)
+	ip, err := node.FindSuccessorContext(ctx, pair.KeyId)
+	if err != nil {
+		node.log.WithField("key", pair.Key).WithError(err).Warn("Putting in data error.")
+		return "", rpc.RouteError(err)
+	}
+	err = node.RPC.RemoteCallContext(ctx, ip, "Chord.PutInCondition", pair, &value)
+	if err != nil {
+		node.log.WithField("key", pair.Key).WithError(err).Warn("Putting in data error.")
+		return "", err
+	}
+	node.log.WithField("key", pair.Key).Debug("Puts in data.")
+	return value, nil
+}
+
+// 按模式与一致性级别查询数据，多个版本按合并函数得到一个值
+func (node *Node) getMode(ctx context.Context, key, mode string, level rpc.Consistency) (string, error) {
+	versions, err := node.getVersions(ctx, key, mode, level)
+	if err != nil {
+		return "", err
+	}
+	return versions.Resolve(node.merge), nil
+}
+
+// 按模式与一致性级别查询数据的各个版本
+func (node *Node) getVersions(ctx context.Context, key, mode string, level rpc.Consistency) (versions version.Siblings, err error) {
+	id := node.keyId(key)
 	node.preLock.RLock()
-	predecessor := node.predecessor
+	pre := node.predecessor
 	node.preLock.RUnlock()
-	if predecessor != "" && !node.Ping(predecessor) {
-		node.preLock.RLock()
-		node.preLock.RUnlock()
-		node.preLock.Lock()
-		node.predecessor = "OFFLINE" //若前驱已下线，置上标记
-		predecessor = "OFFLINE"
-		node.preLock.Unlock()
-		dataBackup := []DataPair{}
-		node.dataBackupLock.Lock()
-		for key, valuePair := range node.dataBackup {
-			dataBackup = append(dataBackup, DataPair{key, valuePair})
+	if level != rpc.ONE {
+		ip, err := node.FindSuccessorContext(ctx, id)
+		if err != nil {
+			node.log.WithField("key", key).WithError(err).Warn("Getting out data error.")
+			return nil, rpc.RouteError(err)
 		}
-		for _, dataPair := range dataBackup {
-			delete(node.dataBackup, dataPair.Key) //从备份中删去
+		err = node.RPC.RemoteCallContext(ctx, ip, "Chord.GetOutLevel", LevelKeyPair{key, mode, level}, &versions)
+		if err != nil {
+			node.log.WithField("key", key).WithError(err).Debug("Getting out data error.")
+			return nil, err
 		}
-		node.dataBackupLock.Unlock()
-		node.dataLock.Lock()
-		for _, dataPair := range dataBackup {
-			node.data[dataPair.Key] = dataPair.Values //加入主数据
+	} else if preId, err := node.getId(pre); err == nil && belong(false, true, preId, node.ID, id) {
+		ok := false
+		versions, ok = node.GetOutVersions(key, mode)
+		if !ok {
+			return nil, rpc.ErrNotFound
 		}
-		node.dataLock.Unlock()
-		successor, err := node.GetSuccessor()
+	} else {
+		ip, err := node.FindSuccessorContext(ctx, id)
 		if err != nil {
-			//logrus.Errorf("Restoring data error (IP = %s): %v.", node.IP, err)
-			return "OFFLINE", err
+			node.log.WithField("key", key).WithError(err).Warn("Getting out data error.")
+			return nil, rpc.RouteError(err)
 		}
-		err = node.RPC.RemoteCall(successor, "Chord.PutInBackup", dataBackup, &Null{})
+		err = node.RPC.RemoteCallContext(ctx, ip, "Chord.GetOutVersions", KeyModePair{key, mode}, &versions)
 		if err != nil {
-			//logrus.Errorf("Restoring data error (IP = %s): %v.", node.IP, err)
-			return "OFFLINE", err
+			node.log.WithField("key", key).WithError(err).Debug("Getting out data error.")
+			return nil, err
 		}
 	}
-	return predecessor, nil
+	node.log.WithField("key", key).Debug("Gets out data.")
+	return versions, nil
 }
 
-// 找到路由表中在目标位置前最近的上线节点
-func (node *Node) closestPrecedingFinger(id *big.Int) string {
-	for i := 160; i > 1; i-- {
-		if !node.Ping(node.finger[i]) { //已下线，将finger设为仍然上线的位置
-			if i == 160 {
-				node.finger[i] = node.IP
-			} else {
-				node.finger[i] = node.finger[i+1]
+// 按模式删除数据
+func (node *Node) deleteMode(ctx context.Context, key, mode string) error {
+	id := node.keyId(key)
+	ip, err := node.FindSuccessorContext(ctx, id)
+	if err != nil {
+		node.log.WithField("key", key).WithError(err).Warn("Deleting off data error.")
+		return rpc.RouteError(err)
+	}
+	err = node.RPC.RemoteCallContext(ctx, ip, "Chord.DeleteOffAll", []KeyModePair{{key, mode}}, &Null{})
+	if err != nil {
+		node.log.WithField("key", key).WithError(err).Warn("Deleting off data error.")
+		return err
+	}
+	node.log.WithField("key", key).Debug("Deletes off data.")
+	return nil
+}
+
+// 将键按负责节点分组，并行地对每个节点调用一次call，汇总每个键的结果
+func (node *Node) multiCall(ctx context.Context, keys []string, call func(ip string, keys []string) []error) map[string]error {
+	groups, out := node.groupByOwner(ctx, keys)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(len(groups))
+	for ip, group := range groups {
+		go func(ip string, group []string) {
+			defer wg.Done()
+			errs := call(ip, group)
+			lock.Lock()
+			for i, key := range group {
+				out[key] = errs[i]
+			}
+			lock.Unlock()
+		}(ip, group)
+	}
+	wg.Wait()
+	return out
+}
+
+// 查找各个键的负责节点并分组(重复的键只保留一个)，查找失败的键的结果记入errs。
+// 查到负责节点后记下其负责的区间(前驱, 负责节点]，落在已知区间内的键不再查找
+func (node *Node) groupByOwner(ctx context.Context, keys []string) (groups map[string][]string, errs map[string]error) {
+	type ownerRange struct {
+		ip    string
+		preId *big.Int
+		id    *big.Int
+	}
+	groups = make(map[string][]string)
+	errs = make(map[string]error)
+	ranges := []ownerRange{}
+	for _, key := range keys {
+		if _, ok := errs[key]; ok {
+			continue
+		}
+		id := node.keyId(key)
+		ip := ""
+		for _, r := range ranges {
+			if belong(false, true, r.preId, r.id, id) {
+				ip = r.ip
+				break
+			}
+		}
+		if ip == "" {
+			var err error
+			ip, err = node.FindSuccessorContext(ctx, id)
+			if err != nil {
+				node.log.WithField("key", key).WithError(err).Warn("Finding owner error.")
+				errs[key] = rpc.RouteError(err)
+				continue
+			}
+			pre := ""
+			err = node.RPC.RemoteCallContext(ctx, ip, "Chord.GetPredecessor", Null{}, &pre)
+			if err == nil {
+				preId, errPre := node.getId(pre)
+				ipId, errIp := node.getId(ip)
+				if errPre == nil && errIp == nil {
+					ranges = append(ranges, ownerRange{ip, preId, ipId})
+				}
+			}
+		}
+		groups[ip] = append(groups[ip], key)
+		errs[key] = nil
+	}
+	return groups, errs
+}
+
+// 还原批量调用中每条的错误，调用本身失败时每条均为该错误
+func decodeErrors(messages []string, n int, err error) []error {
+	out := make([]error, n)
+	for i := range out {
+		if err != nil {
+			out[i] = err
+		} else if i < len(messages) {
+			out[i] = rpc.DecodeError(messages[i])
+		}
+	}
+	return out
+}
+
+// 找某个地址id的后继节点
+func (node *Node) FindSuccessor(id *big.Int) (ip string, err error) {
+	return node.FindSuccessorContext(context.Background(), id)
+}
+
+// 找某个地址id的后继节点(服从ctx的截止时间与取消)
+func (node *Node) FindSuccessorContext(ctx context.Context, id *big.Int) (ip string, err error) {
+	ip, _, err = node.FindSuccessorTrace(ctx, id)
+	return ip, err
+}
+
+// 找某个地址id的后继节点，并给出查找经过的各跳(成功的查找的跳数记入Hops直方图)
+func (node *Node) FindSuccessorTrace(ctx context.Context, id *big.Int) (ip string, tr *trace.Trace, err error) {
+	tr = trace.New()
+	defer tr.Finish()
+	if id.Cmp(node.ID) == 0 { //当前节点即位目标后继，结束
+		node.hops.Observe(0)
+		return node.IP, tr, nil
+	}
+	pre, hops, err := node.findPredecessor(ctx, id)
+	for _, hop := range hops {
+		tr.Add(hop)
+	}
+	if err != nil {
+		node.log.WithError(err).Warn("FindSuccessor error.")
+		return "", tr, err
+	}
+	err = node.RPC.RemoteCallContext(ctx, pre, "Chord.GetSuccessor", Null{}, &ip)
+	if err != nil {
+		node.log.WithError(err).Warn("FindSuccessor error.")
+		return "", tr, err
+	}
+	node.hops.Observe(tr.HopCount())
+	return ip, tr, nil
+}
+
+// 找某个地址id的前驱节点
+func (node *Node) FindPredecessor(id *big.Int) (ip string, err error) {
+	return node.FindPredecessorContext(context.Background(), id)
+}
+
+// 找某个地址id的前驱节点(服从ctx的截止时间与取消，截止时间随请求传给下一跳)
+func (node *Node) FindPredecessorContext(ctx context.Context, id *big.Int) (ip string, err error) {
+	ip, _, err = node.findPredecessor(ctx, id)
+	return ip, err
+}
+
+// 找某个地址id的前驱节点，并给出经过的各跳(不包括当前节点，轮次从下一跳算起)。
+// 递归查找时，每一跳的耗时为调用下一跳的耗时减去之后各跳的耗时
+func (node *Node) findPredecessor(ctx context.Context, id *big.Int) (ip string, hops []trace.Hop, err error) {
+	if node.iterative {
+		return node.findPredecessorIterative(ctx, id)
+	}
+	node.sucLock.RLock()
+	successor := node.successorList[0]
+	node.sucLock.RUnlock()
+	successorId, err := node.getId(successor)
+	if err != nil {
+		node.log.WithError(err).Warn("FindPredecessor error.")
+		return "", nil, err
+	}
+	if belong(false, true, node.ID, successorId, id) {
+		return node.IP, nil, nil
+	}
+	next, offline := node.closestPrecedingFinger(id)
+	for _, ipOffline := range offline {
+		hops = append(hops, trace.Hop{IP: ipOffline, Round: 1, Err: rpc.EncodeError(rpc.ErrOffline)})
+	}
+	deadline, _ := ctx.Deadline()
+	reply := PathReply{}
+	start := time.Now()
+	err = node.RPC.RemoteCallContext(ctx, next, "Chord.FindPredecessorTrace", IdDeadlinePair{id, deadline}, &reply)
+	hops = append(hops, trace.Hop{IP: next, Round: 1, Latency: time.Since(start) - trace.Latency(reply.Hops), Err: rpc.EncodeError(err)})
+	if err != nil {
+		node.log.WithError(err).Warn("FindPredecessor error.")
+		return "", hops, err
+	}
+	for _, hop := range reply.Hops {
+		hop.Round++
+		hops = append(hops, hop)
+	}
+	return reply.IP, hops, rpc.DecodeError(reply.Err)
+}
+
+// 迭代地找某个地址id的前驱节点：每次询问已知节点中距目标最近且未询问过的节点，由其路由表与后继列表得到新的候选节点。
+// 每一跳(包括验证新候选节点的ID)服从LookupHopTimeOut，无应答的节点直接绕开，改为询问下一个最近的候选节点；
+// 判断目标是否落在某节点与其后继之间时，跳过后继列表中无应答的节点(其后继列表可能尚未更新)
+func (node *Node) findPredecessorIterative(ctx context.Context, id *big.Int) (string, []trace.Hop, error) {
+	candidates := map[string]*big.Int{node.IP: node.ID}
+	asked := make(map[string]bool)
+	failed := make(map[string]bool)
+	replies := make(map[string][]string) //已应答节点的后继列表
+	hops := []trace.Hop{}
+	round := 1
+	for ctx.Err() == nil {
+		for ip, successors := range replies {
+			for _, successor := range successors {
+				if failed[successor] {
+					continue
+				}
+				if belong(false, true, candidates[ip], candidates[successor], id) {
+					return ip, hops, nil
+				}
+				break
 			}
-		} else if belong(false, false, node.ID, id, getHash(node.finger[i])) {
-			return node.finger[i]
 		}
+		ip := ""
+		var best *big.Int
+		for candidate, candidateId := range candidates {
+			if asked[candidate] || failed[candidate] {
+				continue
+			}
+			d := distance(candidateId, id)
+			if best == nil || d.Cmp(best) < 0 {
+				ip, best = candidate, d
+			}
+		}
+		if ip == "" {
+			return "", hops, rpc.ErrNoRoute
+		}
+		asked[ip] = true
+		reply := LookupReply{}
+		hopCtx, cancel := context.WithTimeout(ctx, LookupHopTimeOut)
+		if ip == node.IP {
+			reply = node.ClosestPreceding(id)
+		} else {
+			start := time.Now()
+			err := node.RPC.RemoteCallContext(hopCtx, ip, "Chord.ClosestPreceding", id, &reply)
+			hops = append(hops, trace.Hop{IP: ip, Round: round, Latency: time.Since(start), Err: rpc.EncodeError(err)})
+			if err != nil {
+				node.log.WithField("hop", ip).WithError(err).Debug("FindPredecessor error.")
+				failed[ip] = true
+				cancel()
+				continue
+			}
+			round++
+		}
+		successors := []string{}
+		for _, next := range append(reply.Fingers, reply.Successors...) {
+			if _, ok := candidates[next]; ok || failed[next] || next == "" || next == "OFFLINE" {
+				continue
+			}
+			nextId, err := node.verifyId(hopCtx, next)
+			if err != nil {
+				hops = append(hops, trace.Hop{IP: next, Round: round, Err: rpc.EncodeError(err)})
+				failed[next] = true
+				continue
+			}
+			candidates[next] = nextId
+		}
+		cancel()
+		for _, successor := range reply.Successors {
+			if _, ok := candidates[successor]; ok || failed[successor] {
+				successors = append(successors, successor)
+			}
+		}
+		replies[ip] = successors
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", hops, rpc.ErrTimeout
 	}
+	return "", hops, ctx.Err()
+}
+
+// 给出路由表中在目标位置前最近的若干节点，以及后继列表(用于迭代查找)。
+// 只使用已缓存的ID，不检测是否上线(下线节点的ID已从缓存中删去)，由查询方绕开无应答的节点
+func (node *Node) ClosestPreceding(id *big.Int) LookupReply {
+	node.fingerLock.RLock()
+	finger := node.finger
+	node.fingerLock.RUnlock()
+	reply := LookupReply{}
+	found := make(map[string]bool)
+	for i := 160; i >= 1 && len(reply.Fingers) < lookupFingers; i-- {
+		if finger[i] == "" || finger[i] == node.IP || found[finger[i]] {
+			continue
+		}
+		found[finger[i]] = true
+		fingerId, ok := node.cachedId(finger[i])
+		if ok && belong(false, false, node.ID, id, fingerId) {
+			reply.Fingers = append(reply.Fingers, finger[i])
+		}
+	}
+	reply.Successors = node.GetSuccessorList()
+	return reply
+}
+
+// 得到某节点的后继(同时顺带更新successorList)
+func (node *Node) GetSuccessor() (ip string, err error) {
+	node.updateSuccessorList(context.Background())
 	node.sucLock.Lock()
 	defer node.sucLock.Unlock()
-	if !node.Ping(node.successorList[0]) { //已下线，将finger设为仍然上线的位置
-		node.successorList[0] = node.IP
-	} else if belong(false, false, node.ID, id, getHash(node.successorList[0])) {
-		return node.successorList[0]
+	return node.successorList[0], nil
+}
+
+// 得到某节点的前驱(同时顺带监测前驱是否异常下线，若发现则置上标记，待新前驱通知时再利用备份数据进行恢复)
+func (node *Node) GetPredecessor() (string, error) {
+	node.preLock.RLock()
+	predecessor := node.predecessor
+	node.preLock.RUnlock()
+	if predecessor != "" && predecessor != "OFFLINE" && !node.Ping(predecessor) {
+		node.preLock.Lock()
+		if node.predecessor == predecessor {
+			node.predecessor = "OFFLINE" //若前驱已下线，置上标记
+		}
+		node.preLock.Unlock()
+		predecessor = "OFFLINE"
 	}
-	return node.IP
+	return predecessor, nil
+}
+
+// 前驱异常下线后得到新前驱pre：(pre, 当前节点]中的备份数据转为主数据，并复制到前r个后继
+func (node *Node) restore(pre string) error {
+	preId, err := node.getId(pre)
+	if err != nil {
+		node.log.WithField("predecessor", pre).WithError(err).Warn("Restoring data error.")
+		return err
+	}
+	data := []DataPair{}
+	node.dataLock.Lock()
+	node.dataBackupLock.Lock()
+	for _, dataPair := range node.dataBackup.All() {
+		if belong(false, true, preId, node.ID, dataPair.Values.KeyId) {
+			if _, ok := node.data.Get(dataPair.Key); !ok { //前驱下线期间写入的主数据更新
+				node.data.Put(dataPair.Key, dataPair.Values)
+				data = append(data, dataPair)
+			}
+			node.dataBackup.Delete(dataPair.Key) //从备份中删去
+		}
+	}
+	node.dataBackupLock.Unlock()
+	node.dataLock.Unlock()
+	if len(data) == 0 {
+		return nil
+	}
+	for _, ip := range node.replicaList() {
+		err := node.RPC.RemoteCall(ip, "Chord.SetInBackup", data, &Null{})
+		if err != nil {
+			node.log.WithField("replica", ip).WithError(err).Warn("Restoring data error.")
+		}
+	}
+	return nil
+}
+
+// 找到路由表中在目标位置前最近的上线节点，并给出途中发现已下线的节点
+func (node *Node) closestPrecedingFinger(id *big.Int) (ip string, offline []string) {
+	found := make(map[string]bool)
+	for i := 160; i > 1; i-- {
+		fingerId, ok := node.reachable(node.finger[i])
+		if !ok { //已下线或身份无法验证，将finger设为仍然上线的位置
+			if node.finger[i] != "" && !found[node.finger[i]] {
+				found[node.finger[i]] = true
+				offline = append(offline, node.finger[i])
+			}
+			if i == 160 {
+				node.finger[i] = node.IP
+			} else {
+				node.finger[i] = node.finger[i+1]
+			}
+		} else if belong(false, false, node.ID, id, fingerId) {
+			return node.finger[i], offline
+		}
+	}
+	node.sucLock.RLock()
+	successor := node.successorList[0]
+	node.sucLock.RUnlock()
+	successorId, ok := node.reachable(successor)
+	if !ok { //已下线，将后继设为仍然上线的位置(不持有锁发起远端调用)
+		if !found[successor] {
+			offline = append(offline, successor)
+		}
+		node.sucLock.Lock()
+		if node.successorList[0] == successor {
+			node.successorList[0] = node.IP
+		}
+		node.sucLock.Unlock()
+	} else if belong(false, false, node.ID, id, successorId) {
+		return successor, offline
+	}
+	return node.IP, offline
 }
 
 // 测试节点是否上线
@@ -426,217 +1213,318 @@ func (node *Node) Ping(ip string) bool {
 			return true
 		}
 	}
+	node.forgetId(ip)
+	node.churn()
 	return false
 }
 
 // 修护前驱后继，并相应地转移数据
-func (node *Node) stabilize() error {
-	successor, err := node.GetSuccessor()
-	if err != nil {
-		//logrus.Errorf("Stabilize error (IP = %s): %v.", node.IP, err)
-		return err
-	}
+func (node *Node) stabilize(ctx context.Context) error {
+	defer node.updateReplicas(ctx)
+	node.updateSuccessorList(ctx)
+	node.sucLock.RLock()
+	successor := node.successorList[0]
+	node.sucLock.RUnlock()
 	ip := ""
-	err = node.RPC.RemoteCall(successor, "Chord.GetPredecessor", Null{}, &ip)
+	err := node.RPC.RemoteCallContext(ctx, successor, "Chord.GetPredecessor", Null{}, &ip)
 	if err != nil {
-		//logrus.Errorf("Stabilize error (IP = %s): %v.", node.IP, err)
+		node.log.WithError(err).Warn("Stabilize error.")
 		return err
 	}
-	if (successor == node.IP) ||
-		(ip != "" && ip != "OFFLINE" && belong(false, false, node.ID, getHash(successor), getHash(ip))) {
+	if successor == node.IP || node.between(successor, ip) {
 		node.sucLock.Lock() //需要更改后继
-		node.successorList[2] = node.successorList[1]
-		node.successorList[1] = node.successorList[0]
+		copy(node.successorList[1:], node.successorList[:len(node.successorList)-1])
 		node.successorList[0] = ip
 		node.sucLock.Unlock()
-		node.RPC.RemoteCall(successor, "Chord.TransferData", IpPair{ip, node.IP}, &Null{})
+		node.RPC.RemoteCallContext(ctx, successor, "Chord.TransferData", IpPair{ip, node.IP}, &Null{})
 	}
 	node.sucLock.RLock()
 	successor = node.successorList[0]
 	node.sucLock.RUnlock()
-	err = node.RPC.RemoteCall(successor, "Chord.Notifty", node.IP, &Null{})
+	err = node.RPC.RemoteCallContext(ctx, successor, "Chord.Notifty", node.IP, &Null{})
 	if err != nil {
-		//logrus.Errorf("Stabilize error (IP = %s): %v.", node.IP, err)
+		node.log.WithError(err).Warn("Stabilize error.")
 		return err
 	}
-	if ip == "OFFLINE" {
-		data := []DataPair{}
-		node.dataLock.RLock()
-		for key, valuePair := range node.data {
-			data = append(data, DataPair{key, valuePair})
-		}
-		node.dataLock.RUnlock()
-		err = node.RPC.RemoteCall(successor, "Chord.PutInBackup", data, &Null{})
+	return nil
+}
+
+// 前r个后继变化时，向新加入的后继复制主数据，并删去不再负责备份的后继上的备份
+func (node *Node) updateReplicas(ctx context.Context) {
+	newReplicas := node.replicaList()
+	added := exclude(newReplicas, node.replicas)
+	removed := exclude(node.replicas, newReplicas)
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	node.replicas = newReplicas
+	node.dataLock.RLock()
+	data := node.data.All()
+	node.dataLock.RUnlock()
+	keys := []string{}
+	for _, dataPair := range data {
+		keys = append(keys, dataPair.Key)
+	}
+	for _, ip := range added {
+		err := node.RPC.RemoteCallContext(ctx, ip, "Chord.SetInBackup", data, &Null{})
 		if err != nil {
-			//logrus.Errorf("Restoring data error (IP = %s): %v.", node.IP, err)
-			return err
+			node.log.WithField("replica", ip).WithError(err).Warn("Replicating data error.")
 		}
 	}
-	return nil
+	for _, ip := range removed {
+		node.RPC.RemoteCallContext(ctx, ip, "Chord.DeleteOffBackup", keys, &Null{}) //已下线的节点无需处理
+	}
 }
 
-// 修复前驱
+// 节点ip是否在(当前节点, successor)之间，身份无法验证时视为否
+func (node *Node) between(successor, ip string) bool {
+	successorId, err := node.getId(successor)
+	if err != nil {
+		return false
+	}
+	id, err := node.getId(ip)
+	return err == nil && belong(false, false, node.ID, successorId, id)
+}
+
+// 修复前驱(若原前驱异常下线，则同时恢复其数据)
 func (node *Node) Notifty(ip string) error {
+	id, err := node.getId(ip) //在持有锁之前验证身份
+	if err != nil {
+		node.log.WithField("from", ip).WithError(err).Warn("Notifty error.")
+		return err
+	}
+	node.preLock.RLock()
+	pre := node.predecessor
+	node.preLock.RUnlock()
+	node.getId(pre) //原前驱一般已缓存，无法验证时视为下线
+	restore := false
 	node.preLock.Lock()
-	if node.predecessor == "" || node.predecessor == "OFFLINE" ||
-		belong(false, false, getHash(node.predecessor), node.ID, getHash(ip)) {
+	preId, ok := node.cachedId(node.predecessor)
+	if node.predecessor == "" || node.predecessor == "OFFLINE" || !ok ||
+		belong(false, false, preId, node.ID, id) {
+		restore = node.predecessor == "OFFLINE"
+		if node.predecessor != ip {
+			defer node.churn()
+		}
 		node.predecessor = ip
 	}
 	node.preLock.Unlock()
+	if restore {
+		return node.restore(ip)
+	}
 	return nil
 }
 
 // 更改后继时，转移数据
 func (node *Node) TransferData(ips IpPair) error { //将数据转移到前节点
-	preId := getHash(ips.IpPre)
-	prePreId := getHash(ips.IpPrePre)
+	preId, err := node.getId(ips.IpPre)
+	if err != nil {
+		node.log.WithField("target", ips.IpPre).WithError(err).Warn("Transferring data error.")
+		return err
+	}
 	//转移主数据
 	data := []DataPair{}
-	node.dataLock.RLock()
-	for key, valuePair := range node.data {
-		if !belong(false, true, preId, node.ID, valuePair.KeyId) {
-			data = append(data, DataPair{key, valuePair})
-		}
-	}
-	node.dataLock.RUnlock()
 	node.dataLock.Lock()
-	for _, dataPair := range data {
-		delete(node.data, dataPair.Key)
+	for _, dataPair := range node.data.All() {
+		if !belong(false, true, preId, node.ID, dataPair.Values.KeyId) {
+			data = append(data, dataPair)
+			node.data.Delete(dataPair.Key)
+		}
 	}
 	node.dataLock.Unlock()
-	err := node.RPC.RemoteCall(ips.IpPre, "Chord.PutIn", data, &Null{})
+	err = node.RPC.RemoteCall(ips.IpPre, "Chord.SetIn", data, &Null{})
 	if err != nil {
-		//logrus.Errorf("Node (IP = %s , toIp = %s) transferring data error: %v.", node.IP, ips.IpPre, err)
+		node.log.WithField("target", ips.IpPre).WithError(err).Warn("Transferring data error.")
 		return err
 	}
-	//转移主数据对应的备份
+	//转移主数据对应的备份：当前节点成为第1个备份，第r个后继不再需要备份
 	keyBackup := []string{}
 	node.dataBackupLock.Lock()
 	for _, dataPair := range data {
-		node.dataBackup[dataPair.Key] = dataPair.Values
+		node.dataBackup.Put(dataPair.Key, dataPair.Values)
 		keyBackup = append(keyBackup, dataPair.Key)
 	}
 	node.dataBackupLock.Unlock()
-	successor, _ := node.GetSuccessor()
-	err = node.RPC.RemoteCall(successor, "Chord.DeleteOffBackup", keyBackup, &Null{})
+	node.updateSuccessorList(context.Background())
+	node.sucLock.RLock()
+	lastReplica := node.successorList[node.replica-1]
+	node.sucLock.RUnlock()
+	if lastReplica != node.IP && lastReplica != ips.IpPre {
+		err = node.RPC.RemoteCall(lastReplica, "Chord.DeleteOffBackup", keyBackup, &Null{})
+		if err != nil {
+			node.log.WithField("target", lastReplica).WithError(err).Warn("Transferring backup data error.")
+		}
+	}
+	//转移备份：前节点与当前节点原先的前r个前驱相同，复制全部备份
+	node.dataBackupLock.RLock()
+	dataBackup := node.dataBackup.All()
+	node.dataBackupLock.RUnlock()
+	err = node.RPC.RemoteCall(ips.IpPre, "Chord.SetInBackup", dataBackup, &Null{})
 	if err != nil {
-		//logrus.Errorf("Node (IP = %s , sucIp = %s) transferring backup data error: %v.", node.IP, successor, err)
+		node.log.WithField("target", ips.IpPre).WithError(err).Warn("Transferring backup data error.")
 		return err
 	}
-	//转移备份
-	dataBackup := []DataPair{}
-	node.dataBackupLock.RLock()
-	for key, valuePair := range node.dataBackup {
-		if !belong(false, true, prePreId, preId, valuePair.KeyId) {
-			dataBackup = append(dataBackup, DataPair{key, valuePair})
+	//当前节点只需保留前r个前驱的备份，即(IpPrePre往前第r-1个前驱, IpPre]中的数据
+	boundary := ips.IpPrePre
+	for i := 1; i < node.replica; i++ {
+		err = node.RPC.RemoteCall(boundary, "Chord.GetPredecessor", Null{}, &boundary)
+		if err != nil || boundary == "" || boundary == "OFFLINE" {
+			return nil //无法确定范围时保留多余的备份
 		}
 	}
-	node.dataBackupLock.RUnlock()
+	boundaryId, err := node.getId(boundary)
+	if err != nil {
+		return nil
+	}
 	node.dataBackupLock.Lock()
-	for _, dataPair := range dataBackup {
-		delete(node.dataBackup, dataPair.Key)
+	for _, dataPair := range node.dataBackup.All() {
+		if !belong(false, true, boundaryId, preId, dataPair.Values.KeyId) {
+			node.dataBackup.Delete(dataPair.Key)
+		}
 	}
 	node.dataBackupLock.Unlock()
-	err = node.RPC.RemoteCall(ips.IpPre, "Chord.PutInBackup", dataBackup, &Null{})
-	if err != nil {
-		//logrus.Errorf("Node (IP = %s , toIp = %s) transferring backup data error: %v.", node.IP, ips.IpPre, err)
-		return err
-	}
 	return nil
 }
 
-// 修复路由表
-func (node *Node) fixFinger() error {
+// 修复路由表，返回finger是否变化
+func (node *Node) fixFinger() (bool, error) {
 	ip, err := node.FindSuccessor(node.fingerStart[node.fixIndex])
 	if err != nil {
-		//logrus.Errorf("Fixing finger error (IP = %s): %v.", node.IP, err)
-		return err
+		node.log.WithError(err).Warn("Fixing finger error.")
+		return false, err
 	}
+	changed := false
 	node.fingerLock.Lock()
 	if node.finger[node.fixIndex] != ip { //更新finger
 		node.finger[node.fixIndex] = ip
+		changed = true
 	}
 	node.fingerLock.Unlock()
 	node.fixIndex = (node.fixIndex-1)%159 + 2
-	return nil
+	return changed, nil
 }
 
-// 控制节点周期性地进行修复
+// 控制节点周期性地进行修复(结果没有变化时逐步放慢，见schedule)
 func (node *Node) maintain() {
 	go func() {
 		for node.Online {
+			start := time.Now()
 			node.block.Lock()
-			node.stabilize()
+			before := node.neighbours()
+			err := node.stabilize(context.Background())
+			changed := err != nil || !equal(before, node.neighbours())
 			node.block.Unlock()
-			time.Sleep(50 * time.Millisecond)
+			node.stabilizeTime.Since(start)
+			node.stabilizeSched.done(changed)
+			node.stabilizeSched.wait()
+		}
+		node.log.Info("Node stops stablizing.")
+	}()
+	go func() {
+		for node.Online {
+			start := time.Now()
+			changed, err := node.fixFinger()
+			node.fixFingerTime.Since(start)
+			node.fixFingerSched.done(changed || err != nil)
+			node.fixFingerSched.wait()
 		}
-		//logrus.Infof("Node (IP = %s) stops stablizing.", node.IP)
+		node.log.Info("Node stops fixing finger.")
 	}()
 	go func() {
 		for node.Online {
-			node.fixFinger()
-			time.Sleep(50 * time.Millisecond)
+			node.expire()
+			time.Sleep(ExpireCircleTime)
 		}
-		//logrus.Infof("Node (IP = %s) stops fixing finger.", node.IP)
+		node.log.Info("Node stops expiring.")
 	}()
 }
 
+// 清除主数据与备份数据中的过期数据
+func (node *Node) expire() {
+	now := time.Now()
+	node.dataLock.Lock()
+	for _, dataPair := range node.data.All() {
+		if dataPair.Values.expired(now) {
+			node.data.Delete(dataPair.Key)
+		}
+	}
+	node.dataLock.Unlock()
+	node.dataBackupLock.Lock()
+	for _, dataPair := range node.dataBackup.All() {
+		if dataPair.Values.expired(now) {
+			node.dataBackup.Delete(dataPair.Key)
+		}
+	}
+	node.dataBackupLock.Unlock()
+}
+
 // 更改前驱
 func (node *Node) ChangePredecessor(ip string) {
 	node.preLock.Lock()
 	node.predecessor = ip
 	node.preLock.Unlock()
+	node.churn()
 }
 
 // 更改后继列表
-func (node *Node) ChangeSuccessorList(list [3]string) {
+func (node *Node) ChangeSuccessorList(list []string) {
 	node.sucLock.Lock()
-	for i := 0; i < 3; i++ { //将后继列表转移
-		node.successorList[i] = list[i]
+	for i := range node.successorList { //将后继列表转移
+		if i < len(list) {
+			node.successorList[i] = list[i]
+		} else {
+			node.successorList[i] = node.IP
+		}
 	}
 	node.sucLock.Unlock()
+	node.churn()
 }
 
 // 得到后继列表
-func (node *Node) GetSuccessorList() [3]string {
-	var successorList [3]string
+func (node *Node) GetSuccessorList() []string {
 	node.sucLock.RLock()
-	for i := 0; i < 3; i++ {
-		successorList[i] = node.successorList[i]
-	}
+	successorList := make([]string, len(node.successorList))
+	copy(successorList, node.successorList)
 	node.sucLock.RUnlock()
 	return successorList
 }
 
-// 更新后继列表，使其中节点在线
-func (node *Node) updateSuccessorList() error {
-	var tmp, nextSuccessorList [3]string
+// 得到负责备份主数据的节点(前r个后继，去除自身与重复项)
+func (node *Node) replicaList() []string {
 	node.sucLock.RLock()
-	for i, ip := range node.successorList {
-		tmp[i] = ip
-	}
-	node.sucLock.RUnlock()
+	defer node.sucLock.RUnlock()
+	return distinctReplicas(node.successorList[:node.replica], node.IP)
+}
+
+// 更新后继列表，使其中节点在线
+func (node *Node) updateSuccessorList(ctx context.Context) error {
+	tmp := node.GetSuccessorList()
 	for _, ip := range tmp {
 		if node.Ping(ip) { //找到最近的存在的后继
-			err := node.RPC.RemoteCall(ip, "Chord.GetSuccessorList", Null{}, &nextSuccessorList)
+			nextSuccessorList := []string{}
+			err := node.RPC.RemoteCallContext(ctx, ip, "Chord.GetSuccessorList", Null{}, &nextSuccessorList)
 			if err != nil {
-				//logrus.Errorf("Getting successor list error (IP = %s): %v.", node.IP, err)
+				node.log.WithField("successor", ip).WithError(err).Debug("Getting successor list error.")
 				continue
 			}
 			node.sucLock.Lock()
 			node.successorList[0] = ip //更新后继列表
-			for j := 1; j < 3; j++ {
-				node.successorList[j] = nextSuccessorList[j-1]
+			for j := 1; j < len(node.successorList); j++ {
+				if j-1 < len(nextSuccessorList) {
+					node.successorList[j] = nextSuccessorList[j-1]
+				} else {
+					node.successorList[j] = node.IP
+				}
 			}
 			node.sucLock.Unlock()
 			return nil
 		}
 	}
-	//logrus.Infof("All successors are offline (IP = %s).", node.IP)
+	node.log.Warn("All successors are offline.")
 	//后继列表中节点均失效，尝试通过路由表寻找以防止环断裂
-	ip, err := node.FindSuccessor(cal(node.ID, 0))
+	ip, err := node.FindSuccessorContext(ctx, cal(node.ID, 0))
 	if err != nil {
-		//logrus.Errorf("Finding successor list error (IP = %s): %v.", node.IP, err)
+		node.log.WithError(err).Warn("Finding successor list error.")
 	}
 	node.sucLock.Lock()
 	for i := range node.successorList {
@@ -662,84 +1550,388 @@ func (node *Node) Unlock() {
 
 // 将数据存为某节点的主数据
 func (node *Node) PutIn(data []DataPair) error {
+	_, err := node.putIn(data)
+	return err
+}
+
+// 按数据模式将写入请求存入主数据，返回写入后的数据(包含版本，用于复制到备份)
+func (node *Node) putIn(data []DataPair) ([]DataPair, error) {
 	node.dataLock.Lock()
-	for i := range data {
-		_, ok := node.data[data[i].Key]
-		if !ok {
-			node.data[data[i].Key] = data[i].Values
-		} else {
-			node.data[data[i].Key] = dataPut(node.data[data[i].Key], data[i].Values, (data[i].Key)[len(data[i].Key)-1])
+	defer node.dataLock.Unlock()
+	stored := []DataPair{}
+	for _, dataPair := range data {
+		valueOld, _ := node.data.Get(dataPair.Key)
+		valuePair, err := dataPut(valueOld, dataPair.Values, node.IP, node.merge)
+		if err != nil {
+			return stored, err
+		}
+		err = node.data.Put(dataPair.Key, valuePair)
+		if err != nil {
+			return stored, err
 		}
+		stored = append(stored, DataPair{dataPair.Key, valuePair})
 	}
-	node.dataLock.Unlock()
-	return nil
+	return stored, nil
 }
 
-// 将数据存为某节点的备份数据
+// 将数据存为某节点的备份数据(与已有版本合并)
 func (node *Node) PutInBackup(data []DataPair) error {
 	node.dataBackupLock.Lock()
-	for i := range data {
-		_, ok := node.dataBackup[data[i].Key]
-		if !ok {
-			node.dataBackup[data[i].Key] = data[i].Values
-		} else {
-			node.dataBackup[data[i].Key] = dataPut(node.dataBackup[data[i].Key], data[i].Values, (data[i].Key)[len(data[i].Key)-1])
+	defer node.dataBackupLock.Unlock()
+	for _, dataPair := range data {
+		valueOld, _ := node.dataBackup.Get(dataPair.Key)
+		err := node.dataBackup.Put(dataPair.Key, dataMerge(valueOld, dataPair.Values, node.merge))
+		if err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+// 将数据直接覆盖为某节点的主数据(用于转移数据，不按模式合并)
+func (node *Node) SetIn(data []DataPair) error {
+	node.dataLock.Lock()
+	defer node.dataLock.Unlock()
+	return setInStorage(node.data, data)
+}
+
+// 将数据直接覆盖为某节点的备份数据(用于转移数据，不按模式合并)
+func (node *Node) SetInBackup(data []DataPair) error {
+	node.dataBackupLock.Lock()
+	defer node.dataBackupLock.Unlock()
+	return setInStorage(node.dataBackup, data)
+}
 
+func setInStorage(storage Storage, data []DataPair) error {
+	for _, dataPair := range data {
+		err := storage.Put(dataPair.Key, dataPair.Values)
+		if err != nil {
+			return err
+		}
 	}
-	node.dataBackupLock.Unlock()
 	return nil
 }
 
-// 将数据存为某节点的主数据，以及该节点的后继的备份数据
+// 将数据存为某节点的主数据，以及该节点的前r个后继的备份数据
 func (node *Node) PutInAll(data []DataPair) error {
-	node.PutIn(data)
-	//后继节点放入备份数据
-	successor, err := node.GetSuccessor()
+	return node.PutInAllLevel(data, rpc.ONE)
+}
+
+// 将数据存为某节点的主数据，以及该节点的前r个后继的备份数据，写入成功的副本数不足level要求时返回错误(已写入的副本不回滚)
+func (node *Node) PutInAllLevel(data []DataPair, level rpc.Consistency) error {
+	data, err := node.putIn(data)
 	if err != nil {
-		//logrus.Errorf("Getting successor error (IP = %s): %v.", node.IP, err)
 		return err
 	}
-	err = node.RPC.RemoteCall(successor, "Chord.PutInBackup", data, &Null{})
+	return node.putInReplicas(data, level)
+}
+
+// 按条将写入请求存入主数据，再复制到前r个后继，返回各条的错误信息(成功为空)，某条失败不影响其他条
+func (node *Node) PutInMulti(data []DataPair) []string {
+	out := make([]string, len(data))
+	stored := []DataPair{}
+	node.dataLock.Lock()
+	for i, dataPair := range data {
+		valueOld, _ := node.data.Get(dataPair.Key)
+		valuePair, err := dataPut(valueOld, dataPair.Values, node.IP, node.merge)
+		if err == nil {
+			err = node.data.Put(dataPair.Key, valuePair)
+		}
+		if err != nil {
+			out[i] = rpc.EncodeError(err)
+			continue
+		}
+		stored = append(stored, DataPair{dataPair.Key, valuePair})
+	}
+	node.dataLock.Unlock()
+	node.putInReplicas(stored, rpc.ONE)
+	return out
+}
+
+// 在主节点上原子地判断条件并写入，再复制到前r个后继，返回写入后的值
+func (node *Node) PutInCondition(pair ConditionPair) (string, error) {
+	node.dataLock.Lock()
+	valueOld, ok := node.data.Get(pair.Key)
+	if !ok {
+		node.preLock.RLock()
+		predecessor := node.predecessor
+		node.preLock.RUnlock()
+		if predecessor == "OFFLINE" {
+			node.dataBackupLock.RLock()
+			valueOld, ok = node.dataBackup.Get(pair.Key)
+			node.dataBackupLock.RUnlock()
+		}
+	}
+	if ok && (valueOld.expired(time.Now()) || valueOld.Format == 0) {
+		valueOld, ok = ValuePair{}, false
+	}
+	valueNew, err := dataCondition(valueOld, ok, pair, node.merge)
 	if err != nil {
-		//logrus.Errorf("Putting in backup error (IP = %s): %v.", node.IP, err)
+		node.dataLock.Unlock()
+		return "", err
+	}
+	valuePair, err := dataPut(valueOld, valueNew, node.IP, node.merge)
+	if err == nil {
+		err = node.data.Put(pair.Key, valuePair)
+	}
+	node.dataLock.Unlock()
+	if err != nil {
+		return "", err
+	}
+	//各副本按版本合并，复制可以在释放锁之后进行
+	return valuePair.Value, node.putInReplicas([]DataPair{{pair.Key, valuePair}}, rpc.ONE)
+}
+
+// 将写入后的数据复制到前r个后继的备份数据
+func (node *Node) putInReplicas(data []DataPair, level rpc.Consistency) error {
+	replicas, acked, errs := node.callReplicas("Chord.PutInBackup", data)
+	for ip, err := range errs {
+		node.log.WithField("replica", ip).WithError(err).Warn("Putting in backup error.")
+	}
+	acks := 1
+	for _, ip := range replicas {
+		if acked[ip] {
+			acks++
+		}
+	}
+	if acks < level.Required(len(replicas)+1) {
+		return rpc.ErrUnavailable
 	}
 	return nil
 }
 
-// 在某节点主数据中查询数据
+// 并行地对前r个后继调用method，使用stabilize维护的后继列表；有后继没有应答时才更新后继列表，
+// 并对更新后的列表中尚未应答的后继再调用一次。返回最终的副本列表、其中应答的节点与失败的节点的错误
+func (node *Node) callReplicas(method string, args interface{}) (replicas []string, acked map[string]bool, errs map[string]error) {
+	acked = make(map[string]bool)
+	replicas = node.replicaList()
+	errs = node.callEach(replicas, method, args, acked)
+	for _, err := range errs {
+		if rpc.Unanswered(err) {
+			node.updateSuccessorList(context.Background())
+			replicas = node.replicaList()
+			errs = node.callEach(replicas, method, args, acked)
+			break
+		}
+	}
+	return replicas, acked, errs
+}
+
+// 并行地对ips中不在acked中的节点调用method，应答的节点记入acked，返回失败的节点的错误
+func (node *Node) callEach(ips []string, method string, args interface{}, acked map[string]bool) map[string]error {
+	errs := make(map[string]error)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for _, ip := range ips {
+		if acked[ip] {
+			continue
+		}
+		wg.Add(1)
+		go func(ip string) {
+			defer wg.Done()
+			err := node.RPC.RemoteCall(ip, method, args, &Null{})
+			lock.Lock()
+			if err != nil {
+				errs[ip] = err
+			} else {
+				acked[ip] = true
+			}
+			lock.Unlock()
+		}(ip)
+	}
+	wg.Wait()
+	return errs
+}
+
+// 在某节点主数据中查询数据(前驱异常下线、尚未恢复数据时，同时查询备份数据)
 func (node *Node) GetOut(key string) (string, bool) {
+	valuePair, ok := node.getOut(key)
+	return valuePair.Value, ok
+}
+
+// 在某节点主数据中查询某一模式的数据的各个版本(模式不同视为不存在)
+func (node *Node) GetOutVersions(key, mode string) (version.Siblings, bool) {
+	valuePair, ok := node.getOut(key)
+	if !ok || valuePair.Mode != mode {
+		return nil, false
+	}
+	return versionsOf(valuePair), true
+}
+
+func (node *Node) getOut(key string) (ValuePair, bool) {
+	node.dataLock.RLock()
+	defer node.dataLock.RUnlock()
+	valuePair, ok := node.data.Get(key)
+	if !ok {
+		node.preLock.RLock()
+		predecessor := node.predecessor
+		node.preLock.RUnlock()
+		if predecessor == "OFFLINE" {
+			node.dataBackupLock.RLock()
+			valuePair, ok = node.dataBackup.Get(key)
+			node.dataBackupLock.RUnlock()
+		}
+	}
+	if ok && valuePair.expired(time.Now()) { //尚未清除的过期数据
+		return ValuePair{}, false
+	}
+	return valuePair, ok
+}
+
+// 给出某节点ID在[StartId, EndId]内、键在[StartKey, EndKey)内的数据，按键排序，至多Limit条(前驱异常下线、尚未恢复数据时，同时查询备份数据)
+func (node *Node) ScanOut(scanRange ScanRange) []ScanPair {
 	node.dataLock.RLock()
-	valuePair, ok := node.data[key]
+	data := node.data.All()
 	node.dataLock.RUnlock()
-	return valuePair.Value, ok
+	node.preLock.RLock()
+	predecessor := node.predecessor
+	node.preLock.RUnlock()
+	if predecessor == "OFFLINE" {
+		node.dataBackupLock.RLock()
+		data = append(data, node.dataBackup.All()...)
+		node.dataBackupLock.RUnlock()
+	}
+	now := time.Now()
+	found := make(map[string]bool)
+	out := []ScanPair{}
+	for _, dataPair := range data {
+		valuePair := dataPair.Values
+		if found[dataPair.Key] || valuePair.Format == 0 || valuePair.expired(now) ||
+			dataPair.Key < scanRange.StartKey || (scanRange.EndKey != "" && dataPair.Key >= scanRange.EndKey) ||
+			valuePair.KeyId.Cmp(scanRange.StartId) < 0 || valuePair.KeyId.Cmp(scanRange.EndId) > 0 {
+			continue
+		}
+		found[dataPair.Key] = true
+		out = append(out, ScanPair{dataPair.Key, valuePair.Value, valuePair.Mode})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Key < out[j].Key
+	})
+	if scanRange.Limit > 0 && len(out) > scanRange.Limit {
+		out = out[:scanRange.Limit]
+	}
+	return out
 }
 
-// 在某节点中删除主数据，在其后继删除备份数据
-func (node *Node) DeleteOffAll(keys []string) bool {
-	out := true
-	node.dataLock.Lock()
-	for _, key := range keys {
-		_, ok := node.data[key]
+// 在某节点主数据中按条查询数据的各个版本，不存在的为空
+func (node *Node) GetOutMulti(pairs []KeyModePair) []version.Siblings {
+	out := make([]version.Siblings, len(pairs))
+	for i, pair := range pairs {
+		out[i], _ = node.GetOutVersions(pair.Key, pair.Mode)
+	}
+	return out
+}
+
+// 在某节点备份数据中查询某一模式的数据的各个版本(模式不同视为不存在)
+func (node *Node) GetOutBackup(key, mode string) (version.Siblings, bool) {
+	node.dataBackupLock.RLock()
+	valuePair, ok := node.dataBackup.Get(key)
+	node.dataBackupLock.RUnlock()
+	if !ok || valuePair.Mode != mode || valuePair.expired(time.Now()) {
+		return nil, false
+	}
+	return versionsOf(valuePair), true
+}
+
+// 在某节点主数据及其前r个后继的备份数据中查询数据，应答的副本数不足level要求时返回错误，结果为各副本版本的合并
+func (node *Node) GetOutLevel(key, mode string, level rpc.Consistency) (version.Siblings, error) {
+	replicas := node.replicaList()
+	valuePair, ok := node.getOut(key)
+	if ok && valuePair.Mode != mode {
+		return nil, rpc.ErrNotFound
+	}
+	versions := versionsOf(valuePair)
+	acks := 1
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(len(replicas))
+	for _, ip := range replicas {
+		go func(ip string) {
+			defer wg.Done()
+			versionsBackup := version.Siblings{}
+			err := node.RPC.RemoteCall(ip, "Chord.GetOutBackup", KeyModePair{key, mode}, &versionsBackup)
+			if err != nil && !errors.Is(err, rpc.ErrNotFound) {
+				node.log.WithField("replica", ip).WithError(err).Debug("Getting out backup error.")
+				return
+			}
+			lock.Lock()
+			acks++ //不存在也算应答
+			versions = versions.Merge(versionsBackup)
+			lock.Unlock()
+		}(ip)
+	}
+	wg.Wait()
+	if acks < level.Required(len(replicas)+1) {
+		return nil, rpc.ErrUnavailable
+	}
+	if len(versions) == 0 {
+		return nil, rpc.ErrNotFound
+	}
+	return versions, nil
+}
+
+// 在某节点中删除主数据，在其前r个后继删除备份数据(模式不同视为不存在)
+func (node *Node) DeleteOffAll(pairs []KeyModePair) error {
+	found, err := node.deleteOff(pairs)
+	for _, ok := range found {
+		if !ok { //键不存在时，备份中自然也没有
+			return rpc.ErrNotFound
+		}
+	}
+	return err
+}
+
+// 按条删除数据，返回各条的错误信息(成功为空)
+func (node *Node) DeleteOffMulti(pairs []KeyModePair) []string {
+	found, err := node.deleteOff(pairs)
+	out := make([]string, len(pairs))
+	for i, ok := range found {
 		if !ok {
-			out = false
+			out[i] = rpc.EncodeError(rpc.ErrNotFound)
 		} else {
-			delete(node.data, key)
+			out[i] = rpc.EncodeError(err)
 		}
 	}
+	return out
+}
+
+// 删除主数据及前r个后继的备份数据，返回各条是否存在，以及删除备份时的错误
+func (node *Node) deleteOff(pairs []KeyModePair) (found []bool, out error) {
+	keys := []string{}
+	node.preLock.RLock()
+	predecessor := node.predecessor
+	node.preLock.RUnlock()
+	now := time.Now()
+	node.dataLock.Lock()
+	node.dataBackupLock.Lock()
+	for _, pair := range pairs {
+		//与getOut相同：前驱异常下线、尚未恢复数据时，同时在备份数据中查找，过期数据视为不存在
+		store := node.data
+		valuePair, ok := store.Get(pair.Key)
+		if !ok && predecessor == "OFFLINE" {
+			store = node.dataBackup
+			valuePair, ok = store.Get(pair.Key)
+		}
+		if !ok || valuePair.Mode != pair.Mode || valuePair.expired(now) {
+			found = append(found, false)
+		} else {
+			store.Delete(pair.Key)
+			keys = append(keys, pair.Key)
+			found = append(found, true)
+		}
+	}
+	node.dataBackupLock.Unlock()
 	node.dataLock.Unlock()
-	//删除后继节点的备份数据
-	successor, err := node.GetSuccessor()
-	if err != nil {
-		//logrus.Errorf("Getting successor error (IP = %s): %v.", node.IP, err)
-		return false
+	if len(keys) == 0 { //没有删去任何主数据，无需通知后继
+		return found, nil
 	}
-	err = node.RPC.RemoteCall(successor, "Chord.DeleteOffBackup", keys, &Null{})
-	if err != nil {
-		//logrus.Errorf("Deleting off backup error (IP = %s): %v.", node.IP, err)
-		return false
+	//删除后继节点的备份数据
+	_, _, errs := node.callReplicas("Chord.DeleteOffBackup", keys)
+	for ip, err := range errs {
+		node.log.WithField("replica", ip).WithError(err).Warn("Deleting off backup error.")
+		out = err
 	}
-	return out
+	return found, out
 }
 
 // 在某节点中删除备份数据
@@ -747,11 +1939,11 @@ func (node *Node) DeleteOffBackup(keys []string) bool {
 	out := true
 	node.dataBackupLock.Lock()
 	for _, key := range keys {
-		_, ok := node.dataBackup[key]
+		_, ok := node.dataBackup.Get(key)
 		if !ok {
 			out = false
 		} else {
-			delete(node.dataBackup, key)
+			node.dataBackup.Delete(key)
 		}
 	}
 	node.dataBackupLock.Unlock()
@@ -1,27 +1,189 @@
 package chord
 
 import (
+	"errors"
 	"math/big"
+	"strconv"
+	"sync"
+	"time"
+
+	"dht/rpc"
+	"dht/version"
 )
 
-// 提供两种数据存储模式： 覆盖overwrite/添加append
+// 提供两种数据存储模式： 覆盖overwrite/添加append，可通过RegisterMode注册新的模式。
+// OVERWRITE/APPEND保留原有的取值(旧格式中键末尾的模式编码)，注册表中使用可读的名称
 const OVERWRITE = "a"
 const APPEND = "b"
 
+// 两种模式在注册表中的名称，也是ValuePair.Mode中记录的值
+const modeOverwrite = "overwrite"
+const modeAppend = "append"
+
+// 数据格式版本：0为旧格式(模式编码在键的末尾)，1为显式的模式字段
+const dataFormat = 1
+
+// 旧格式中键末尾的模式编码 -> 模式名称
+var legacyModes = map[string]string{OVERWRITE: modeOverwrite, APPEND: modeAppend}
+
+// 模式处理函数：主节点由已有数据(不存在时为零值)与写入请求得到写入后的各版本
+type ModeHandler func(valueOld, valueNew ValuePair, writer string) version.Siblings
+
+var modeHandlers = map[string]ModeHandler{modeOverwrite: overwriteMode, modeAppend: appendMode}
+var modeLock sync.RWMutex
+
+// 注册新的数据模式(需在节点运行前调用，各节点需注册相同的模式)
+func RegisterMode(mode string, handler ModeHandler) error {
+	modeLock.Lock()
+	defer modeLock.Unlock()
+	if _, ok := modeHandlers[mode]; ok || mode == "" {
+		return errors.New("Mode " + mode + " already registered.")
+	}
+	if _, ok := legacyModes[mode]; ok {
+		return errors.New("Mode " + mode + " is reserved.")
+	}
+	modeHandlers[mode] = handler
+	return nil
+}
+
+// 检查模式是否已注册，返回其在注册表中的名称(OVERWRITE/APPEND给出"overwrite"/"append")，未注册时返回空串
+func CheckMode(mode string) string {
+	if name, ok := legacyModes[mode]; ok {
+		mode = name
+	}
+	modeLock.RLock()
+	defer modeLock.RUnlock()
+	if _, ok := modeHandlers[mode]; ok {
+		return mode
+	}
+	return ""
+}
+
+// 由模式名称得到原有的取值(overwrite为OVERWRITE，append为APPEND)，其他模式返回空串。
+// 保留原有的行为以兼容已有的调用者，检查模式请使用CheckMode
 func GetMode(NewMode string) string {
-	if NewMode == "overwrite" {
-		return OVERWRITE
-	} else if NewMode == "append" {
-		return APPEND
+	for suffix, mode := range legacyModes {
+		if mode == NewMode {
+			return suffix
+		}
 	}
 	return ""
 }
 
-func dataPut(valueOld, valueNew ValuePair, mode byte) ValuePair {
-	if mode == 'a' {
-		return valueNew
-	} else if mode == 'b' {
-		return ValuePair{(valueOld.Value + valueNew.Value), new(big.Int).Set(valueNew.KeyId)}
+// 覆盖模式：未带版本的写入(盲写)覆盖已有的所有版本，带版本的写入与已有版本合并
+func overwriteMode(valueOld, valueNew ValuePair, writer string) version.Siblings {
+	if len(valueNew.Versions) == 0 {
+		return versionsOf(valueOld).Overwrite(valueNew.Value, writer, time.Now().UnixNano())
+	}
+	return versionsOf(valueOld).Merge(valueNew.Versions)
+}
+
+// 添加模式：将值添加到已有值之后，作为覆盖已有版本的新版本
+func appendMode(valueOld, valueNew ValuePair, writer string) version.Siblings {
+	return versionsOf(valueOld).Overwrite(valueOld.Value+valueNew.Value, writer, time.Now().UnixNano())
+}
+
+// 条件写入的条件
+type Condition int
+
+const (
+	IF_ABSENT Condition = iota //键不存在
+	IF_EQUAL                   //当前值等于Expected
+	INCREMENT                  //当前值为整数(不存在视为0)，写入加上Delta后的值
+)
+
+// 判断条件写入的条件，满足时给出写入请求(盲写，计数器保留原有的过期时间)
+func dataCondition(valueOld ValuePair, exist bool, pair ConditionPair, merge version.MergeFunc) (ValuePair, error) {
+	valueNew := ValuePair{Value: pair.Value, KeyId: pair.KeyId, Mode: modeOverwrite}
+	switch pair.Condition {
+	case IF_ABSENT:
+		if exist {
+			return ValuePair{}, rpc.ErrConditionFailed
+		}
+	case IF_EQUAL:
+		if !exist || versionsOf(valueOld).Resolve(merge) != pair.Expected {
+			return ValuePair{}, rpc.ErrConditionFailed
+		}
+	case INCREMENT:
+		count := int64(0)
+		if exist {
+			var err error
+			count, err = strconv.ParseInt(versionsOf(valueOld).Resolve(merge), 10, 64)
+			if err != nil {
+				return ValuePair{}, errors.New("Value is not an integer.")
+			}
+		}
+		valueNew.Value = strconv.FormatInt(count+pair.Delta, 10)
+		valueNew.ExpireTime = valueOld.ExpireTime
+	default:
+		return ValuePair{}, errors.New("Unknown condition.")
+	}
+	return valueNew, nil
+}
+
+// 主节点按写入请求的模式执行一次写入，模式与已有数据不同时拒绝写入
+func dataPut(valueOld, valueNew ValuePair, writer string, merge version.MergeFunc) (ValuePair, error) {
+	modeLock.RLock()
+	handler, ok := modeHandlers[valueNew.Mode]
+	modeLock.RUnlock()
+	if !ok {
+		return ValuePair{}, errors.New("Unknown mode: " + valueNew.Mode + ".")
+	}
+	if valueOld.Format == 0 { //尚未迁移的旧格式数据属于另一个键(模式编码在键的末尾)
+		valueOld = ValuePair{}
+	}
+	if valueOld.Mode != "" && valueOld.Mode != valueNew.Mode {
+		return ValuePair{}, rpc.ErrModeConflict
+	}
+	versions := handler(valueOld, valueNew, writer)
+	expire := valueNew.ExpireTime
+	if len(valueNew.Versions) != 0 {
+		expire = mergeExpire(valueOld, valueNew)
+	}
+	return ValuePair{versions.Resolve(merge), new(big.Int).Set(valueNew.KeyId), versions, expire, valueNew.Mode, dataFormat}, nil
+}
+
+// 副本之间同步时合并版本
+func dataMerge(valueOld, valueNew ValuePair, merge version.MergeFunc) ValuePair {
+	versions := versionsOf(valueOld).Merge(versionsOf(valueNew))
+	return ValuePair{versions.Resolve(merge), new(big.Int).Set(valueNew.KeyId), versions, mergeExpire(valueOld, valueNew), valueNew.Mode, valueNew.Format}
+}
+
+// 合并版本后的过期时间：沿用版本覆盖另一方的一方的过期时间，并发时取较晚者
+func mergeExpire(valueOld, valueNew ValuePair) time.Time {
+	versionsOld, versionsNew := versionsOf(valueOld), versionsOf(valueNew)
+	if versionsNew.Covers(versionsOld) {
+		return valueNew.ExpireTime
+	}
+	if versionsOld.Covers(versionsNew) {
+		return valueOld.ExpireTime
+	}
+	if valueOld.ExpireTime.IsZero() || valueNew.ExpireTime.IsZero() {
+		return time.Time{}
+	}
+	if valueOld.ExpireTime.After(valueNew.ExpireTime) {
+		return valueOld.ExpireTime
+	}
+	return valueNew.ExpireTime
+}
+
+// 由ttl得到过期时间(ttl不为正时永不过期)
+func expireTime(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// 数据是否已过期
+func (valuePair ValuePair) expired(now time.Time) bool {
+	return !valuePair.ExpireTime.IsZero() && now.After(valuePair.ExpireTime)
+}
+
+// 得到数据的各版本(兼容未记录版本的旧数据，视为最早的版本)
+func versionsOf(valuePair ValuePair) version.Siblings {
+	if len(valuePair.Versions) == 0 && valuePair.Value != "" {
+		return version.Siblings{{Value: valuePair.Value, Clock: version.Clock{}}}
 	}
-	return ValuePair{}
+	return valuePair.Versions
 }
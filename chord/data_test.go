@@ -0,0 +1,30 @@
+package chord
+
+import "testing"
+
+func TestModeNames(t *testing.T) {
+	cases := []struct {
+		mode, suffix, checked string
+	}{
+		{"overwrite", "a", "overwrite"},
+		{"append", "b", "append"},
+		{OVERWRITE, "", "overwrite"},
+		{APPEND, "", "append"},
+		{"unknown", "", ""},
+		{"", "", ""},
+	}
+	for _, c := range cases {
+		if got := GetMode(c.mode); got != c.suffix {
+			t.Errorf("GetMode(%q) = %q, want %q", c.mode, got, c.suffix)
+		}
+		if got := CheckMode(c.mode); got != c.checked {
+			t.Errorf("CheckMode(%q) = %q, want %q", c.mode, got, c.checked)
+		}
+	}
+	if OVERWRITE != "a" || APPEND != "b" {
+		t.Fatalf("OVERWRITE = %q, APPEND = %q, want the original values a and b", OVERWRITE, APPEND)
+	}
+	if err := RegisterMode(APPEND, appendMode); err == nil {
+		t.Fatal("registering a mode under a reserved value should fail")
+	}
+}
@@ -0,0 +1,89 @@
+package chord
+
+import (
+	"context"
+	"crypto/ed25519"
+	"math/big"
+
+	"dht/logging"
+	"dht/rpc"
+)
+
+const identifyUsage = "Chord.Identify"
+
+// 磁盘存储的目录中保存私钥的文件
+const KeyFile = "node.key"
+
+// 以key为节点的私钥，ID由公钥确定，finger的起点随之改变
+func (node *Node) setKey(key ed25519.PrivateKey) {
+	node.key = key
+	node.ID = rpc.IdFromPublicKey(key.Public().(ed25519.PublicKey))
+	node.log = logging.ForNode(node.logger, node.IP, node.ID)
+	node.RPC.Log = node.log
+	for i := 2; i <= 160; i++ {
+		node.fingerStart[i] = cal(node.ID, i-1)
+	}
+}
+
+// 对请求方给出的随机数签名，证明自身持有ID对应的私钥
+func (node *Node) Identify(nonce []byte) rpc.Identity {
+	return rpc.Sign(node.key, rpc.SignMessage(identifyUsage, nonce, node.IP))
+}
+
+// 得到节点ip对应的ID(要求对方签名验证后缓存)，无法验证时返回错误，视为节点不可达
+func (node *Node) getId(ip string) (*big.Int, error) {
+	return node.verifyId(context.Background(), ip)
+}
+
+// 在ctx的时限内要求节点ip签名，得到其ID并缓存，无法验证时返回错误
+func (node *Node) verifyId(ctx context.Context, ip string) (*big.Int, error) {
+	if id, ok := node.cachedId(ip); ok {
+		return id, nil
+	}
+	if ip == "" || ip == "OFFLINE" {
+		return nil, rpc.ErrOffline
+	}
+	nonce := rpc.NewNonce()
+	identity := rpc.Identity{}
+	err := node.RPC.RemoteCallContext(ctx, ip, "Chord.Identify", nonce, &identity)
+	if err != nil {
+		node.log.WithField("target", ip).WithError(err).Debug("Identify error.")
+		return nil, err
+	}
+	id, err := identity.Verify(rpc.SignMessage(identifyUsage, nonce, ip))
+	if err != nil {
+		node.log.WithField("target", ip).WithError(err).Warn("Verifying identity error.")
+		return nil, rpc.UnverifiedError(err)
+	}
+	node.idLock.Lock()
+	node.ids[ip] = id
+	node.idLock.Unlock()
+	return id, nil
+}
+
+// 节点上线且身份经过验证时给出其ID
+func (node *Node) reachable(ip string) (*big.Int, bool) {
+	if !node.Ping(ip) {
+		return nil, false
+	}
+	id, err := node.getId(ip)
+	return id, err == nil
+}
+
+// 已验证并缓存的节点ID(不发起远端调用)
+func (node *Node) cachedId(ip string) (*big.Int, bool) {
+	if ip == node.IP {
+		return node.ID, true
+	}
+	node.idLock.RLock()
+	id, ok := node.ids[ip]
+	node.idLock.RUnlock()
+	return id, ok
+}
+
+// 节点下线后删去缓存的ID(该地址上的新节点可能持有不同的私钥)
+func (node *Node) forgetId(ip string) {
+	node.idLock.Lock()
+	delete(node.ids, ip)
+	node.idLock.Unlock()
+}
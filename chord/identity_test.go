@@ -0,0 +1,74 @@
+package chord
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"dht/logging"
+	"dht/rpc"
+)
+
+// 冒充者：应答Ping，但签名使用的不是自身地址(例如转发别的节点的签名)
+type impostor struct {
+	key ed25519.PrivateKey
+}
+
+func (impostor) Ping(_ Null, _ *Null) error {
+	return nil
+}
+
+func (impostor impostor) Identify(nonce []byte, identity *rpc.Identity) error {
+	*identity = rpc.Sign(impostor.key, rpc.SignMessage(identifyUsage, nonce, "node-victim"))
+	return nil
+}
+
+func TestUnverifiedPeerIsUnreachable(t *testing.T) {
+	transport := rpc.NewMemoryTransport()
+	logger, _ := logging.New(logging.Options{Output: io.Discard})
+	node := new(Node)
+	node.SetLogger(logger)
+	if !node.Init("node-0") {
+		t.Fatal("initializing node error")
+	}
+	node.RPC.Transport = transport
+	node.Run()
+	defer node.ForceQuit()
+	node.Create()
+
+	key, _ := rpc.GenerateKey()
+	fake := &rpc.NodeRpc{Transport: transport}
+	fake.Register("Chord", impostor{key})
+	start, quit := make(chan bool), make(chan bool)
+	go fake.Serve("node-fake", start, quit)
+	defer close(quit)
+	select {
+	case <-start:
+	case <-time.After(time.Second):
+		t.Fatal("serving time out")
+	}
+
+	if id, err := node.getId("node-fake"); err == nil || !errors.Is(err, rpc.ErrOffline) {
+		t.Fatalf("impostor got id %v, error %v", id, err)
+	}
+	if _, ok := node.cachedId("node-fake"); ok {
+		t.Fatal("impostor id cached")
+	}
+	if _, ok := node.reachable("node-fake"); ok {
+		t.Fatal("impostor answering Ping should still be unreachable")
+	}
+	if err := node.Notifty("node-fake"); err == nil {
+		t.Fatal("notification from an impostor accepted")
+	}
+	node.preLock.RLock()
+	predecessor := node.predecessor
+	node.preLock.RUnlock()
+	if predecessor == "node-fake" {
+		t.Fatal("impostor became the predecessor")
+	}
+	if _, err := node.getId("node-missing"); err == nil {
+		t.Fatal("node without listener got an id")
+	}
+}
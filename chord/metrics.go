@@ -0,0 +1,81 @@
+package chord
+
+import (
+	"net"
+
+	"dht/metrics"
+)
+
+// 注册节点的指标(在Init时调用)
+func (node *Node) newRegistry() *metrics.Registry {
+	registry := metrics.NewRegistry()
+	registry.Gauge("dht_online", "Whether the node is online.", func() []metrics.Sample {
+		online := 0.0
+		if node.Online {
+			online = 1
+		}
+		return []metrics.Sample{{Value: online}}
+	})
+	registry.Counter("dht_rpc_calls_total", "Remote calls sent by the node, by method and outcome.", node.RPC.Calls(), "method", "outcome")
+	registry.Gauge("dht_rpc_client_pool_idle", "Idle clients in the client pool of each peer.", func() []metrics.Sample {
+		out := []metrics.Sample{}
+		for ip, size := range node.RPC.PoolSizes() {
+			out = append(out, metrics.Sample{Labels: map[string]string{"peer": ip}, Value: float64(size)})
+		}
+		return out
+	})
+	registry.Gauge("dht_stored_keys", "Keys stored by the node, primary data and backup data.", func() []metrics.Sample {
+		node.dataLock.RLock()
+		data := node.data.Size()
+		node.dataLock.RUnlock()
+		node.dataBackupLock.RLock()
+		backup := node.dataBackup.Size()
+		node.dataBackupLock.RUnlock()
+		return []metrics.Sample{
+			{Labels: map[string]string{"store": "data"}, Value: float64(data)},
+			{Labels: map[string]string{"store": "backup"}, Value: float64(backup)},
+		}
+	})
+	registry.Summary("dht_stabilize_duration_seconds", "Time spent in each stabilize round.", &node.stabilizeTime)
+	registry.Summary("dht_fix_finger_duration_seconds", "Time spent in each fixFinger round.", &node.fixFingerTime)
+	registry.Gauge("dht_maintain_interval_seconds", "Current interval of each periodic maintenance task.", func() []metrics.Sample {
+		out := []metrics.Sample{}
+		for _, schedule := range node.schedules() {
+			out = append(out, metrics.Sample{Labels: map[string]string{"task": schedule.task}, Value: schedule.interval().Seconds()})
+		}
+		return out
+	})
+	registry.Counter("dht_maintain_rounds_total", "Rounds of each periodic maintenance task.", &node.maintainStats.rounds, "task")
+	registry.Counter("dht_maintain_rounds_saved_total", "Rounds not run compared with always running at the minimum interval.", &node.maintainStats.savedRounds, "task")
+	registry.Counter("dht_maintain_messages_saved_total", "Estimated messages saved by the skipped rounds (at least the messages of one round each).", &node.maintainStats.savedMessages, "task")
+	registry.Histogram("dht_lookup_hops", "Hops of successful lookups started by the node.", node.hops.Counts)
+	return registry
+}
+
+// 节点的指标
+func (node *Node) Metrics() *metrics.Registry {
+	return node.registry
+}
+
+// 在addr(如"127.0.0.1:9100")上启动HTTP服务，于/metrics给出节点的指标，节点退出时停止，返回实际监听的地址
+func (node *Node) ServeMetrics(addr string) (net.Addr, error) {
+	listener, err := metrics.Serve(addr, node.registry)
+	if err != nil {
+		node.log.WithField("address", addr).WithError(err).Error("Serving metrics error.")
+		return nil, err
+	}
+	node.httpLock.Lock()
+	node.httpListeners = append(node.httpListeners, listener)
+	node.httpLock.Unlock()
+	return listener.Addr(), nil
+}
+
+// 停止指标与管理接口的HTTP服务
+func (node *Node) closeHTTP() {
+	node.httpLock.Lock()
+	for _, listener := range node.httpListeners {
+		listener.Close()
+	}
+	node.httpListeners = nil
+	node.httpLock.Unlock()
+}
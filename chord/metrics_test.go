@@ -0,0 +1,80 @@
+package chord
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 经HTTP读取节点的指标
+func scrape(t *testing.T, node *Node) string {
+	t.Helper()
+	server := httptest.NewServer(node.Metrics())
+	defer server.Close()
+	response, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// 取值为正的样本，name含标签
+func hasPositive(output, name string) bool {
+	match := regexp.MustCompile("(?m)^" + regexp.QuoteMeta(name) + " ([0-9.e+]+)$").FindStringSubmatch(output)
+	if match == nil {
+		return false
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	return err == nil && value > 0
+}
+
+func TestMetrics(t *testing.T) {
+	nodes := newTestRing(t, 5)
+	for i := 0; i < 20; i++ {
+		if !nodes[0].Put("key"+strconv.Itoa(i), "value") {
+			t.Fatalf("putting key%d error", i)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+	output := scrape(t, nodes[0])
+	for _, name := range []string{
+		`dht_online`,
+		`dht_rpc_calls_total{method="Chord.Identify",outcome="ok"}`,
+		`dht_rpc_calls_total{method="Chord.Notifty",outcome="ok"}`,
+		`dht_stabilize_duration_seconds_count`,
+		`dht_fix_finger_duration_seconds_count`,
+		`dht_maintain_interval_seconds{task="stabilize"}`,
+		`dht_maintain_rounds_total{task="stabilize"}`,
+		`dht_maintain_rounds_total{task="fix_finger"}`,
+		`dht_lookup_hops_bucket{le="+Inf"}`,
+	} {
+		if !hasPositive(output, name) {
+			t.Errorf("%s not positive in:\n%s", name, output)
+		}
+	}
+	stored := 0
+	for _, node := range nodes {
+		match := regexp.MustCompile(`(?m)^dht_stored_keys\{store="data"\} (\d+)$`).FindStringSubmatch(scrape(t, node))
+		if match == nil {
+			t.Fatal("stored keys not found")
+		}
+		count, _ := strconv.Atoi(match[1])
+		stored += count
+	}
+	if stored != 20 {
+		t.Fatalf("%d keys stored, want 20", stored)
+	}
+	nodes[0].Quit()
+	if output := scrape(t, nodes[0]); !regexp.MustCompile(`(?m)^dht_online 0$`).MatchString(output) {
+		t.Fatal("quitted node still online")
+	}
+}
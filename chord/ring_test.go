@@ -0,0 +1,904 @@
+package chord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dht/config"
+	"dht/logging"
+	"dht/rpc"
+	"dht/trace"
+	"dht/version"
+)
+
+// 在进程内的传输层上启动size个节点并组成环(节点0创建网络，其余依次加入)
+func newTestRing(t *testing.T, size int) []*Node {
+	t.Helper()
+	return newTestRingWith(t, rpc.NewMemoryTransport(), size, nil)
+}
+
+// 同newTestRing，setup不为空时在各节点Run之前调用
+func newTestRingWith(t *testing.T, transport rpc.Transport, size int, setup func(i int, node *Node)) []*Node {
+	t.Helper()
+	logger, _ := logging.New(logging.Options{Output: io.Discard})
+	nodes := make([]*Node, size)
+	for i := range nodes {
+		node := new(Node)
+		node.SetLogger(logger)
+		if !node.Init(fmt.Sprintf("node-%d", i)) {
+			t.Fatal("initializing node error")
+		}
+		node.RPC.Transport = transport
+		if setup != nil {
+			setup(i, node)
+		}
+		node.Run()
+		nodes[i] = node
+	}
+	t.Cleanup(func() {
+		for _, node := range nodes {
+			node.ForceQuit()
+		}
+	})
+	nodes[0].Create()
+	for i := 1; i < size; i++ {
+		if !nodes[i].Join(nodes[0].IP) {
+			t.Fatalf("node %d joining error", i)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	waitRing(t, nodes)
+	return nodes
+}
+
+// 等待在线节点沿后继组成一个完整的环
+func waitRing(t *testing.T, nodes []*Node) {
+	t.Helper()
+	online := 0
+	for _, node := range nodes {
+		if node.Online {
+			online++
+		}
+	}
+	deadline := time.Now().Add(20 * time.Second)
+	for time.Now().Before(deadline) {
+		start := firstOnline(nodes)
+		visited := map[string]bool{}
+		ip := start.IP
+		for !visited[ip] {
+			visited[ip] = true
+			ip = nodeByIP(nodes, ip).GetSuccessorList()[0]
+			if nodeByIP(nodes, ip) == nil || !nodeByIP(nodes, ip).Online {
+				break
+			}
+		}
+		if ip == start.IP && len(visited) == online {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("ring not stabilized")
+}
+
+func firstOnline(nodes []*Node) *Node {
+	for _, node := range nodes {
+		if node.Online {
+			return node
+		}
+	}
+	return nil
+}
+
+func nodeByIP(nodes []*Node, ip string) *Node {
+	for _, node := range nodes {
+		if node.IP == ip {
+			return node
+		}
+	}
+	return nil
+}
+
+// 从各在线节点轮流读取，返回读取失败或值不对的键数
+func checkKeys(nodes []*Node, data map[string]string) int {
+	online := []*Node{}
+	for _, node := range nodes {
+		if node.Online {
+			online = append(online, node)
+		}
+	}
+	fail, i := 0, 0
+	for key, value := range data {
+		ok, got := online[i%len(online)].Get(key)
+		if !ok || got != value {
+			fail++
+		}
+		i++
+	}
+	return fail
+}
+
+func TestRingOverMemoryTransport(t *testing.T) {
+	testRingOverMemoryTransport(t, nil)
+}
+
+// 所有节点使用迭代查找
+func TestRingOverMemoryTransportIterative(t *testing.T) {
+	testRingOverMemoryTransport(t, func(i int, node *Node) {
+		node.SetIterativeLookup(true)
+	})
+}
+
+// 存入数据后正常退出、强制退出部分节点，数据不丢失
+func testRingOverMemoryTransport(t *testing.T, setup func(i int, node *Node)) {
+	size := 30
+	if testing.Short() {
+		size = 10
+	}
+	nodes := newTestRingWith(t, rpc.NewMemoryTransport(), size, setup)
+	data := map[string]string{}
+	for i := 0; i < 200; i++ {
+		key, value := "key"+strconv.Itoa(i), "value"+strconv.Itoa(i)
+		if !nodes[i%size].Put(key, value) {
+			t.Fatalf("putting %s error", key)
+		}
+		data[key] = value
+	}
+	if fail := checkKeys(nodes, data); fail != 0 {
+		t.Fatalf("%d keys not found", fail)
+	}
+	for i := 1; i < size; i += 5 { //正常退出
+		nodes[i].Quit()
+		time.Sleep(100 * time.Millisecond)
+	}
+	waitRing(t, nodes)
+	if fail := checkKeys(nodes, data); fail != 0 {
+		t.Fatalf("%d keys lost after quitting", fail)
+	}
+	nodes[3].ForceQuit() //强制退出不相邻的节点，由备份恢复
+	time.Sleep(500 * time.Millisecond)
+	nodes[size-2].ForceQuit()
+	waitRing(t, nodes)
+	time.Sleep(time.Second)
+	if fail := checkKeys(nodes, data); fail != 0 {
+		t.Fatalf("%d keys lost after force quitting", fail)
+	}
+	for key := range data {
+		if !nodes[0].Delete(key) {
+			t.Fatalf("deleting %s error", key)
+		}
+	}
+	if ok, _ := nodes[size-1].Get("key0"); ok {
+		t.Fatal("deleted key still found")
+	}
+}
+
+// 复制因子为3时，相邻两个节点同时强制退出不丢失数据
+func TestRingAdjacentForceQuit(t *testing.T) {
+	size := 10
+	nodes := newTestRingWith(t, rpc.NewMemoryTransport(), size, func(i int, node *Node) {
+		if err := node.SetReplica(3); err != nil {
+			t.Fatal(err)
+		}
+	})
+	data := map[string]string{}
+	for i := 0; i < 100; i++ {
+		key, value := "key"+strconv.Itoa(i), "value"+strconv.Itoa(i)
+		if !nodes[i%size].Put(key, value) {
+			t.Fatalf("putting %s error", key)
+		}
+		data[key] = value
+	}
+	first := nodes[2]
+	second := nodeByIP(nodes, first.GetSuccessorList()[0])
+	first.ForceQuit()
+	second.ForceQuit()
+	waitRing(t, nodes)
+	time.Sleep(time.Second)
+	if fail := checkKeys(nodes, data); fail != 0 {
+		t.Fatalf("%d keys lost after force quitting two adjacent nodes", fail)
+	}
+}
+
+// 经过内层传输层，down中的地址不可达(已建立的连接也不能再写入)，用于使某个副本只对一个节点不可达，
+// 其他节点的维护不受影响，后继列表不会因此改变
+type partitionTransport struct {
+	rpc.Transport
+	lock sync.Mutex
+	down map[string]bool
+}
+
+func (transport *partitionTransport) setDown(ip string) {
+	transport.lock.Lock()
+	transport.down[ip] = true
+	transport.lock.Unlock()
+}
+
+func (transport *partitionTransport) isDown(ip string) bool {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+	return transport.down[ip]
+}
+
+func (transport *partitionTransport) Dial(ip string, timeOut time.Duration) (net.Conn, error) {
+	if transport.isDown(ip) {
+		return nil, errors.New("partitioned: " + ip)
+	}
+	conn, err := transport.Transport.Dial(ip, timeOut)
+	if err != nil {
+		return nil, err
+	}
+	return &partitionConn{conn, ip, transport}, nil
+}
+
+type partitionConn struct {
+	net.Conn
+	ip        string
+	transport *partitionTransport
+}
+
+func (conn *partitionConn) Write(b []byte) (int, error) {
+	if conn.transport.isDown(conn.ip) {
+		return 0, errors.New("partitioned: " + conn.ip)
+	}
+	return conn.Conn.Write(b)
+}
+
+// 返回主数据中有该键的节点的下标
+func ownerOf(nodes []*Node, key string) int {
+	for i, node := range nodes {
+		if _, ok := node.GetOut(key); ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// 主节点的第2个备份不可达时，QUORUM的读写成功，ALL的读写返回rpc.ErrUnavailable
+func TestRingConsistencyWithReplicaDown(t *testing.T) {
+	memory := rpc.NewMemoryTransport()
+	transports := make([]*partitionTransport, 6)
+	nodes := newTestRingWith(t, memory, len(transports), func(i int, node *Node) {
+		transports[i] = &partitionTransport{Transport: memory, down: map[string]bool{}}
+		node.RPC.Transport = transports[i]
+	})
+	ctx := context.Background()
+	if err := nodes[0].PutConsistency(ctx, "key", "value1", rpc.ALL); err != nil {
+		t.Fatalf("putting with all replicas online: %v", err)
+	}
+	i := ownerOf(nodes, "key")
+	if i < 0 {
+		t.Fatal("no owner of the key")
+	}
+	owner := nodes[i]
+	transports[i].setDown(owner.GetSuccessorList()[1])
+	if err := owner.PutConsistency(ctx, "key", "value2", rpc.QUORUM); err != nil {
+		t.Fatalf("putting at quorum with a replica down: %v", err)
+	}
+	if value, err := owner.GetConsistency(ctx, "key", rpc.QUORUM); err != nil || value != "value2" {
+		t.Fatalf("getting at quorum with a replica down: %q, %v", value, err)
+	}
+	if err := owner.PutConsistency(ctx, "key", "value3", rpc.ALL); !errors.Is(err, rpc.ErrUnavailable) {
+		t.Fatalf("putting at all with a replica down: %v, want %v", err, rpc.ErrUnavailable)
+	}
+	if _, err := owner.GetConsistency(ctx, "key", rpc.ALL); !errors.Is(err, rpc.ErrUnavailable) {
+		t.Fatalf("getting at all with a replica down: %v, want %v", err, rpc.ErrUnavailable)
+	}
+	if value, err := owner.GetConsistency(ctx, "key", rpc.QUORUM); err != nil || value != "value3" { //未满足ALL的写入不回滚
+		t.Fatalf("getting at quorum after a failed put at all: %q, %v", value, err)
+	}
+}
+
+// 只应答Ping、GetSuccessorList与PutInBackup的副本，记录被调用的次数
+type fakeReplica struct {
+	successors []string
+	lists      *atomic.Int64
+	puts       *atomic.Int64
+}
+
+func (fakeReplica) Ping(_ Null, _ *Null) error {
+	return nil
+}
+
+func (replica fakeReplica) GetSuccessorList(_ Null, successorList *[]string) error {
+	replica.lists.Add(1)
+	*successorList = replica.successors
+	return nil
+}
+
+func (replica fakeReplica) PutInBackup(_ []DataPair, _ *Null) error {
+	replica.puts.Add(1)
+	return nil
+}
+
+// 写入使用stabilize维护的后继列表，只在副本没有应答时更新后继列表并改写到新的副本
+func TestReplicaWritesUseSuccessorList(t *testing.T) {
+	transport := rpc.NewMemoryTransport()
+	logger, _ := logging.New(logging.Options{Output: io.Discard})
+	cfg := config.Default()
+	cfg.Chord.StabilizeInterval = config.Duration(time.Hour) //测试期间不进行周期性维护
+	cfg.Chord.MaxStabilizeInterval = cfg.Chord.StabilizeInterval
+	cfg.Chord.FixFingerInterval = cfg.Chord.StabilizeInterval
+	cfg.Chord.MaxFixFingerInterval = cfg.Chord.StabilizeInterval
+	node := new(Node)
+	node.SetLogger(logger)
+	key, _ := rpc.GenerateKey()
+	if !node.InitWithConfig("node-0", key, cfg) {
+		t.Fatal("initializing node error")
+	}
+	node.RPC.Transport = transport
+	node.Run()
+	defer node.ForceQuit()
+	node.Create()
+	time.Sleep(100 * time.Millisecond) //等待第一轮维护结束
+
+	lists := &atomic.Int64{}
+	puts := map[string]*atomic.Int64{}
+	quits := map[string]chan bool{}
+	ips := []string{"fake-1", "fake-2", "fake-3", "fake-4"}
+	for i, ip := range ips {
+		puts[ip] = &atomic.Int64{}
+		fake := &rpc.NodeRpc{Transport: transport}
+		fake.Register("Chord", fakeReplica{append(ips[i+1:], node.IP), lists, puts[ip]})
+		start := make(chan bool)
+		quits[ip] = make(chan bool)
+		go fake.Serve(ip, start, quits[ip])
+		select {
+		case <-start:
+		case <-time.After(time.Second):
+			t.Fatal("serving time out")
+		}
+	}
+	defer func() {
+		for _, ip := range ips[1:] {
+			close(quits[ip])
+		}
+	}()
+	node.sucLock.Lock()
+	copy(node.successorList, ips)
+	node.sucLock.Unlock()
+
+	data := []DataPair{{Key: "key"}}
+	for i := 0; i < 10; i++ {
+		if err := node.putInReplicas(data, rpc.ALL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lists.Load() != 0 {
+		t.Fatalf("successor list fetched %d times while all replicas answered", lists.Load())
+	}
+	if puts["fake-1"].Load() != 10 || puts["fake-2"].Load() != 10 || puts["fake-3"].Load() != 0 {
+		t.Fatalf("backup writes: %d, %d, %d, want 10, 10, 0", puts["fake-1"].Load(), puts["fake-2"].Load(), puts["fake-3"].Load())
+	}
+
+	close(quits["fake-1"]) //第一个副本离开，改写到下一个后继
+	time.Sleep(50 * time.Millisecond)
+	if err := node.putInReplicas(data, rpc.ALL); err != nil {
+		t.Fatalf("putting with a replica gone: %v", err)
+	}
+	if lists.Load() == 0 { //发现后继离开后维护也会恢复，可能不止一次
+		t.Fatal("successor list not fetched after a replica left")
+	}
+	if puts["fake-2"].Load() != 11 || puts["fake-3"].Load() == 0 {
+		t.Fatalf("backup writes after a replica left: %d, %d, want 11 and at least 1", puts["fake-2"].Load(), puts["fake-3"].Load())
+	}
+	if replicas := node.replicaList(); len(replicas) != 2 || replicas[0] != "fake-2" || replicas[1] != "fake-3" {
+		t.Fatalf("replicas %v, want [fake-2 fake-3]", replicas)
+	}
+}
+
+// 按级别读取时，备份中模式不同的数据与主数据一样视为不存在；删除不存在的键返回rpc.ErrNotFound
+func TestRingLevelReadMode(t *testing.T) {
+	nodes := newTestRing(t, 4)
+	ctx := context.Background()
+	if err := nodes[0].PutModeContext(ctx, "key", "value", APPEND); err != nil {
+		t.Fatal(err)
+	}
+	i := ownerOf(nodes, "key")
+	if i < 0 {
+		t.Fatal("no owner of the key")
+	}
+	owner := nodes[i]
+	owner.dataLock.Lock() //只留下备份中的数据
+	owner.data.Delete("key")
+	owner.dataLock.Unlock()
+	if _, err := owner.GetConsistency(ctx, "key", rpc.QUORUM); !errors.Is(err, rpc.ErrNotFound) {
+		t.Fatalf("getting an append-mode backup as overwrite: %v, want %v", err, rpc.ErrNotFound)
+	}
+	if value, err := owner.getMode(ctx, "key", modeAppend, rpc.QUORUM); err != nil || value != "value" {
+		t.Fatalf("getting the append-mode backup: %q, %v", value, err)
+	}
+	if err := nodes[0].DeleteContext(ctx, "missing"); !errors.Is(err, rpc.ErrNotFound) {
+		t.Fatalf("deleting a missing key: %v, want %v", err, rpc.ErrNotFound)
+	}
+}
+
+// 删除与读取按同样的规则查找数据：前驱异常下线时备份中的数据可被删除，过期数据视为不存在
+func TestRingDeleteAgreesWithGet(t *testing.T) {
+	nodes := newTestRing(t, 4)
+	ctx := context.Background()
+	if err := nodes[0].PutContext(ctx, "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	i := ownerOf(nodes, "key")
+	if i < 0 {
+		t.Fatal("no owner of the key")
+	}
+	owner := nodes[i]
+	successor := nodeByIP(nodes, owner.GetSuccessorList()[0])
+	owner.Lock() //阻塞stabilize，使后继保持前驱下线的标记
+	defer owner.Unlock()
+	successor.Lock()
+	defer successor.Unlock()
+	successor.preLock.Lock()
+	successor.predecessor = "OFFLINE"
+	successor.preLock.Unlock()
+	if _, ok := successor.GetOutVersions("key", modeOverwrite); !ok {
+		t.Fatal("backup not readable with the predecessor offline")
+	}
+	if found, _ := successor.deleteOff([]KeyModePair{{"key", modeOverwrite}}); !found[0] {
+		t.Fatal("backup readable but not deletable with the predecessor offline")
+	}
+	if _, ok := successor.GetOutVersions("key", modeOverwrite); ok {
+		t.Fatal("backup still readable after deleting")
+	}
+
+	if err := nodes[0].PutTTL(ctx, "expired", "value", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	for _, node := range nodes {
+		if _, ok := node.GetOutVersions("expired", modeOverwrite); ok {
+			t.Fatalf("%s: expired key readable", node.IP)
+		}
+		if found, _ := node.deleteOff([]KeyModePair{{"expired", modeOverwrite}}); found[0] {
+			t.Fatalf("%s: expired key deleted as if present", node.IP)
+		}
+	}
+}
+
+// 两个节点基于同一时钟并发写入，读取时得到两个版本；基于两者合并后的时钟写入后只剩一个版本
+func TestRingConcurrentVersions(t *testing.T) {
+	nodes := newTestRing(t, 4)
+	ctx := context.Background()
+	if err := nodes[0].PutContext(ctx, "key", "value0"); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := nodes[1].GetVersions(ctx, "key")
+	if err != nil || len(versions) != 1 {
+		t.Fatalf("getting versions: %v, %v", versions, err)
+	}
+	clock := versions.Clock()
+	if err := nodes[1].PutVersion(ctx, "key", "value1", clock); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes[2].PutVersion(ctx, "key", "value2", clock); err != nil {
+		t.Fatal(err)
+	}
+	versions, err = nodes[3].GetVersions(ctx, "key")
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("got %v, %v, want two siblings", versions, err)
+	}
+	if versions[0].Clock.Compare(versions[1].Clock) != version.Concurrent {
+		t.Fatalf("siblings are not concurrent: %v", versions)
+	}
+	values := map[string]bool{versions[0].Value: true, versions[1].Value: true}
+	if !values["value1"] || !values["value2"] {
+		t.Fatalf("got siblings %v, want value1 and value2", versions)
+	}
+	if err := nodes[3].PutVersion(ctx, "key", "value3", versions.Clock()); err != nil {
+		t.Fatal(err)
+	}
+	versions, err = nodes[0].GetVersions(ctx, "key")
+	if err != nil || len(versions) != 1 || versions[0].Value != "value3" {
+		t.Fatalf("got %v, %v, want value3 only", versions, err)
+	}
+}
+
+// 节点的主数据与备份数据中该键的所有副本
+func copiesOf(node *Node, key string) []ValuePair {
+	copies := []ValuePair{}
+	node.dataLock.RLock()
+	if valuePair, ok := node.data.Get(key); ok {
+		copies = append(copies, valuePair)
+	}
+	node.dataLock.RUnlock()
+	node.dataBackupLock.RLock()
+	if valuePair, ok := node.dataBackup.Get(key); ok {
+		copies = append(copies, valuePair)
+	}
+	node.dataBackupLock.RUnlock()
+	return copies
+}
+
+// 带过期时间的数据随加入时的TransferData转移后，各副本的过期时间不变，过期后主数据与备份中都被清除
+func TestRingTTLAfterTransfer(t *testing.T) {
+	transport := rpc.NewMemoryTransport()
+	nodes := newTestRingWith(t, transport, 5, nil)
+	ctx := context.Background()
+	ttl := 1500 * time.Millisecond
+	keys := []string{}
+	for i := 0; i < 40; i++ {
+		key := "ttl" + strconv.Itoa(i)
+		if err := nodes[i%len(nodes)].PutTTL(ctx, key, "value", ttl); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	if err := nodes[0].PutContext(ctx, "keep", "value"); err != nil {
+		t.Fatal(err)
+	}
+	logger, _ := logging.New(logging.Options{Output: io.Discard})
+	joiner := new(Node)
+	joiner.SetLogger(logger)
+	if !joiner.Init("node-joiner") {
+		t.Fatal("initializing node error")
+	}
+	joiner.RPC.Transport = transport
+	joiner.Run()
+	t.Cleanup(joiner.ForceQuit)
+	if !joiner.Join(nodes[0].IP) {
+		t.Fatal("joining error")
+	}
+	nodes = append(nodes, joiner)
+	waitRing(t, nodes)
+	time.Sleep(300 * time.Millisecond)
+	transferred := 0
+	for _, key := range keys {
+		expireTime := time.Time{}
+		for _, node := range nodes {
+			for _, valuePair := range copiesOf(node, key) {
+				if valuePair.ExpireTime.IsZero() {
+					t.Fatalf("copy of %s on %s lost its expire time", key, node.IP)
+				}
+				if !expireTime.IsZero() && !valuePair.ExpireTime.Equal(expireTime) {
+					t.Fatalf("copies of %s expire at %v and %v", key, expireTime, valuePair.ExpireTime)
+				}
+				expireTime = valuePair.ExpireTime
+			}
+		}
+		if expireTime.IsZero() {
+			t.Fatalf("%s lost before expiring", key)
+		}
+		transferred += len(copiesOf(joiner, key))
+	}
+	if transferred == 0 {
+		t.Fatal("no data transferred to the joining node")
+	}
+	time.Sleep(ttl)
+	for _, key := range keys {
+		if ok, _ := nodes[0].Get(key); ok {
+			t.Fatalf("expired key %s still found", key)
+		}
+	}
+	for _, node := range nodes {
+		node.expire()
+	}
+	for _, key := range keys {
+		for _, node := range nodes {
+			if copies := copiesOf(node, key); len(copies) != 0 {
+				t.Fatalf("expired key %s still stored on %s", key, node.IP)
+			}
+		}
+	}
+	if ok, value := joiner.Get("keep"); !ok || value != "value" {
+		t.Fatal("key without ttl lost")
+	}
+}
+
+// 条件写入：值不符时返回rpc.ErrConditionFailed，计数器从不存在的键开始计数、拒绝非整数的值，并发的PutIfAbsent只有一个成功
+func TestRingConditionalPut(t *testing.T) {
+	nodes := newTestRing(t, 4)
+	ctx := context.Background()
+	if err := nodes[0].PutContext(ctx, "key", "value1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes[1].CompareAndSwap(ctx, "key", "wrong", "value2"); !errors.Is(err, rpc.ErrConditionFailed) {
+		t.Fatalf("swapping with a wrong expected value: %v, want %v", err, rpc.ErrConditionFailed)
+	}
+	if err := nodes[1].CompareAndSwap(ctx, "missing", "", "value2"); !errors.Is(err, rpc.ErrConditionFailed) {
+		t.Fatalf("swapping a missing key: %v, want %v", err, rpc.ErrConditionFailed)
+	}
+	if err := nodes[1].CompareAndSwap(ctx, "key", "value1", "value2"); err != nil {
+		t.Fatalf("swapping with the current value: %v", err)
+	}
+	if value, err := nodes[2].GetContext(ctx, "key"); err != nil || value != "value2" {
+		t.Fatalf("got %q, %v after swapping, want value2", value, err)
+	}
+
+	if count, err := nodes[2].Increment(ctx, "counter", 5); err != nil || count != 5 {
+		t.Fatalf("incrementing a missing key: %d, %v, want 5", count, err)
+	}
+	if count, err := nodes[3].Increment(ctx, "counter", -2); err != nil || count != 3 {
+		t.Fatalf("incrementing a counter: %d, %v, want 3", count, err)
+	}
+	if _, err := nodes[3].Increment(ctx, "key", 1); err == nil || errors.Is(err, rpc.ErrConditionFailed) {
+		t.Fatalf("incrementing a non-numeric value: %v, want a distinct error", err)
+	}
+	if value, _ := nodes[0].GetContext(ctx, "key"); value != "value2" {
+		t.Fatalf("non-numeric value changed to %q", value)
+	}
+
+	for round := 0; round < 10; round++ { //两个节点同时占用同一个键
+		key := "lock" + strconv.Itoa(round)
+		var wins, conflicts int
+		var lock sync.Mutex
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				err := nodes[i].PutIfAbsent(ctx, key, "owner"+strconv.Itoa(i))
+				lock.Lock()
+				defer lock.Unlock()
+				switch {
+				case err == nil:
+					wins++
+				case errors.Is(err, rpc.ErrConditionFailed):
+					conflicts++
+				default:
+					t.Errorf("racing PutIfAbsent: %v", err)
+				}
+			}(i)
+		}
+		wg.Wait()
+		if wins != 1 || conflicts != 1 {
+			t.Fatalf("%d PutIfAbsent won and %d conflicted on %s, want exactly one of each", wins, conflicts, key)
+		}
+		if value, err := nodes[3].GetContext(ctx, key); err != nil || (value != "owner0" && value != "owner1") {
+			t.Fatalf("got %q, %v for %s", value, err, key)
+		}
+	}
+}
+
+// 模式不再编码在键的末尾："foo"的append模式与"fooa"、"foob"的overwrite模式是不同的键
+func TestRingModeKeysDoNotCollide(t *testing.T) {
+	nodes := newTestRing(t, 4)
+	ctx := context.Background()
+	if err := nodes[0].PutModeContext(ctx, "foo", "x", APPEND); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes[1].PutModeContext(ctx, "foo", "y", APPEND); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes[2].PutModeContext(ctx, "fooa", "overwritten", OVERWRITE); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes[3].PutContext(ctx, "foob", "plain"); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		key, mode, want string
+	}{
+		{"foo", APPEND, "xy"},
+		{"foo", "append", "xy"},
+		{"fooa", OVERWRITE, "overwritten"},
+		{"foob", "overwrite", "plain"},
+	}
+	for _, c := range cases {
+		if value, err := nodes[1].GetModeContext(ctx, c.key, c.mode); err != nil || value != c.want {
+			t.Errorf("GetMode(%q, %q) = %q, %v, want %q", c.key, c.mode, value, err, c.want)
+		}
+	}
+	if _, err := nodes[2].GetContext(ctx, "foo"); !errors.Is(err, rpc.ErrNotFound) {
+		t.Errorf("getting an append-mode key as overwrite: %v, want %v", err, rpc.ErrNotFound)
+	}
+	if err := nodes[2].PutContext(ctx, "foo", "z"); !errors.Is(err, rpc.ErrModeConflict) {
+		t.Errorf("overwriting an append-mode key: %v, want %v", err, rpc.ErrModeConflict)
+	}
+}
+
+// 保持顺序的键空间：分布在多个节点上的键按顺序扫描，并遵守上界与数量限制
+func TestRingScanOrdered(t *testing.T) {
+	nodes := newTestRingWith(t, rpc.NewMemoryTransport(), 8, func(i int, node *Node) {
+		node.SetOrderPreserving(true)
+	})
+	ctx := context.Background()
+	keys := []string{}
+	for b := 0; b < 16; b++ { //首字节覆盖整个ID空间
+		keys = append(keys, string([]byte{byte(b*16 + 8)})+"key")
+	}
+	keys = append(keys, "p\xfe", "p\xff1", "p\xff2", "q", "\xff\xffa", "\xff\xffb")
+	for i, key := range keys {
+		if err := nodes[i%len(nodes)].PutContext(ctx, key, "value-"+key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(keys)
+	owners := map[int]bool{}
+	for _, key := range keys {
+		owners[ownerOf(nodes, key)] = true
+	}
+	if len(owners) < 3 {
+		t.Fatalf("keys are stored on %d nodes, want several", len(owners))
+	}
+
+	cases := []struct {
+		name       string
+		start, end string
+		prefix     bool
+		limit      int
+		want       []string
+	}{
+		{"all", "", "", false, 0, keys},
+		{"limit", "", "", false, 5, keys[:5]},
+		{"end key", "\x28", "\x68key", false, 0, []string{"\x28key", "\x38key", "\x48key", "\x58key"}},
+		{"end key and limit", "\x28", "\x68key", false, 2, []string{"\x28key", "\x38key"}},
+		{"empty range", "q", "p", false, 0, []string{}},
+		{"prefix ending in 0xff", "p\xff", "", true, 0, []string{"p\xff1", "p\xff2"}},
+		{"prefix of 0xff bytes", "\xff\xff", "", true, 0, []string{"\xff\xffa", "\xff\xffb"}},
+	}
+	for _, c := range cases {
+		var pairs []ScanPair
+		var err error
+		if c.prefix {
+			pairs, err = nodes[3].ScanPrefix(ctx, c.start, c.limit)
+		} else {
+			pairs, err = nodes[5].Scan(ctx, c.start, c.end, c.limit)
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		got := []string{}
+		for _, pair := range pairs {
+			if pair.Value != "value-"+pair.Key {
+				t.Errorf("%s: %q has value %q", c.name, pair.Key, pair.Value)
+			}
+			got = append(got, pair.Key)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("%s: scanned %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// 批量读写落在多个主节点上的键，不存在的键在结果中给出rpc.ErrNotFound
+func TestRingMulti(t *testing.T) {
+	nodes := newTestRing(t, 8)
+	ctx := context.Background()
+	data := map[string]string{}
+	keys := []string{}
+	for i := 0; i < 40; i++ {
+		key := "multi" + strconv.Itoa(i)
+		data[key] = "value" + strconv.Itoa(i)
+		keys = append(keys, key)
+	}
+	for key, err := range nodes[0].MultiPut(ctx, data) {
+		if err != nil {
+			t.Fatalf("putting %s: %v", key, err)
+		}
+	}
+	owners := map[int]bool{}
+	for _, key := range keys {
+		owners[ownerOf(nodes, key)] = true
+	}
+	if len(owners) < 3 || owners[-1] {
+		t.Fatalf("keys are stored on nodes %v, want several owners", owners)
+	}
+
+	values, errs := nodes[3].MultiGet(ctx, append([]string{"missing"}, keys...))
+	for _, key := range keys {
+		if errs[key] != nil || values[key] != data[key] {
+			t.Fatalf("got %q, %v for %s, want %q", values[key], errs[key], key, data[key])
+		}
+	}
+	if !errors.Is(errs["missing"], rpc.ErrNotFound) {
+		t.Fatalf("getting a missing key: %v, want %v", errs["missing"], rpc.ErrNotFound)
+	}
+
+	errs = nodes[5].MultiDelete(ctx, append([]string{"missing"}, keys[:20]...))
+	for _, key := range keys[:20] {
+		if errs[key] != nil {
+			t.Fatalf("deleting %s: %v", key, errs[key])
+		}
+	}
+	if !errors.Is(errs["missing"], rpc.ErrNotFound) {
+		t.Fatalf("deleting a missing key: %v, want %v", errs["missing"], rpc.ErrNotFound)
+	}
+	values, errs = nodes[6].MultiGet(ctx, keys)
+	for i, key := range keys {
+		if i < 20 && !errors.Is(errs[key], rpc.ErrNotFound) {
+			t.Fatalf("deleted key %s: %q, %v", key, values[key], errs[key])
+		}
+		if i >= 20 && (errs[key] != nil || values[key] != data[key]) {
+			t.Fatalf("kept key %s: %q, %v", key, values[key], errs[key])
+		}
+	}
+}
+
+// 指针表修正后，30个节点的环上查找的平均跳数不超过log2(30)
+func TestRingHopCount(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waiting for finger tables")
+	}
+	size := 30
+	nodes := newTestRing(t, size)
+	bound := math.Log2(float64(size))
+	var mean float64
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		var histogram trace.Histogram
+		for i := 0; i < 200; i++ {
+			_, tr, err := nodes[i%size].FindSuccessorTrace(context.Background(), getHash("hop"+strconv.Itoa(i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			histogram.Observe(tr.HopCount())
+		}
+		mean = histogram.Mean()
+		if mean <= bound {
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	t.Fatalf("mean hop count %.2f, want at most %.2f", mean, bound)
+}
+
+// 可被多个协程同时写入的缓冲
+type syncBuffer struct {
+	buf  bytes.Buffer
+	lock sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+// 节点(包括其远端调用)输出的每条日志都是合法的JSON，带有该节点的地址与ID字段
+func TestRingLogFields(t *testing.T) {
+	out := &syncBuffer{}
+	logger, err := logging.New(logging.Options{Level: "debug", JSON: true, Output: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := newTestRingWith(t, rpc.NewMemoryTransport(), 3, func(i int, node *Node) {
+		node.SetLogger(logger)
+	})
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		if err := nodes[i%3].PutContext(ctx, "key"+strconv.Itoa(i), "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nodes[1].GetContext(ctx, "missing")
+	nodes[2].Quit()
+	ids := map[string]string{}
+	for _, node := range nodes {
+		ids[node.IP] = fmt.Sprintf("%040x", node.ID)[:8]
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) < 10 {
+		t.Fatalf("only %d records logged", len(lines))
+	}
+	for _, line := range lines {
+		record := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid JSON record %q: %v", line, err)
+		}
+		ip, _ := record["ip"].(string)
+		if id, ok := ids[ip]; !ok || record["id"] != id {
+			t.Fatalf("record without the node's ip and id: %s", line)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package chord
 
 import (
+	"context"
 	"errors"
 	"math/big"
+
+	"dht/rpc"
+	"dht/version"
 )
 
 type RPCWrapper struct {
@@ -14,16 +18,50 @@ func (wrapper *RPCWrapper) FindSuccessor(id *big.Int, ip *string) (err error) {
 	return err
 }
 
-func (wrapper *RPCWrapper) FindPredecessor(id *big.Int, ip *string) (err error) {
-	*ip, err = wrapper.node.FindPredecessor(id)
+func (wrapper *RPCWrapper) FindPredecessor(pair IdDeadlinePair, ip *string) (err error) {
+	ctx := context.Background()
+	if !pair.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, pair.Deadline)
+		defer cancel()
+	}
+	*ip, err = wrapper.node.FindPredecessorContext(ctx, pair.Id)
 	return err
 }
 
+func (wrapper *RPCWrapper) ClosestPreceding(id *big.Int, reply *LookupReply) error {
+	if !wrapper.node.Online { //已下线的节点不再参与查找
+		return rpc.ErrOffline
+	}
+	*reply = wrapper.node.ClosestPreceding(id)
+	return nil
+}
+
+func (wrapper *RPCWrapper) FindPredecessorTrace(pair IdDeadlinePair, reply *PathReply) error {
+	ctx := context.Background()
+	if !pair.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, pair.Deadline)
+		defer cancel()
+	}
+	ip, hops, err := wrapper.node.findPredecessor(ctx, pair.Id)
+	*reply = PathReply{ip, hops, rpc.EncodeError(err)}
+	return nil
+}
+
 func (wrapper *RPCWrapper) GetSuccessor(_ Null, ip *string) (err error) {
 	*ip, err = wrapper.node.GetSuccessor()
 	return err
 }
 
+func (wrapper *RPCWrapper) Routing(_ Null, state *RoutingState) error {
+	if !wrapper.node.Online {
+		return rpc.ErrOffline
+	}
+	*state = wrapper.node.Routing()
+	return nil
+}
+
 func (wrapper *RPCWrapper) GetPredecessor(_ Null, ip *string) (err error) {
 	*ip, err = wrapper.node.GetPredecessor()
 	return err
@@ -38,7 +76,7 @@ func (wrapper *RPCWrapper) ChangePredecessor(ip string, _ *Null) error {
 	return nil
 }
 
-func (wrapper *RPCWrapper) ChangeSuccessorList(list [3]string, _ *Null) error {
+func (wrapper *RPCWrapper) ChangeSuccessorList(list []string, _ *Null) error {
 	wrapper.node.ChangeSuccessorList(list)
 	return nil
 }
@@ -47,10 +85,15 @@ func (wrapper *RPCWrapper) Ping(_ Null, _ *Null) error {
 	if wrapper.node.Online {
 		return nil
 	}
-	return errors.New("Offline node.")
+	return rpc.ErrOffline
 }
 
-func (wrapper *RPCWrapper) GetSuccessorList(_ Null, successorList *[3]string) error {
+func (wrapper *RPCWrapper) Identify(nonce []byte, identity *rpc.Identity) error {
+	*identity = wrapper.node.Identify(nonce)
+	return nil
+}
+
+func (wrapper *RPCWrapper) GetSuccessorList(_ Null, successorList *[]string) error {
 	*successorList = wrapper.node.GetSuccessorList()
 	return nil
 }
@@ -63,33 +106,90 @@ func (wrapper *RPCWrapper) PutInAll(data []DataPair, _ *Null) error {
 	return wrapper.node.PutInAll(data)
 }
 
+func (wrapper *RPCWrapper) PutInAllLevel(pair LevelDataPairs, _ *Null) error {
+	return wrapper.node.PutInAllLevel(pair.Data, pair.Level)
+}
+
+func (wrapper *RPCWrapper) PutInCondition(pair ConditionPair, value *string) (err error) {
+	*value, err = wrapper.node.PutInCondition(pair)
+	return err
+}
+
 func (wrapper *RPCWrapper) PutInBackup(data []DataPair, _ *Null) error {
 	return wrapper.node.PutInBackup(data)
 }
 
+func (wrapper *RPCWrapper) PutInMulti(data []DataPair, messages *[]string) error {
+	*messages = wrapper.node.PutInMulti(data)
+	return nil
+}
+
+func (wrapper *RPCWrapper) SetIn(data []DataPair, _ *Null) error {
+	return wrapper.node.SetIn(data)
+}
+
+func (wrapper *RPCWrapper) SetInBackup(data []DataPair, _ *Null) error {
+	return wrapper.node.SetInBackup(data)
+}
+
 func (wrapper *RPCWrapper) GetOut(key string, value *string) error {
 	ok := false
 	*value, ok = wrapper.node.GetOut(key)
 	if ok {
 		return nil
 	} else {
-		return errors.New("Get out error.")
+		return rpc.ErrNotFound
 	}
 }
 
-func (wrapper *RPCWrapper) TransferData(ips IpPair, _ *Null) error {
-	return wrapper.node.TransferData(ips)
+func (wrapper *RPCWrapper) GetOutVersions(pair KeyModePair, versions *version.Siblings) error {
+	ok := false
+	*versions, ok = wrapper.node.GetOutVersions(pair.Key, pair.Mode)
+	if ok {
+		return nil
+	} else {
+		return rpc.ErrNotFound
+	}
+}
+
+func (wrapper *RPCWrapper) GetOutMulti(pairs []KeyModePair, versions *[]version.Siblings) error {
+	*versions = wrapper.node.GetOutMulti(pairs)
+	return nil
 }
 
-func (wrapper *RPCWrapper) DeleteOffAll(keys []string, _ *Null) error {
-	ok := wrapper.node.DeleteOffAll(keys)
+func (wrapper *RPCWrapper) GetOutBackup(pair KeyModePair, versions *version.Siblings) error {
+	ok := false
+	*versions, ok = wrapper.node.GetOutBackup(pair.Key, pair.Mode)
 	if ok {
 		return nil
 	} else {
-		return errors.New("Delete off error.")
+		return rpc.ErrNotFound
 	}
 }
 
+func (wrapper *RPCWrapper) GetOutLevel(pair LevelKeyPair, versions *version.Siblings) (err error) {
+	*versions, err = wrapper.node.GetOutLevel(pair.Key, pair.Mode, pair.Level)
+	return err
+}
+
+func (wrapper *RPCWrapper) ScanOut(scanRange ScanRange, pairs *[]ScanPair) error {
+	*pairs = wrapper.node.ScanOut(scanRange)
+	return nil
+}
+
+func (wrapper *RPCWrapper) TransferData(ips IpPair, _ *Null) error {
+	return wrapper.node.TransferData(ips)
+}
+
+func (wrapper *RPCWrapper) DeleteOffAll(pairs []KeyModePair, _ *Null) error {
+	return wrapper.node.DeleteOffAll(pairs)
+}
+
+func (wrapper *RPCWrapper) DeleteOffMulti(pairs []KeyModePair, messages *[]string) error {
+	*messages = wrapper.node.DeleteOffMulti(pairs)
+	return nil
+}
+
 func (wrapper *RPCWrapper) DeleteOffBackup(keys []string, _ *Null) error {
 	ok := wrapper.node.DeleteOffBackup(keys)
 	if ok {
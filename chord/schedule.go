@@ -0,0 +1,127 @@
+package chord
+
+import (
+	"sync"
+	"time"
+
+	"dht/metrics"
+)
+
+// 每轮维护至少发出的消息数，用于估计节省的消息：
+// stabilize为Ping与GetSuccessorList(后继)、GetPredecessor、Notifty；fixFinger为一次FindSuccessor
+const stabilizeMessages = 4
+const fixFingerMessages = 1
+
+// 变化后至少按最小间隔进行的轮数：stabilize一轮即可确认邻居不变；
+// fixFinger每轮只修复一项，需依次修复完路由表(第2至160项)才能确认不再变化
+const stabilizeSettleRounds = 1
+const fixFingerSettleRounds = 159
+
+// 节点维护的统计(标签为维护的种类)
+type maintainStats struct {
+	rounds        metrics.CounterVec //进行的轮数
+	savedRounds   metrics.CounterVec //与始终按最小间隔维护相比少进行的轮数
+	savedMessages metrics.CounterVec //由少进行的轮数估计的少发出的消息数
+}
+
+// 自适应的维护间隔：连续settle轮维护的结果没有变化后，每轮间隔翻倍(至多为max)，
+// 结果变化或收到变动的通知(churn)时恢复为min，并唤醒正在等待的维护
+type schedule struct {
+	task     string
+	min      time.Duration
+	max      time.Duration
+	settle   int     //结果连续不变多少轮后开始放慢
+	messages float64 //每轮至少发出的消息数
+	stats    *maintainStats
+	lock     sync.Mutex
+	current  time.Duration
+	quiet    int       //结果连续不变的轮数
+	churn    bool      //本轮进行中收到了变动的通知
+	wake     chan bool //容量为1，变动时放入
+}
+
+func newSchedule(task string, min, max time.Duration, settle int, messages float64, stats *maintainStats) *schedule {
+	return &schedule{task: task, min: min, max: max, settle: settle, messages: messages, stats: stats, current: min, wake: make(chan bool, 1)}
+}
+
+// 当前的间隔
+func (schedule *schedule) interval() time.Duration {
+	schedule.lock.Lock()
+	defer schedule.lock.Unlock()
+	return schedule.current
+}
+
+// 记录一轮维护的结果，得到下一轮前的间隔
+func (schedule *schedule) done(changed bool) {
+	schedule.lock.Lock()
+	if changed || schedule.churn {
+		schedule.current = schedule.min
+		schedule.quiet = 0
+	} else {
+		schedule.quiet++
+		if schedule.quiet >= schedule.settle && schedule.current < schedule.max {
+			schedule.current *= 2
+			if schedule.current > schedule.max {
+				schedule.current = schedule.max
+			}
+		}
+	}
+	schedule.churn = false
+	schedule.lock.Unlock()
+	schedule.stats.rounds.Inc(schedule.task)
+}
+
+// 收到变动的通知：恢复最小间隔，并立即开始下一轮
+func (schedule *schedule) reset() {
+	schedule.lock.Lock()
+	schedule.current = schedule.min
+	schedule.churn = true
+	schedule.lock.Unlock()
+	select {
+	case schedule.wake <- true:
+	default:
+	}
+}
+
+// 等待至下一轮，并记录与按最小间隔维护相比少进行的轮数
+func (schedule *schedule) wait() {
+	start := time.Now()
+	interval := schedule.interval()
+	timer := time.NewTimer(interval)
+	select {
+	case <-timer.C:
+	case <-schedule.wake:
+		timer.Stop()
+	}
+	waited := time.Since(start)
+	if waited > interval { //不计入计时器的误差
+		waited = interval
+	}
+	saved := float64(waited)/float64(schedule.min) - 1
+	if saved > 0 {
+		schedule.stats.savedRounds.Add(saved, schedule.task)
+		schedule.stats.savedMessages.Add(saved*schedule.messages, schedule.task)
+	}
+}
+
+// 前驱与后继列表，比较一轮stabilize前后是否变化
+func (node *Node) neighbours() []string {
+	node.preLock.RLock()
+	predecessor := node.predecessor
+	node.preLock.RUnlock()
+	return append([]string{predecessor}, node.GetSuccessorList()...)
+}
+
+// 收到变动的通知(前驱、后继变化或有节点未应答)，stabilize与fixFinger恢复最小间隔
+func (node *Node) churn() {
+	if node.stabilizeSched == nil { //未初始化
+		return
+	}
+	node.stabilizeSched.reset()
+	node.fixFingerSched.reset()
+}
+
+// 节点的各项自适应维护(用于指标)
+func (node *Node) schedules() []*schedule {
+	return []*schedule{node.stabilizeSched, node.fixFingerSched}
+}
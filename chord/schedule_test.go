@@ -0,0 +1,119 @@
+package chord
+
+import (
+	"testing"
+	"time"
+)
+
+// 依次记录各轮的结果，检查每轮后的间隔
+func checkIntervals(t *testing.T, schedule *schedule, results []bool, want []time.Duration) {
+	t.Helper()
+	for i, changed := range results {
+		schedule.done(changed)
+		if got := schedule.interval(); got != want[i] {
+			t.Fatalf("round %d: interval %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func TestScheduleBackoff(t *testing.T) {
+	ms := time.Millisecond
+	schedule := newSchedule("test", 10*ms, 50*ms, 1, 4, &maintainStats{})
+	checkIntervals(t, schedule,
+		[]bool{false, false, false, false, true, false},
+		[]time.Duration{20 * ms, 40 * ms, 50 * ms, 50 * ms, 10 * ms, 20 * ms})
+
+	//收到变动的通知后立即恢复，进行中的一轮即使没有变化也不放慢
+	schedule.reset()
+	if got := schedule.interval(); got != 10*ms {
+		t.Fatalf("interval %v after reset, want 10ms", got)
+	}
+	checkIntervals(t, schedule, []bool{false, false}, []time.Duration{10 * ms, 20 * ms})
+	if rounds := schedule.stats.rounds.Get("test"); rounds != 8 {
+		t.Fatalf("%v rounds, want 8", rounds)
+	}
+}
+
+func TestScheduleSettle(t *testing.T) {
+	ms := time.Millisecond
+	schedule := newSchedule("test", 10*ms, 40*ms, 3, 1, &maintainStats{})
+	checkIntervals(t, schedule,
+		[]bool{false, false, false, false, true, false, false, false},
+		[]time.Duration{10 * ms, 10 * ms, 20 * ms, 40 * ms, 10 * ms, 10 * ms, 10 * ms, 20 * ms})
+	schedule.reset()
+	checkIntervals(t, schedule, []bool{false, false, false}, []time.Duration{10 * ms, 10 * ms, 10 * ms})
+	checkIntervals(t, schedule, []bool{false}, []time.Duration{20 * ms})
+}
+
+func TestScheduleWait(t *testing.T) {
+	ms := time.Millisecond
+	stats := &maintainStats{}
+	schedule := newSchedule("test", 10*ms, 10*time.Second, 1, 4, stats)
+	for schedule.interval() < 10*time.Second {
+		schedule.done(false)
+	}
+
+	//等待中收到变动的通知时立即开始下一轮
+	go func() {
+		time.Sleep(50 * ms)
+		schedule.reset()
+	}()
+	start := time.Now()
+	schedule.wait()
+	if waited := time.Since(start); waited > time.Second {
+		t.Fatalf("waited %v after reset", waited)
+	}
+	saved := stats.savedRounds.Get("test")
+	if saved < 3 || saved > 100 {
+		t.Fatalf("%v rounds saved in about 50ms, want about 4", saved)
+	}
+	if messages := stats.savedMessages.Get("test"); messages != saved*4 {
+		t.Fatalf("%v messages saved, want %v", messages, saved*4)
+	}
+
+	//多次通知只唤醒一次，按最小间隔等待时不计入节省的轮数
+	schedule.reset()
+	schedule.reset()
+	schedule.wait()
+	schedule.done(false)
+	schedule.wait()
+	if got := stats.savedRounds.Get("test"); got != saved {
+		t.Fatalf("%v rounds saved, want %v", got, saved)
+	}
+}
+
+func TestChurnResetsSchedules(t *testing.T) {
+	node := new(Node)
+	node.churn() //未初始化时忽略
+	if !node.Init("node-0") {
+		t.Fatal("initializing node error")
+	}
+	for _, schedule := range node.schedules() {
+		for schedule.interval() < schedule.max {
+			schedule.done(false)
+		}
+	}
+	node.churn()
+	for _, schedule := range node.schedules() {
+		if schedule.interval() != schedule.min {
+			t.Fatalf("%s: interval %v after churn, want %v", schedule.task, schedule.interval(), schedule.min)
+		}
+		select {
+		case <-schedule.wake:
+		default:
+			t.Fatalf("%s: not woken by churn", schedule.task)
+		}
+	}
+	//fixFinger需按最小间隔修复完整个路由表后才放慢
+	node.fixFingerSched.done(false) //收到通知时进行中的一轮
+	for i := 0; i < fixFingerSettleRounds-1; i++ {
+		node.fixFingerSched.done(false)
+	}
+	if node.fixFingerSched.interval() != node.fixFingerSched.min {
+		t.Fatal("fixFinger slowed down before refreshing all fingers")
+	}
+	node.fixFingerSched.done(false)
+	if node.fixFingerSched.interval() != 2*node.fixFingerSched.min {
+		t.Fatal("fixFinger not slowed down after refreshing all fingers")
+	}
+}
@@ -0,0 +1,272 @@
+package chord
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 数据存储接口，节点的主数据与备份数据各使用一个
+type Storage interface {
+	Get(key string) (ValuePair, bool)
+	Put(key string, valuePair ValuePair) error
+	Delete(key string) error
+	All() []DataPair
+	Size() int
+	Clear() error
+	Close() error
+}
+
+// 内存存储(默认)
+type MemoryStorage struct {
+	data map[string]ValuePair
+	lock sync.RWMutex
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{data: make(map[string]ValuePair)}
+}
+
+func (storage *MemoryStorage) Get(key string) (ValuePair, bool) {
+	storage.lock.RLock()
+	valuePair, ok := storage.data[key]
+	storage.lock.RUnlock()
+	return valuePair, ok
+}
+
+func (storage *MemoryStorage) Put(key string, valuePair ValuePair) error {
+	storage.lock.Lock()
+	storage.data[key] = valuePair
+	storage.lock.Unlock()
+	return nil
+}
+
+func (storage *MemoryStorage) Delete(key string) error {
+	storage.lock.Lock()
+	delete(storage.data, key)
+	storage.lock.Unlock()
+	return nil
+}
+
+func (storage *MemoryStorage) All() []DataPair {
+	storage.lock.RLock()
+	data := make([]DataPair, 0, len(storage.data))
+	for key, valuePair := range storage.data {
+		data = append(data, DataPair{key, valuePair})
+	}
+	storage.lock.RUnlock()
+	return data
+}
+
+func (storage *MemoryStorage) Size() int {
+	storage.lock.RLock()
+	defer storage.lock.RUnlock()
+	return len(storage.data)
+}
+
+func (storage *MemoryStorage) Clear() error {
+	storage.lock.Lock()
+	storage.data = make(map[string]ValuePair)
+	storage.lock.Unlock()
+	return nil
+}
+
+func (storage *MemoryStorage) Close() error {
+	return nil
+}
+
+const snapshotFile = "snapshot"
+const logFile = "log"
+const compactLogSize = 10000 //日志记录数超过该值(且超过数据量)时，写入快照并清空日志
+
+// 日志记录
+type logRecord struct {
+	Delete bool
+	Key    string
+	Values ValuePair
+}
+
+// 磁盘存储：内存中保留全部数据，每次修改追加写入日志，日志过长时写入快照
+type DiskStorage struct {
+	MemoryStorage
+	dir     string
+	log     *os.File
+	writer  *bufio.Writer
+	logSize int
+	logLock sync.Mutex
+}
+
+// 打开dir中的磁盘存储(不存在时创建)，并由快照与日志恢复数据
+func NewDiskStorage(dir string) (*DiskStorage, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	storage := &DiskStorage{MemoryStorage: MemoryStorage{data: make(map[string]ValuePair)}, dir: dir}
+	err = storage.load()
+	if err != nil {
+		return nil, err
+	}
+	storage.log, err = os.OpenFile(filepath.Join(dir, logFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	storage.writer = bufio.NewWriter(storage.log)
+	return storage, nil
+}
+
+// 读取快照并重放日志
+func (storage *DiskStorage) load() error {
+	snapshot, err := os.ReadFile(filepath.Join(storage.dir, snapshotFile))
+	if err == nil {
+		err = json.Unmarshal(snapshot, &storage.data)
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	log, err := os.Open(filepath.Join(storage.dir, logFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer log.Close()
+	scanner := bufio.NewScanner(log)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		record := logRecord{}
+		if json.Unmarshal(scanner.Bytes(), &record) != nil {
+			break //最后一条记录可能因异常退出而不完整
+		}
+		if record.Delete {
+			delete(storage.data, record.Key)
+		} else {
+			storage.data[record.Key] = record.Values
+		}
+		storage.logSize++
+	}
+	return nil
+}
+
+func (storage *DiskStorage) Put(key string, valuePair ValuePair) error {
+	storage.MemoryStorage.Put(key, valuePair)
+	return storage.append(logRecord{false, key, valuePair})
+}
+
+func (storage *DiskStorage) Delete(key string) error {
+	storage.MemoryStorage.Delete(key)
+	return storage.append(logRecord{true, key, ValuePair{}})
+}
+
+func (storage *DiskStorage) Clear() error {
+	storage.MemoryStorage.Clear()
+	storage.logLock.Lock()
+	defer storage.logLock.Unlock()
+	return storage.compact()
+}
+
+func (storage *DiskStorage) Close() error {
+	storage.logLock.Lock()
+	defer storage.logLock.Unlock()
+	if storage.log == nil {
+		return nil
+	}
+	err := storage.writer.Flush()
+	if err == nil {
+		err = storage.log.Sync()
+	}
+	storage.log.Close()
+	storage.log = nil
+	return err
+}
+
+// 追加一条日志记录
+func (storage *DiskStorage) append(record logRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	storage.logLock.Lock()
+	defer storage.logLock.Unlock()
+	if storage.log == nil {
+		return os.ErrClosed
+	}
+	storage.writer.Write(append(line, '\n'))
+	err = storage.writer.Flush()
+	if err == nil {
+		err = storage.log.Sync() //写入磁盘后才返回，节点崩溃或断电时不丢失已确认的写入
+	}
+	if err != nil {
+		return err
+	}
+	storage.logSize++
+	if storage.logSize > compactLogSize && storage.logSize > storage.Size() {
+		return storage.compact()
+	}
+	return nil
+}
+
+// 写入快照并清空日志(需持有logLock)
+func (storage *DiskStorage) compact() error {
+	if storage.log == nil {
+		return os.ErrClosed
+	}
+	storage.lock.RLock()
+	snapshot, err := json.Marshal(storage.data)
+	storage.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(storage.dir, snapshotFile+".tmp")
+	err = writeFileSync(tmpPath, snapshot)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpPath, filepath.Join(storage.dir, snapshotFile))
+	if err != nil {
+		return err
+	}
+	err = syncDir(storage.dir) //改名写入目录后才清空日志，否则断电后可能只剩旧快照与已清空的日志
+	if err != nil {
+		return err
+	}
+	err = storage.log.Truncate(0)
+	if err != nil {
+		return err
+	}
+	storage.logSize = 0
+	return nil
+}
+
+// 写入文件并同步到磁盘(用于快照：先写入临时文件再改名，改名前需确保内容已落盘)
+func writeFileSync(path string, content []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(content)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// 将目录(其中的改名、新建)同步到磁盘
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package chord
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"dht/rpc"
+)
+
+func TestDiskStorageReload(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewDiskStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		storage.Put("key"+strconv.Itoa(i), ValuePair{Value: "value" + strconv.Itoa(i)})
+	}
+	storage.Delete("key0")
+	storage.Close()
+	storage, err = NewDiskStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if _, ok := storage.Get("key0"); ok {
+		t.Fatal("deleted key reloaded")
+	}
+	if value, ok := storage.Get("key9"); !ok || value.Value != "value9" {
+		t.Fatalf("reloaded %v, %v", value, ok)
+	}
+}
+
+func TestUseDiskStorageBeforeInit(t *testing.T) {
+	node := new(Node)
+	if err := node.UseDiskStorage(t.TempDir()); err == nil {
+		t.Fatal("using disk storage before Init should fail")
+	}
+}
+
+// 所有节点同时强制退出后以相同的地址与数据目录重启，ID不变，数据不丢失
+func TestRestartWithDiskStorage(t *testing.T) {
+	size := 5
+	dirs := make([]string, size)
+	for i := range dirs {
+		dirs[i] = t.TempDir()
+	}
+	useDisk := func(i int, node *Node) {
+		if err := node.UseDiskStorage(dirs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	transport := rpc.NewMemoryTransport()
+	nodes := newTestRingWith(t, transport, size, useDisk)
+	data := map[string]string{}
+	for i := 0; i < 100; i++ {
+		key, value := "key"+strconv.Itoa(i), "value"+strconv.Itoa(i)
+		if !nodes[i%size].Put(key, value) {
+			t.Fatalf("putting %s error", key)
+		}
+		data[key] = value
+	}
+	ids := make([]string, size)
+	for i, node := range nodes {
+		ids[i] = node.ID.String()
+		node.ForceQuit()
+	}
+	time.Sleep(200 * time.Millisecond) //等待原节点停止监听，释放地址
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, KeyFile)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nodes = newTestRingWith(t, transport, size, useDisk)
+	for i, node := range nodes {
+		if node.ID.String() != ids[i] {
+			t.Fatalf("node %d got a new ID after restarting", i)
+		}
+	}
+	if fail := checkKeys(nodes, data); fail != 0 {
+		t.Fatalf("%d keys lost after restarting", fail)
+	}
+}
+
+// 旧格式的快照(模式编码在键的末尾)在节点加入后迁移为显式的模式字段
+func TestMigrateLegacySnapshot(t *testing.T) {
+	dir := t.TempDir()
+	legacy := map[string]ValuePair{
+		"foob": {Value: "x\n", KeyId: getHash("foob")},   //"foo"的append模式
+		"bara": {Value: "value", KeyId: getHash("bara")}, //"bar"的overwrite模式
+	}
+	snapshot, err := json.Marshal(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "data", snapshotFile), snapshot, 0644); err != nil {
+		t.Fatal(err)
+	}
+	nodes := newTestRingWith(t, rpc.NewMemoryTransport(), 3, func(i int, node *Node) {
+		if i == 0 {
+			if err := node.UseDiskStorage(dir); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		left := 0
+		nodes[0].dataLock.RLock()
+		for key := range legacy {
+			if _, ok := nodes[0].data.Get(key); ok {
+				left++
+			}
+		}
+		nodes[0].dataLock.RUnlock()
+		if left == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d legacy keys not migrated", left)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if ok, value := nodes[1].GetMode("foo", "append"); !ok || value != "x\n" {
+		t.Fatalf("migrated append-mode key: %q, %v", value, ok)
+	}
+	if ok, value := nodes[2].Get("bar"); !ok || value != "value" {
+		t.Fatalf("migrated overwrite-mode key: %q, %v", value, ok)
+	}
+	if ok, _ := nodes[2].Get("bara"); ok {
+		t.Fatal("legacy key with its suffix still readable")
+	}
+}
@@ -14,6 +14,25 @@ func getHash(ip string) *big.Int {
 	return hashInt.SetBytes(hash[:])
 }
 
+// 保持顺序的键ID：键的前20字节(不足时以0补齐)，键的字典序较小时ID不大于另一个键的ID
+func orderedId(key string) *big.Int {
+	id := make([]byte, sha1.Size)
+	copy(id, key)
+	return new(big.Int).SetBytes(id)
+}
+
+// 得到大于所有以prefix为前缀的键的最小键(不存在时为空串，表示无上界)
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
 // 判断是否在目标区间内
 func belong(leftOpen, rightOpen bool, beg, end, tar *big.Int) bool {
 	cmpBegEnd, cmpTarBeg, cmpTarEnd := beg.Cmp(end), tar.Cmp(beg), tar.Cmp(end)
@@ -47,6 +66,38 @@ func belong(leftOpen, rightOpen bool, beg, end, tar *big.Int) bool {
 	return false
 }
 
+// 去除列表中的自身与重复项(小规模网络中后继列表可能重复)
+func distinctReplicas(list []string, self string) []string {
+	replicas := []string{}
+	for _, ip := range list {
+		if ip == self || ip == "" || ip == "OFFLINE" || contains(replicas, ip) {
+			continue
+		}
+		replicas = append(replicas, ip)
+	}
+	return replicas
+}
+
+// 得到在list中而不在other中的元素
+func exclude(list, other []string) []string {
+	out := []string{}
+	for _, ip := range list {
+		if !contains(other, ip) {
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+func contains(list []string, tar string) bool {
+	for _, ip := range list {
+		if ip == tar {
+			return true
+		}
+	}
+	return false
+}
+
 func initCal() {
 	for i := range exp {
 		exp[i] = new(big.Int).Lsh(big.NewInt(1), uint(i)) //exp[i]存储2^i
@@ -61,3 +112,25 @@ func cal(n *big.Int, i int) *big.Int {
 	}
 	return tmp
 }
+
+// 从from顺时针到id的距离，from与id重合时视为绕环一周
+func distance(from, id *big.Int) *big.Int {
+	d := new(big.Int).Sub(id, from)
+	if d.Sign() <= 0 {
+		d.Add(d, exp[160])
+	}
+	return d
+}
+
+// 两个列表是否逐项相同
+func equal(list, other []string) bool {
+	if len(list) != len(other) {
+		return false
+	}
+	for i := range list {
+		if list[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
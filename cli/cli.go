@@ -0,0 +1,248 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"dht/chat"
+	"dht/client"
+	"dht/daemon"
+	"dht/test"
+)
+
+// 退出码
+const (
+	ExitOK       = 0
+	ExitError    = 1 //操作失败或测试未通过
+	ExitUsage    = 2 //命令或参数错误
+	ExitNotFound = 3 //get的键不存在
+)
+
+const usage = `Usage: dht <command> [flags] [args]
+
+Commands:
+  node start   start a node that creates a new network
+  node join    start a node that joins an existing network
+  daemon       start a node and serve it to local applications over a Unix socket
+  put          put a key through a running node: dht put --node ADDR KEY VALUE
+  get          get a key through a running node: dht get --node ADDR KEY
+  delete       delete a key through a running node: dht delete --node ADDR KEY
+               (ADDR is the --client address of the node, not its --ip)
+               (use --socket PATH instead of --node to go through a daemon)
+  test         run the test program: dht test --protocol chord --part advance
+  chat         start the chat program: dht chat --name NAME --ip ADDR --password PASSWORD
+
+Flags come before positional arguments. Run "dht <command> -h" for the flags of a command.
+Exit codes: 0 success, 1 failure, 2 usage error, 3 key not found.
+`
+
+var stdout io.Writer = os.Stdout
+var stderr io.Writer = os.Stderr
+
+// 执行命令行(不包括程序名)，返回退出码
+func Run(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return ExitUsage
+	}
+	switch args[0] {
+	case "node":
+		return runNode(args[1:])
+	case "daemon":
+		return runDaemon(args[1:])
+	case "put", "get", "delete":
+		return runOperation(args[0], args[1:])
+	case "test":
+		return runTest(args[1:])
+	case "chat":
+		return runChat(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return ExitOK
+	}
+	fmt.Fprintf(stderr, "dht: unknown command %q\n\n%s", args[0], usage)
+	return ExitUsage
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet("dht "+name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	return flags
+}
+
+// 解析参数，-h时返回ExitOK，出错时返回ExitUsage，成功时返回-1
+func parse(flags *flag.FlagSet, args []string) int {
+	err := flags.Parse(args)
+	if err == flag.ErrHelp {
+		return ExitOK
+	}
+	if err != nil {
+		return ExitUsage
+	}
+	return -1
+}
+
+func usageError(flags *flag.FlagSet, message string) int {
+	fmt.Fprintf(stderr, "dht: %s\n", message)
+	flags.Usage()
+	return ExitUsage
+}
+
+func failure(err error) int {
+	fmt.Fprintf(stderr, "dht: %v\n", err)
+	if errors.Is(err, client.ErrNotFound) {
+		return ExitNotFound
+	}
+	return ExitError
+}
+
+// put/get/delete：通过运行中的节点读写
+func runOperation(name string, args []string) int {
+	flags := newFlagSet(name)
+	node := flags.String("node", os.Getenv("DHT_NODE"), "client address of a running node, given by its --client flag (default $DHT_NODE)")
+	socket := flags.String("socket", os.Getenv("DHT_SOCKET"), "socket of a running \"dht daemon\", used instead of --node (default $DHT_SOCKET)")
+	mode := flags.String("mode", "", "data mode, e.g. append (chord only; default overwrite)")
+	timeout := flags.Duration("timeout", client.DefaultTimeout, "timeout of the operation")
+	tlsOptions := &tlsOptions{}
+	tlsOptions.flags(flags)
+	if code := parse(flags, args); code >= 0 {
+		return code
+	}
+	want := map[string]int{"put": 2, "get": 1, "delete": 1}[name]
+	if flags.NArg() != want {
+		return usageError(flags, fmt.Sprintf("%s takes %d argument(s), got %d", name, want, flags.NArg()))
+	}
+	var remote *client.Remote
+	var err error
+	switch {
+	case *socket != "":
+		remote, err = daemon.Dial(*socket)
+		if err != nil {
+			return failure(fmt.Errorf("connecting to %s: %w", *socket, err))
+		}
+	case *node != "":
+		tlsConfig, err := tlsOptions.config()
+		if err != nil {
+			return usageError(flags, err.Error())
+		}
+		remote, err = client.Dial(*node, *timeout, tlsConfig)
+		if err != nil {
+			return failure(fmt.Errorf("connecting to %s: %w", *node, err))
+		}
+	default:
+		return usageError(flags, "--node or --socket is required")
+	}
+	defer remote.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	key := flags.Arg(0)
+	switch {
+	case name == "put" && *mode == "":
+		err = remote.Put(ctx, key, flags.Arg(1))
+	case name == "put":
+		err = remote.PutMode(ctx, key, flags.Arg(1), *mode)
+	case name == "get":
+		value := ""
+		if *mode == "" {
+			value, err = remote.Get(ctx, key)
+		} else {
+			value, err = remote.GetMode(ctx, key, *mode)
+		}
+		if err == nil {
+			fmt.Fprintln(stdout, value)
+		}
+	case *mode == "":
+		err = remote.Delete(ctx, key)
+	default:
+		err = remote.DeleteMode(ctx, key, *mode)
+	}
+	if err != nil {
+		return failure(err)
+	}
+	return ExitOK
+}
+
+// test：运行测试程序，全部通过时退出码为0
+func runTest(args []string) int {
+	flags := newFlagSet("test")
+	protocol := flags.String("protocol", "chord", "protocol to test: naive, chord or kademlia")
+	part := flags.String("part", "all", "part to test: basic, advance or all")
+	transport := flags.String("transport", "tcp", "transport between nodes: tcp or memory")
+	tls := flags.Bool("tls", false, "use mutual TLS between nodes")
+	if code := parse(flags, args); code >= 0 {
+		return code
+	}
+	if flags.NArg() != 0 {
+		return usageError(flags, "test takes no arguments")
+	}
+	if err := test.SetTransport(*transport); err != nil {
+		return usageError(flags, err.Error())
+	}
+	if err := test.SetTLS(*tls); err != nil {
+		return failure(err)
+	}
+	passed, err := test.Run(*protocol, *part)
+	if err != nil {
+		return usageError(flags, err.Error())
+	}
+	if !passed {
+		return ExitError
+	}
+	return ExitOK
+}
+
+// chat：给出账户时直接登录，否则进入原有的登录界面
+func runChat(args []string) int {
+	flags := newFlagSet("chat")
+	name := flags.String("name", "", "account name")
+	ip := flags.String("ip", "", "address of the chat node")
+	password := flags.String("password", "", "account password")
+	join := flags.String("join", "", "address of a node in the chat network (empty to create a new network)")
+	register := flags.Bool("register", false, "register a new account")
+	tlsOptions := &tlsOptions{}
+	tlsOptions.flags(flags)
+	if code := parse(flags, args); code >= 0 {
+		return code
+	}
+	if flags.NArg() != 0 {
+		return usageError(flags, "chat takes no arguments")
+	}
+	if *name == "" && *ip == "" && *password == "" {
+		chat.Chat()
+		return ExitOK
+	}
+	if *name == "" || *ip == "" || *password == "" {
+		return usageError(flags, "--name, --ip and --password should be given together")
+	}
+	tlsConfig, err := tlsOptions.config()
+	if err != nil {
+		return usageError(flags, err.Error())
+	}
+	err = chat.Run(*name, *ip, *password, *join, *register, tlsConfig)
+	if err != nil {
+		return failure(err)
+	}
+	return ExitOK
+}
+
+// 在后台运行fn，超过timeout未完成时返回错误
+func withTimeout(timeout time.Duration, message string, fn func() bool) error {
+	done := make(chan bool, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case ok := <-done:
+		if !ok {
+			return errors.New(message)
+		}
+		return nil
+	case <-time.After(timeout):
+		return errors.New(message + " (timeout)")
+	}
+}
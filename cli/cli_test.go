@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dht/chord"
+	"dht/client"
+	"dht/daemon"
+	"dht/logging"
+	"dht/rpc"
+)
+
+// 执行命令行，返回退出码与输出
+func run(t *testing.T, args ...string) (code int, out, errOut string) {
+	t.Helper()
+	var outBuffer, errBuffer bytes.Buffer
+	oldStdout, oldStderr := stdout, stderr
+	stdout, stderr = &outBuffer, &errBuffer
+	defer func() {
+		stdout, stderr = oldStdout, oldStderr
+	}()
+	code = Run(args)
+	return code, outBuffer.String(), errBuffer.String()
+}
+
+// 在进程内启动一个chord节点，并由守护进程在临时目录中的套接字上提供读写
+func startDaemon(t *testing.T) (*chord.Node, string) {
+	t.Helper()
+	logger, _ := logging.New(logging.Options{Output: io.Discard})
+	node := new(chord.Node)
+	node.SetLogger(logger)
+	if !node.Init("node-0") {
+		t.Fatal("initializing node error")
+	}
+	node.RPC.Transport = rpc.NewMemoryTransport()
+	node.Run()
+	node.Create()
+	t.Cleanup(node.ForceQuit)
+	path := filepath.Join(t.TempDir(), "dht.sock")
+	server, err := daemon.Listen(path, client.NewChord(node))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return node, path
+}
+
+func TestRunUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"nothing"},
+		{"get"},
+		{"get", "--socket", "dht.sock"},
+		{"get", "--socket", "dht.sock", "a", "b"},
+		{"put", "--socket", "dht.sock", "key"},
+		{"get", "--unknown", "key"},
+		{"test", "--transport", "udp"},
+		{"test", "extra"},
+		{"chat", "--name", "alice"},
+	} {
+		if code, _, errOut := run(t, args...); code != ExitUsage || errOut == "" {
+			t.Errorf("%q: exit code %d, stderr %q, want %d with a message", args, code, errOut, ExitUsage)
+		}
+	}
+	if code, out, _ := run(t, "help"); code != ExitOK || !strings.HasPrefix(out, "Usage:") {
+		t.Errorf("help: exit code %d, stdout %q", code, out)
+	}
+	if code, _, _ := run(t, "get", "-h"); code != ExitOK {
+		t.Errorf("get -h: exit code %d, want %d", code, ExitOK)
+	}
+}
+
+func TestRunOperations(t *testing.T) {
+	node, socket := startDaemon(t)
+	if code, _, errOut := run(t, "put", "--socket", socket, "key", "value"); code != ExitOK {
+		t.Fatalf("put: exit code %d, stderr %q", code, errOut)
+	}
+	if code, out, _ := run(t, "get", "--socket", socket, "key"); code != ExitOK || out != "value\n" {
+		t.Fatalf("get: exit code %d, stdout %q", code, out)
+	}
+	if code, _, errOut := run(t, "get", "--socket", socket, "missing"); code != ExitNotFound || errOut == "" {
+		t.Fatalf("getting a missing key: exit code %d, stderr %q, want %d", code, errOut, ExitNotFound)
+	}
+	if code, _, _ := run(t, "delete", "--socket", socket, "key"); code != ExitOK {
+		t.Fatalf("delete: exit code %d", code)
+	}
+	if code, _, _ := run(t, "delete", "--socket", socket, "key"); code != ExitNotFound {
+		t.Fatalf("deleting a deleted key: exit code %d, want %d", code, ExitNotFound)
+	}
+
+	node.ForceQuit() //守护进程仍在，节点已下线
+	if code, _, errOut := run(t, "get", "--socket", socket, "key"); code != ExitError || errOut == "" {
+		t.Fatalf("getting through an offline node: exit code %d, stderr %q, want %d", code, errOut, ExitError)
+	}
+	missing := filepath.Join(t.TempDir(), "missing.sock")
+	if code, _, _ := run(t, "get", "--socket", missing, "key"); code != ExitError {
+		t.Fatalf("no daemon: exit code %d, want %d", code, ExitError)
+	}
+	if code, _, _ := run(t, "get", "--node", "127.0.0.1:1", "--timeout", "200ms", "key"); code != ExitError {
+		t.Fatalf("no node: exit code %d, want %d", code, ExitError)
+	}
+}
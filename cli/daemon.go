@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"fmt"
+	"os/signal"
+
+	"dht/daemon"
+)
+
+// daemon：运行节点(给出--bootstrap时加入网络，否则创建)，并在Unix域套接字上为本机应用提供读写，直至收到SIGINT或SIGTERM
+func runDaemon(args []string) int {
+	flags, options := nodeFlags("daemon")
+	flags.StringVar(&options.bootstrap, "bootstrap", "", "address of a node in the network (default: create a new network)")
+	socket := flags.String("socket", "dht.sock", "path of the Unix socket for local applications")
+	if code := parse(flags, args); code >= 0 {
+		return code
+	}
+	if flags.NArg() != 0 {
+		return usageError(flags, "daemon takes no arguments")
+	}
+	if options.ip == "" {
+		return usageError(flags, "--ip is required")
+	}
+	if err := setLogger(options); err != nil {
+		return usageError(flags, err.Error())
+	}
+	signals := notifyQuit()
+	defer signal.Stop(signals)
+	node, err := startNode(*options)
+	if err != nil {
+		return failure(err)
+	}
+	server, err := daemon.Listen(*socket, node.client)
+	if err != nil {
+		node.Quit()
+		return failure(err)
+	}
+	fmt.Fprintf(stdout, "%s node running on %s, serving %s, press Ctrl-C to quit\n", options.protocol, options.ip, server.Path())
+	<-signals
+	fmt.Fprintln(stdout, "quitting...")
+	server.Close()
+	node.Quit()
+	return ExitOK
+}
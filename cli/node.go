@@ -0,0 +1,275 @@
+package cli
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"dht/chord"
+	"dht/client"
+	"dht/config"
+	"dht/kademlia"
+	"dht/logging"
+	"dht/rpc"
+
+	"github.com/sirupsen/logrus"
+)
+
+const StartTimeOut = 30 * time.Second //创建或加入网络的时限
+
+// 由命令行运行的节点(chord或kademlia)
+type hostedNode interface {
+	Run()
+	Create()
+	Join(ip string) bool
+	Quit()
+	ForceQuit()
+	UseDiskStorage(dir string) error
+	ServeAdmin(addr string) (net.Addr, error)
+	ServeMetrics(addr string) (net.Addr, error)
+}
+
+// 节点的配置
+type nodeOptions struct {
+	protocol    string
+	ip          string
+	bootstrap   string //为空时创建新的网络
+	keyFile     string
+	configFile  string //为空时使用默认配置
+	replica     int    //不为0时覆盖配置中的复制因子(只用于chord)
+	dataDir     string
+	adminAddr   string
+	metricsAddr string
+	clientAddr  string //为空时不提供Client服务
+	logLevel    string
+	logJSON     bool
+	logger      *logrus.Logger //由logLevel与logJSON得到
+	tls         tlsOptions
+}
+
+// TLS的证书文件(节点间、以及put/get/delete与节点的Client服务之间)，均为空时不使用TLS
+type tlsOptions struct {
+	certFile string
+	keyFile  string
+	caFile   string
+}
+
+// TLS相关的参数
+func (options *tlsOptions) flags(flags *flag.FlagSet) {
+	flags.StringVar(&options.certFile, "tls-cert", "", "PEM certificate of this side, enables mutual TLS with --tls-key and --tls-ca")
+	flags.StringVar(&options.keyFile, "tls-key", "", "PEM private key of the certificate")
+	flags.StringVar(&options.caFile, "tls-ca", "", "PEM certificate of the CA trusted for the other side")
+}
+
+// 读取证书得到TLS配置，未给出证书时返回nil
+func (options tlsOptions) config() (*tls.Config, error) {
+	if options.certFile == "" && options.keyFile == "" && options.caFile == "" {
+		return nil, nil
+	}
+	if options.certFile == "" || options.keyFile == "" || options.caFile == "" {
+		return nil, errors.New("--tls-cert, --tls-key and --tls-ca should be given together")
+	}
+	return rpc.LoadTLSConfig(options.certFile, options.keyFile, options.caFile)
+}
+
+// 按配置文件初始化节点，得到节点、其客户端包装与RPC服务
+func newNode(options nodeOptions) (hostedNode, client.Client, *rpc.NodeRpc, error) {
+	if options.keyFile != "" && options.dataDir != "" {
+		return nil, nil, nil, errors.New("--key cannot be used with --data, the key is kept in the data directory")
+	}
+	key, err := rpc.GenerateKey()
+	if options.keyFile != "" {
+		key, err = rpc.LoadOrGenerateKey(options.keyFile)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	tlsConfig, err := options.tls.config()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	nodeConfig := config.Default()
+	if options.configFile != "" {
+		nodeConfig, err = config.Load(options.configFile)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if options.replica != 0 {
+		if options.protocol != "chord" {
+			return nil, nil, nil, errors.New("--replica is only supported by chord")
+		}
+		nodeConfig.Chord.Replica = options.replica
+		if err := nodeConfig.Validate(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	switch options.protocol {
+	case "chord":
+		node := new(chord.Node)
+		node.SetLogger(options.logger)
+		if !node.InitWithConfig(options.ip, key, nodeConfig) {
+			return nil, nil, nil, errors.New("initializing node error")
+		}
+		node.RPC.TLSConfig = tlsConfig
+		return node, client.NewChord(node), &node.RPC, nil
+	case "kademlia":
+		node := new(kademlia.Node)
+		node.SetLogger(options.logger)
+		if err := node.InitWithConfig(options.ip, key, nodeConfig); err != nil {
+			return nil, nil, nil, err
+		}
+		node.RPC.TLSConfig = tlsConfig
+		return node, client.NewKademlia(node), &node.RPC, nil
+	}
+	return nil, nil, nil, fmt.Errorf("unknown protocol %q", options.protocol)
+}
+
+// 运行中的节点，以及单独监听的Client服务
+type runningNode struct {
+	hostedNode
+	client client.Client
+	server *client.Server //未给出--client时为nil
+}
+
+// 停止Client服务后正常退出
+func (node *runningNode) Quit() {
+	if node.server != nil {
+		node.server.Close()
+	}
+	node.hostedNode.Quit()
+}
+
+// 启动节点并创建或加入网络，按需启动管理接口与指标的HTTP服务，以及单独监听的Client服务
+func startNode(options nodeOptions) (*runningNode, error) {
+	node, nodeClient, nodeRpc, err := newNode(options)
+	if err != nil {
+		return nil, err
+	}
+	if options.dataDir != "" {
+		if err := node.UseDiskStorage(options.dataDir); err != nil {
+			return nil, err
+		}
+	}
+	node.Run()
+	if options.bootstrap == "" {
+		err = withTimeout(StartTimeOut, "creating network error", func() bool {
+			node.Create()
+			return true
+		})
+	} else {
+		err = withTimeout(StartTimeOut, "joining network error", func() bool {
+			return node.Join(options.bootstrap)
+		})
+	}
+	if err != nil {
+		node.ForceQuit()
+		return nil, err
+	}
+	if options.adminAddr != "" {
+		addr, err := node.ServeAdmin(options.adminAddr)
+		if err != nil {
+			node.ForceQuit()
+			return nil, err
+		}
+		fmt.Fprintf(stdout, "admin API on http://%s\n", addr)
+	}
+	if options.metricsAddr != "" {
+		addr, err := node.ServeMetrics(options.metricsAddr)
+		if err != nil {
+			node.ForceQuit()
+			return nil, err
+		}
+		fmt.Fprintf(stdout, "metrics on http://%s/metrics\n", addr)
+	}
+	running := &runningNode{hostedNode: node, client: nodeClient}
+	if options.clientAddr != "" { //与节点间的RPC服务分开，启用TLS时对方需出示由同一CA签发的证书
+		running.server, err = client.Listen(options.clientAddr, nodeClient, nodeRpc.TLSConfig)
+		if err != nil {
+			node.ForceQuit()
+			return nil, err
+		}
+		fmt.Fprintf(stdout, "client service on %s\n", running.server.Addr())
+	}
+	return running, nil
+}
+
+// 节点相关的参数
+func nodeFlags(name string) (*flag.FlagSet, *nodeOptions) {
+	options := &nodeOptions{}
+	flags := newFlagSet(name)
+	flags.StringVar(&options.protocol, "protocol", "chord", "protocol of the node: chord or kademlia")
+	flags.StringVar(&options.ip, "ip", "", "address the node listens on, e.g. 127.0.0.1:9000 (required)")
+	flags.StringVar(&options.keyFile, "key", "", "file holding the private key of the node, created if missing (default: the key in --data, or a new key)")
+	flags.StringVar(&options.configFile, "config", "", "JSON or YAML file tuning timeouts, connection pools and maintenance (default: built-in values)")
+	flags.IntVar(&options.replica, "replica", 0, "chord replication factor: copies of each key kept on the next successors, the same on every node (default: Chord.Replica of --config, 2)")
+	flags.StringVar(&options.dataDir, "data", "", "directory for disk storage (default: in memory)")
+	flags.StringVar(&options.adminAddr, "admin", "", "address of the admin HTTP API (default: disabled)")
+	flags.StringVar(&options.metricsAddr, "metrics", "", "address of the /metrics HTTP endpoint (default: disabled)")
+	flags.StringVar(&options.clientAddr, "client", "", "address serving put/get/delete to \"dht put --node\", separate from the node address; anyone reaching it can write, so keep it local or enable TLS (default: disabled)")
+	flags.StringVar(&options.logLevel, "log-level", "info", "log level: trace, debug, info, warn or error")
+	flags.BoolVar(&options.logJSON, "log-json", false, "write logs as JSON")
+	options.tls.flags(flags)
+	return flags, options
+}
+
+// node start/join：运行节点，直至收到SIGINT或SIGTERM后正常退出
+func runNode(args []string) int {
+	if len(args) == 0 || (args[0] != "start" && args[0] != "join") {
+		fmt.Fprintf(stderr, "dht: node takes a subcommand: start or join\n\n%s", usage)
+		return ExitUsage
+	}
+	flags, options := nodeFlags("node " + args[0])
+	if args[0] == "join" {
+		flags.StringVar(&options.bootstrap, "bootstrap", "", "address of a node in the network (required)")
+	}
+	if code := parse(flags, args[1:]); code >= 0 {
+		return code
+	}
+	if flags.NArg() != 0 {
+		return usageError(flags, "node takes no arguments")
+	}
+	if options.ip == "" {
+		return usageError(flags, "--ip is required")
+	}
+	if args[0] == "join" && options.bootstrap == "" {
+		return usageError(flags, "--bootstrap is required")
+	}
+	if err := setLogger(options); err != nil {
+		return usageError(flags, err.Error())
+	}
+	signals := notifyQuit()
+	defer signal.Stop(signals)
+	node, err := startNode(*options)
+	if err != nil {
+		return failure(err)
+	}
+	fmt.Fprintf(stdout, "%s node running on %s, press Ctrl-C to quit\n", options.protocol, options.ip)
+	<-signals
+	fmt.Fprintln(stdout, "quitting...")
+	node.Quit()
+	return ExitOK
+}
+
+// 由日志级别与格式得到节点的日志
+func setLogger(options *nodeOptions) error {
+	logger, err := logging.New(logging.Options{Level: options.logLevel, JSON: options.logJSON})
+	if err != nil {
+		return err
+	}
+	options.logger = logger
+	return nil
+}
+
+// 收到SIGINT或SIGTERM时通知
+func notifyQuit() chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	return signals
+}
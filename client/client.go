@@ -0,0 +1,247 @@
+// 包装chord、kademlia与naive节点的统一客户端，操作返回可区分的错误而不是bool
+package client
+
+import (
+	"context"
+
+	"dht/chord"
+	"dht/kademlia"
+	"dht/naive"
+	"dht/rpc"
+	"dht/version"
+)
+
+// 可通过errors.Is判断的错误值
+var (
+	ErrNotFound    = rpc.ErrNotFound    // 键不存在
+	ErrTimeout     = rpc.ErrTimeout     // 调用超时(同时视作context.DeadlineExceeded)
+	ErrNoRoute     = rpc.ErrNoRoute     // 找不到负责该键的节点
+	ErrOffline     = rpc.ErrOffline     // 节点已下线
+	ErrUnavailable = rpc.ErrUnavailable // 应答的副本数不满足一致性级别
+	ErrUnsupported = rpc.ErrUnsupported // 协议不支持该操作
+)
+
+// 一致性级别
+type Consistency = rpc.Consistency
+
+const (
+	ONE    = rpc.ONE
+	QUORUM = rpc.QUORUM
+	ALL    = rpc.ALL
+)
+
+// 统一的DHT客户端接口，Put/Get使用ONE级别，Get与Delete在键不存在时返回ErrNotFound
+type Client interface {
+	Put(ctx context.Context, key, value string) error
+	Get(ctx context.Context, key string) (string, error)
+	Delete(ctx context.Context, key string) error
+	PutConsistency(ctx context.Context, key, value string, level Consistency) error
+	GetConsistency(ctx context.Context, key string, level Consistency) (string, error)
+	PutVersion(ctx context.Context, key, value string, context version.Clock) error
+	GetVersions(ctx context.Context, key string) (version.Siblings, error)
+}
+
+// 支持数据模式(覆盖overwrite/添加append等)的客户端，目前只有chord
+type ModeClient interface {
+	PutMode(ctx context.Context, key, value, mode string) error
+	GetMode(ctx context.Context, key, mode string) (string, error)
+	DeleteMode(ctx context.Context, key, mode string) error
+}
+
+type chordClient struct {
+	node *chord.Node
+}
+
+type kademliaClient struct {
+	node *kademlia.Node
+}
+
+type naiveClient struct {
+	node *naive.Node
+}
+
+// 包装chord节点
+func NewChord(node *chord.Node) Client {
+	return &chordClient{node}
+}
+
+// 包装kademlia节点
+func NewKademlia(node *kademlia.Node) Client {
+	return &kademliaClient{node}
+}
+
+// 包装naive节点
+func NewNaive(node *naive.Node) Client {
+	return &naiveClient{node}
+}
+
+func (client *chordClient) Put(ctx context.Context, key, value string) error {
+	if !client.node.Online {
+		return ErrOffline
+	}
+	return client.node.PutContext(ctx, key, value)
+}
+
+func (client *chordClient) Get(ctx context.Context, key string) (string, error) {
+	if !client.node.Online {
+		return "", ErrOffline
+	}
+	return client.node.GetContext(ctx, key)
+}
+
+func (client *chordClient) PutConsistency(ctx context.Context, key, value string, level Consistency) error {
+	if !client.node.Online {
+		return ErrOffline
+	}
+	return client.node.PutConsistency(ctx, key, value, level)
+}
+
+func (client *chordClient) GetConsistency(ctx context.Context, key string, level Consistency) (string, error) {
+	if !client.node.Online {
+		return "", ErrOffline
+	}
+	return client.node.GetConsistency(ctx, key, level)
+}
+
+func (client *chordClient) PutVersion(ctx context.Context, key, value string, context version.Clock) error {
+	if !client.node.Online {
+		return ErrOffline
+	}
+	return client.node.PutVersion(ctx, key, value, context)
+}
+
+func (client *chordClient) GetVersions(ctx context.Context, key string) (version.Siblings, error) {
+	if !client.node.Online {
+		return nil, ErrOffline
+	}
+	return client.node.GetVersions(ctx, key)
+}
+
+func (client *chordClient) Delete(ctx context.Context, key string) error {
+	if !client.node.Online {
+		return ErrOffline
+	}
+	return client.node.DeleteContext(ctx, key)
+}
+
+func (client *chordClient) PutMode(ctx context.Context, key, value, mode string) error {
+	if !client.node.Online {
+		return ErrOffline
+	}
+	return client.node.PutModeContext(ctx, key, value, mode)
+}
+
+func (client *chordClient) GetMode(ctx context.Context, key, mode string) (string, error) {
+	if !client.node.Online {
+		return "", ErrOffline
+	}
+	return client.node.GetModeContext(ctx, key, mode)
+}
+
+func (client *chordClient) DeleteMode(ctx context.Context, key, mode string) error {
+	if !client.node.Online {
+		return ErrOffline
+	}
+	return client.node.DeleteModeContext(ctx, key, mode)
+}
+
+func (client *kademliaClient) Put(ctx context.Context, key, value string) error {
+	if !client.node.Online {
+		return ErrOffline
+	}
+	return client.node.PutContext(ctx, key, value)
+}
+
+func (client *kademliaClient) Get(ctx context.Context, key string) (string, error) {
+	if !client.node.Online {
+		return "", ErrOffline
+	}
+	return client.node.GetContext(ctx, key)
+}
+
+func (client *kademliaClient) PutConsistency(ctx context.Context, key, value string, level Consistency) error {
+	if !client.node.Online {
+		return ErrOffline
+	}
+	return client.node.PutConsistency(ctx, key, value, level)
+}
+
+func (client *kademliaClient) GetConsistency(ctx context.Context, key string, level Consistency) (string, error) {
+	if !client.node.Online {
+		return "", ErrOffline
+	}
+	return client.node.GetConsistency(ctx, key, level)
+}
+
+func (client *kademliaClient) PutVersion(ctx context.Context, key, value string, context version.Clock) error {
+	if !client.node.Online {
+		return ErrOffline
+	}
+	return client.node.PutVersion(ctx, key, value, context)
+}
+
+func (client *kademliaClient) GetVersions(ctx context.Context, key string) (version.Siblings, error) {
+	if !client.node.Online {
+		return nil, ErrOffline
+	}
+	return client.node.GetVersions(ctx, key)
+}
+
+func (client *kademliaClient) Delete(ctx context.Context, key string) error {
+	if !client.node.Online {
+		return ErrOffline
+	}
+	return client.node.DeleteContext(ctx, key)
+}
+
+// naive协议所有数据都在本地，只会出现键不存在的错误
+func (client *naiveClient) Put(ctx context.Context, key, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	client.node.Put(key, value)
+	return nil
+}
+
+func (client *naiveClient) Get(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	ok, value := client.node.Get(key)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (client *naiveClient) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !client.node.Delete(key) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// naive协议只有一个副本，各一致性级别相同
+func (client *naiveClient) PutConsistency(ctx context.Context, key, value string, level Consistency) error {
+	return client.Put(ctx, key, value)
+}
+
+func (client *naiveClient) GetConsistency(ctx context.Context, key string, level Consistency) (string, error) {
+	return client.Get(ctx, key)
+}
+
+// naive协议没有并发写入的副本，只有一个不带时钟的版本
+func (client *naiveClient) PutVersion(ctx context.Context, key, value string, context version.Clock) error {
+	return client.Put(ctx, key, value)
+}
+
+func (client *naiveClient) GetVersions(ctx context.Context, key string) (version.Siblings, error) {
+	value, err := client.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return version.Siblings{{Value: value, Clock: version.Clock{}}}, nil
+}
@@ -0,0 +1,276 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"dht/chord"
+	"dht/config"
+	"dht/kademlia"
+	"dht/logging"
+	"dht/naive"
+	"dht/rpc"
+)
+
+// 进程内的传输层，可以使某些远端调用的请求在发出时连接断开，模拟对方在查找之后、调用之前突然离开
+type dropTransport struct {
+	rpc.Transport
+	methods map[string]bool
+	lock    sync.RWMutex
+}
+
+func newDropTransport() *dropTransport {
+	return &dropTransport{Transport: rpc.NewMemoryTransport(), methods: map[string]bool{}}
+}
+
+// 之后对method的调用都因连接断开而失败(methods为空时恢复)
+func (transport *dropTransport) drop(methods ...string) {
+	transport.lock.Lock()
+	transport.methods = map[string]bool{}
+	for _, method := range methods {
+		transport.methods[method] = true
+	}
+	transport.lock.Unlock()
+}
+
+// 请求b(gob编码，方法名以原文出现)是否应被丢弃
+func (transport *dropTransport) dropped(b []byte) bool {
+	transport.lock.RLock()
+	defer transport.lock.RUnlock()
+	for method := range transport.methods {
+		if bytes.Contains(b, []byte(method)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (transport *dropTransport) Dial(ip string, timeOut time.Duration) (net.Conn, error) {
+	conn, err := transport.Transport.Dial(ip, timeOut)
+	if err != nil {
+		return nil, err
+	}
+	return &dropConn{conn, transport}, nil
+}
+
+type dropConn struct {
+	net.Conn
+	transport *dropTransport
+}
+
+func (conn *dropConn) Write(b []byte) (int, error) {
+	if conn.transport.dropped(b) {
+		conn.Conn.Close()
+		return 0, io.ErrClosedPipe
+	}
+	return conn.Conn.Write(b)
+}
+
+// 在transport上组成size个节点的chord环
+func newChordRing(t *testing.T, transport rpc.Transport, size int) []*chord.Node {
+	t.Helper()
+	logger, _ := logging.New(logging.Options{Output: io.Discard})
+	nodes := make([]*chord.Node, size)
+	for i := range nodes {
+		node := new(chord.Node)
+		node.SetLogger(logger)
+		if !node.Init(fmt.Sprintf("chord-%d", i)) {
+			t.Fatal("initializing node error")
+		}
+		node.RPC.Transport = transport
+		node.Run()
+		nodes[i] = node
+	}
+	t.Cleanup(func() {
+		for _, node := range nodes {
+			node.ForceQuit()
+		}
+	})
+	nodes[0].Create()
+	for i := 1; i < size; i++ {
+		if !nodes[i].Join(nodes[0].IP) {
+			t.Fatalf("node %d joining error", i)
+		}
+	}
+	time.Sleep(time.Second) //等待环稳定
+	return nodes
+}
+
+func nodeByIP(nodes []*chord.Node, ip string) *chord.Node {
+	for _, node := range nodes {
+		if node.IP == ip {
+			return node
+		}
+	}
+	return nil
+}
+
+// 在进程内组成size个节点的kademlia网络
+func newKademliaNetwork(t *testing.T, size int) []*kademlia.Node {
+	t.Helper()
+	transport := rpc.NewMemoryTransport()
+	logger, _ := logging.New(logging.Options{Output: io.Discard})
+	nodes := make([]*kademlia.Node, size)
+	for i := range nodes {
+		node := new(kademlia.Node)
+		node.SetLogger(logger)
+		key, err := rpc.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := node.InitWithConfig(fmt.Sprintf("kademlia-%d", i), key, config.Default()); err != nil {
+			t.Fatal(err)
+		}
+		node.RPC.Transport = transport
+		node.Run()
+		nodes[i] = node
+	}
+	t.Cleanup(func() {
+		for _, node := range nodes {
+			node.ForceQuit()
+		}
+	})
+	nodes[0].Create()
+	for i := 1; i < size; i++ {
+		if !nodes[i].Join(nodes[0].IP) {
+			t.Fatalf("node %d joining error", i)
+		}
+	}
+	return nodes
+}
+
+// 经Client服务读写c，返回Remote
+func serveRemote(t *testing.T, c Client) *Remote {
+	t.Helper()
+	server, err := Listen("127.0.0.1:0", c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	remote, err := Dial(server.Addr().String(), time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { remote.Close() })
+	return remote
+}
+
+// 直接调用的Client与经Remote调用共有的读写方法
+type keyValue interface {
+	Put(ctx context.Context, key, value string) error
+	Get(ctx context.Context, key string) (string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// 读写存在与不存在的键，直接调用与经Remote调用得到相同的错误
+func checkNotFound(t *testing.T, name string, clients ...keyValue) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i, c := range clients {
+		key := name + strconv.Itoa(i)
+		if err := c.Put(ctx, key, "value"); err != nil {
+			t.Fatalf("%s client %d: putting: %v", name, i, err)
+		}
+		if value, err := c.Get(ctx, key); err != nil || value != "value" {
+			t.Fatalf("%s client %d: got %q, %v", name, i, value, err)
+		}
+		if _, err := c.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s client %d: getting a missing key: %v, want %v", name, i, err, ErrNotFound)
+		}
+		if err := c.Delete(ctx, key); err != nil {
+			t.Fatalf("%s client %d: deleting: %v", name, i, err)
+		}
+		if err := c.Delete(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s client %d: deleting a deleted key: %v, want %v", name, i, err, ErrNotFound)
+		}
+		if _, err := c.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s client %d: getting a deleted key: %v, want %v", name, i, err, ErrNotFound)
+		}
+	}
+}
+
+// 节点下线后，直接调用与经Remote调用都得到ErrOffline
+func checkOffline(t *testing.T, name string, clients ...keyValue) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i, c := range clients {
+		if err := c.Put(ctx, "key", "value"); !errors.Is(err, ErrOffline) {
+			t.Errorf("%s client %d: putting through an offline node: %v, want %v", name, i, err, ErrOffline)
+		}
+		if _, err := c.Get(ctx, "key"); !errors.Is(err, ErrOffline) {
+			t.Errorf("%s client %d: getting through an offline node: %v, want %v", name, i, err, ErrOffline)
+		}
+	}
+}
+
+func TestChordClient(t *testing.T) {
+	transport := newDropTransport()
+	nodes := newChordRing(t, transport, 4)
+	c := NewChord(nodes[0])
+	remote := serveRemote(t, c)
+	checkNotFound(t, "chord", c, remote)
+
+	ctx := context.Background()
+	successor, _ := nodes[0].GetSuccessor()
+	key := ""
+	for i := 0; key == ""; i++ {
+		if i == 100 {
+			t.Fatal("no key is stored beyond the client's successor")
+		}
+		key = "key" + strconv.Itoa(i)
+		if err := c.Put(ctx, key, "value"); err != nil {
+			t.Fatal(err)
+		}
+		//由后继以外的节点负责的键，查找时须询问下一跳
+		if _, ok := nodes[0].GetOut(key); ok {
+			key = ""
+		} else if _, ok := nodeByIP(nodes, successor).GetOut(key); ok {
+			key = ""
+		}
+	}
+	//下一跳或负责的节点离开，前一次调用断开的连接也可能使之后的查找失败
+	for _, method := range []string{"Chord.FindPredecessorTrace", "Chord.GetOutVersions"} {
+		transport.drop(method)
+		for i, client := range []keyValue{c, remote} {
+			_, err := client.Get(ctx, key)
+			if !errors.Is(err, ErrOffline) && !errors.Is(err, ErrNoRoute) {
+				t.Errorf("client %d: getting %s while dropping %s: %v, want %v or %v", i, key, method, err, ErrOffline, ErrNoRoute)
+			}
+		}
+	}
+	transport.drop()
+
+	nodes[0].ForceQuit()
+	checkOffline(t, "chord", c, remote)
+}
+
+func TestKademliaClient(t *testing.T) {
+	nodes := newKademliaNetwork(t, 4)
+	c := NewKademlia(nodes[1])
+	remote := serveRemote(t, c)
+	checkNotFound(t, "kademlia", c, remote)
+	nodes[1].ForceQuit()
+	checkOffline(t, "kademlia", c, remote)
+}
+
+func TestNaiveClient(t *testing.T) {
+	node := new(naive.Node)
+	node.Init("127.0.0.1:0")
+	c := NewNaive(node)
+	checkNotFound(t, "naive", c, serveRemote(t, c))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Put(ctx, "key", "value"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("putting with a cancelled context: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	netrpc "net/rpc"
+	"time"
+
+	"dht/rpc"
+)
+
+const ServiceName = "Client"
+const DefaultTimeout = 5 * time.Second //请求未给出时限时，每次操作的时限
+
+// 远端请求
+type Request struct {
+	Key     string
+	Value   string
+	Mode    string        //PutMode/GetMode/DeleteMode的数据模式
+	Timeout time.Duration //为0时使用DefaultTimeout
+}
+
+// 将Client作为RPC服务(服务名为ServiceName)，使其他进程可以通过该节点读写(见Listen/Serve)
+type Service struct {
+	client Client
+}
+
+func NewService(client Client) *Service {
+	return &Service{client}
+}
+
+func (request Request) context() (context.Context, context.CancelFunc) {
+	if request.Timeout <= 0 {
+		return context.WithTimeout(context.Background(), DefaultTimeout)
+	}
+	return context.WithTimeout(context.Background(), request.Timeout)
+}
+
+func (service *Service) Put(request Request, _ *rpc.Null) error {
+	ctx, cancel := request.context()
+	defer cancel()
+	return service.client.Put(ctx, request.Key, request.Value)
+}
+
+func (service *Service) Get(request Request, value *string) (err error) {
+	ctx, cancel := request.context()
+	defer cancel()
+	*value, err = service.client.Get(ctx, request.Key)
+	return err
+}
+
+func (service *Service) Delete(request Request, _ *rpc.Null) error {
+	ctx, cancel := request.context()
+	defer cancel()
+	return service.client.Delete(ctx, request.Key)
+}
+
+func (service *Service) modeClient() (ModeClient, error) {
+	modeClient, ok := service.client.(ModeClient)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	return modeClient, nil
+}
+
+func (service *Service) PutMode(request Request, _ *rpc.Null) error {
+	modeClient, err := service.modeClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := request.context()
+	defer cancel()
+	return modeClient.PutMode(ctx, request.Key, request.Value, request.Mode)
+}
+
+func (service *Service) GetMode(request Request, value *string) error {
+	modeClient, err := service.modeClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := request.context()
+	defer cancel()
+	*value, err = modeClient.GetMode(ctx, request.Key, request.Mode)
+	return err
+}
+
+func (service *Service) DeleteMode(request Request, _ *rpc.Null) error {
+	modeClient, err := service.modeClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := request.context()
+	defer cancel()
+	return modeClient.DeleteMode(ctx, request.Key, request.Mode)
+}
+
+// 通过另一进程中节点的Client服务读写
+type Remote struct {
+	client *netrpc.Client
+}
+
+// 连接由Listen在addr上提供的Client服务，tlsConfig须与服务端一致(都为nil或由同一CA签发证书)
+func Dial(addr string, timeout time.Duration, tlsConfig *tls.Config) (*Remote, error) {
+	conn, err := rpc.NewTLSTransport(rpc.TCPTransport{}, tlsConfig).Dial(addr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w %v", ErrOffline, err)
+	}
+	return NewRemote(conn), nil
+}
+
+// 通过已建立的连接(如Unix域套接字)使用Client服务
+func NewRemote(conn net.Conn) *Remote {
+	return &Remote{netrpc.NewClient(conn)}
+}
+
+// 服从ctx的截止时间与取消，并将截止时间随请求传给节点
+func (remote *Remote) call(ctx context.Context, method string, request Request, reply interface{}) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		request.Timeout = time.Until(deadline)
+		if request.Timeout <= 0 {
+			return ErrTimeout
+		}
+	}
+	call := remote.client.Go(ServiceName+"."+method, request, reply, make(chan *netrpc.Call, 1))
+	select {
+	case <-call.Done:
+		return rpc.ParseError(call.Error)
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return ErrTimeout
+		}
+		return ctx.Err()
+	}
+}
+
+func (remote *Remote) Put(ctx context.Context, key, value string) error {
+	return remote.call(ctx, "Put", Request{Key: key, Value: value}, &rpc.Null{})
+}
+
+func (remote *Remote) Get(ctx context.Context, key string) (string, error) {
+	value := ""
+	err := remote.call(ctx, "Get", Request{Key: key}, &value)
+	return value, err
+}
+
+func (remote *Remote) Delete(ctx context.Context, key string) error {
+	return remote.call(ctx, "Delete", Request{Key: key}, &rpc.Null{})
+}
+
+func (remote *Remote) PutMode(ctx context.Context, key, value, mode string) error {
+	return remote.call(ctx, "PutMode", Request{Key: key, Value: value, Mode: mode}, &rpc.Null{})
+}
+
+func (remote *Remote) GetMode(ctx context.Context, key, mode string) (string, error) {
+	value := ""
+	err := remote.call(ctx, "GetMode", Request{Key: key, Mode: mode}, &value)
+	return value, err
+}
+
+func (remote *Remote) DeleteMode(ctx context.Context, key, mode string) error {
+	return remote.call(ctx, "DeleteMode", Request{Key: key, Mode: mode}, &rpc.Null{})
+}
+
+func (remote *Remote) Close() error {
+	return remote.client.Close()
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"crypto/tls"
+	"net"
+	netrpc "net/rpc"
+	"sync"
+
+	"dht/rpc"
+)
+
+// 在单独的监听上提供Client服务，与节点间的RPC服务分开
+type Server struct {
+	listener net.Listener
+	server   *netrpc.Server
+	conns    map[net.Conn]bool //已接受的连接，关闭时一同关闭
+	connLock sync.Mutex
+	wg       sync.WaitGroup
+}
+
+// 在TCP地址addr上提供c的读写；tlsConfig不为空时经过TLS，对方需出示由同一CA签发的证书
+func Listen(addr string, c Client, tlsConfig *tls.Config) (*Server, error) {
+	listener, err := rpc.NewTLSTransport(rpc.TCPTransport{}, tlsConfig).Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	server, err := Serve(listener, c)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return server, nil
+}
+
+// 在listener上以Client服务(服务名为ServiceName)提供c的读写
+func Serve(listener net.Listener, c Client) (*Server, error) {
+	server := netrpc.NewServer()
+	if err := server.RegisterName(ServiceName, NewService(c)); err != nil {
+		return nil, err
+	}
+	s := &Server{listener: listener, server: server, conns: make(map[net.Conn]bool)}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return //监听已关闭
+		}
+		s.connLock.Lock()
+		if s.conns == nil { //已关闭
+			s.connLock.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.connLock.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.server.ServeConn(conn)
+			s.connLock.Lock()
+			delete(s.conns, conn)
+			s.connLock.Unlock()
+		}()
+	}
+}
+
+// 实际监听的地址
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// 当前的连接数
+func (s *Server) Clients() int {
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+	return len(s.conns)
+}
+
+// 停止监听并断开所有连接(不影响节点)
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.connLock.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+	s.connLock.Unlock()
+	s.wg.Wait()
+	return err
+}
@@ -0,0 +1,176 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"dht/rpc"
+	"dht/version"
+)
+
+// 存于内存的Client，不支持一致性级别与版本
+type mapClient struct {
+	data map[string]string
+	lock sync.Mutex
+}
+
+func (client *mapClient) Put(ctx context.Context, key, value string) error {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	client.data[key] = value
+	return nil
+}
+
+func (client *mapClient) Get(ctx context.Context, key string) (string, error) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	value, ok := client.data[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (client *mapClient) Delete(ctx context.Context, key string) error {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	if _, ok := client.data[key]; !ok {
+		return ErrNotFound
+	}
+	delete(client.data, key)
+	return nil
+}
+
+func (client *mapClient) PutConsistency(ctx context.Context, key, value string, level Consistency) error {
+	return ErrUnsupported
+}
+
+func (client *mapClient) GetConsistency(ctx context.Context, key string, level Consistency) (string, error) {
+	return "", ErrUnsupported
+}
+
+func (client *mapClient) PutVersion(ctx context.Context, key, value string, context version.Clock) error {
+	return ErrUnsupported
+}
+
+func (client *mapClient) GetVersions(ctx context.Context, key string) (version.Siblings, error) {
+	return nil, ErrUnsupported
+}
+
+// 由caCert、caKey签发127.0.0.1的证书，并以trusted为受信任的CA构建TLS配置
+func testTLSConfig(t *testing.T, caCert, caKey, trusted []byte) *tls.Config {
+	t.Helper()
+	certPEM, keyPEM, err := rpc.GenerateCertificate(caCert, caKey, []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(trusted) {
+		t.Fatal("no CA certificate")
+	}
+	return rpc.NewTLSConfig(cert, caPool)
+}
+
+// 经Remote读写，检查错误被还原
+func checkRemote(t *testing.T, remote *Remote) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := remote.Put(ctx, "hello", "world"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := remote.Get(ctx, "hello"); err != nil || value != "world" {
+		t.Fatalf("got %q, %v", value, err)
+	}
+	if err := remote.Delete(ctx, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := remote.Get(ctx, "hello"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+	if err := remote.PutMode(ctx, "hello", "world", "append"); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("got %v, want ErrUnsupported", err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	server, err := Listen("127.0.0.1:0", &mapClient{data: map[string]string{}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := Dial(server.Addr().String(), time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRemote(t, remote)
+	if server.Clients() != 1 {
+		t.Fatalf("%d clients, want 1", server.Clients())
+	}
+	server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := remote.Put(ctx, "hello", "world"); err == nil {
+		t.Fatal("connection not closed with the server")
+	}
+	remote.Close()
+	if _, err := Dial(server.Addr().String(), time.Second, nil); !errors.Is(err, ErrOffline) {
+		t.Fatalf("got %v dialing a closed server, want ErrOffline", err)
+	}
+}
+
+func TestServerWithTLS(t *testing.T) {
+	caCert, caKey, err := rpc.GenerateCA("test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCert, otherKey, err := rpc.GenerateCA("other CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := Listen("127.0.0.1:0", &mapClient{data: map[string]string{}}, testTLSConfig(t, caCert, caKey, caCert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	addr := server.Addr().String()
+
+	remote, err := Dial(addr, time.Second, testTLSConfig(t, caCert, caKey, caCert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	checkRemote(t, remote)
+
+	//客户端的证书不受信任
+	untrusted, err := Dial(addr, time.Second, testTLSConfig(t, otherCert, otherKey, caCert))
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		err = untrusted.Put(ctx, "hello", "world")
+		untrusted.Close()
+	}
+	if err == nil {
+		t.Fatal("client with an untrusted certificate should be rejected")
+	}
+
+	//不使用TLS的客户端
+	plain, err := Dial(addr, time.Second, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := plain.Put(ctx, "hello", "world"); err == nil {
+		t.Fatal("plain client should be rejected")
+	}
+	plain.Close()
+}
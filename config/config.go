@@ -0,0 +1,221 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 默认值(局域网中的测试规模)
+const (
+	DefaultCallTimeOut          = 10 * time.Second
+	DefaultPoolSize             = 50
+	DefaultDialConns            = 10
+	DefaultStabilizeInterval    = 50 * time.Millisecond
+	DefaultFixFingerInterval    = 50 * time.Millisecond
+	DefaultMaxStabilizeInterval = 250 * time.Millisecond
+	DefaultMaxFixFingerInterval = 500 * time.Millisecond
+	DefaultReplica              = 2
+	DefaultK                    = 30
+	DefaultAlpha                = 3
+	DefaultRepublishCircleTime  = 15 * time.Second
+	DefaultRepublishTime        = 1200 * time.Second
+	DefaultAbandonTime          = 2400 * time.Second
+)
+
+// 时长，在JSON中写作"50ms"、"15s"、"20m"等(必须带单位，不接受数字)
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case float64: //没有单位的数字(包括YAML中的1.5)含义不明，与缺少单位的字符串一样拒绝
+		return fmt.Errorf("invalid duration %s: missing unit, e.g. \"%gs\"", data, value)
+	case string:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(duration)
+		return nil
+	}
+	return fmt.Errorf("invalid duration %s", data)
+}
+
+// 节点间远端调用与连接池
+type RPC struct {
+	CallTimeOut Duration //未设截止时间的远端调用的时限
+	PoolSize    int      //到每个节点的连接池最多容纳的客户端数
+	DialConns   int      //新建到某节点的连接池时建立的连接数
+}
+
+// chord的复制因子与周期性维护：结果没有变化时间隔逐步放慢至Max，发生变动时恢复
+type Chord struct {
+	Replica              int      //复制因子r：主数据复制到前r个后继，相邻r个节点同时异常退出不丢失数据，网络中各节点应相同
+	StabilizeInterval    Duration //两次stabilize之间的(最小)间隔
+	FixFingerInterval    Duration //两次fixFinger之间的(最小)间隔
+	MaxStabilizeInterval Duration //与StabilizeInterval相同时间隔固定
+	MaxFixFingerInterval Duration
+	IterativeLookup      bool //由发起查找的节点迭代地询问每一跳，而不是逐跳递归转发
+}
+
+// kademlia的路由与数据分布
+type Kademlia struct {
+	K                   int      //bucket的大小，也是数据存入的节点数
+	Alpha               int      //NodeLookup中每轮并行询问的节点数
+	RepublishCircleTime Duration //检查需重新发布的数据的周期
+	RepublishTime       Duration //数据存入后到重新发布的时长
+	AbandonTime         Duration //数据存入后未被重新发布时到舍弃的时长
+}
+
+// 节点配置，可以从JSON文件读入(未给出的项使用默认值)
+type Config struct {
+	RPC      RPC
+	Chord    Chord
+	Kademlia Kademlia
+}
+
+// 默认配置
+func Default() Config {
+	return Config{
+		RPC: RPC{
+			CallTimeOut: Duration(DefaultCallTimeOut),
+			PoolSize:    DefaultPoolSize,
+			DialConns:   DefaultDialConns,
+		},
+		Chord: Chord{
+			Replica:              DefaultReplica,
+			StabilizeInterval:    Duration(DefaultStabilizeInterval),
+			FixFingerInterval:    Duration(DefaultFixFingerInterval),
+			MaxStabilizeInterval: Duration(DefaultMaxStabilizeInterval),
+			MaxFixFingerInterval: Duration(DefaultMaxFixFingerInterval),
+		},
+		Kademlia: Kademlia{
+			K:                   DefaultK,
+			Alpha:               DefaultAlpha,
+			RepublishCircleTime: Duration(DefaultRepublishCircleTime),
+			RepublishTime:       Duration(DefaultRepublishTime),
+			AbandonTime:         Duration(DefaultAbandonTime),
+		},
+	}
+}
+
+// 检查配置，给出所有不合法的项
+func (config Config) Validate() error {
+	return errors.Join(config.RPC.Validate(), config.Chord.Validate(), config.Kademlia.Validate())
+}
+
+func (config RPC) Validate() error {
+	errs := []error{}
+	if config.CallTimeOut <= 0 {
+		errs = append(errs, errors.New("RPC.CallTimeOut should be positive"))
+	}
+	if config.DialConns < 1 {
+		errs = append(errs, errors.New("RPC.DialConns should be at least 1"))
+	}
+	if config.PoolSize < config.DialConns { //建立的连接都要放入连接池
+		errs = append(errs, errors.New("RPC.PoolSize should be at least RPC.DialConns"))
+	}
+	return errors.Join(errs...)
+}
+
+func (config Chord) Validate() error {
+	errs := []error{}
+	if config.Replica < 1 {
+		errs = append(errs, errors.New("Chord.Replica should be at least 1"))
+	}
+	if config.StabilizeInterval <= 0 {
+		errs = append(errs, errors.New("Chord.StabilizeInterval should be positive"))
+	}
+	if config.FixFingerInterval <= 0 {
+		errs = append(errs, errors.New("Chord.FixFingerInterval should be positive"))
+	}
+	if config.MaxStabilizeInterval < config.StabilizeInterval {
+		errs = append(errs, errors.New("Chord.MaxStabilizeInterval should be at least Chord.StabilizeInterval"))
+	}
+	if config.MaxFixFingerInterval < config.FixFingerInterval {
+		errs = append(errs, errors.New("Chord.MaxFixFingerInterval should be at least Chord.FixFingerInterval"))
+	}
+	return errors.Join(errs...)
+}
+
+func (config Kademlia) Validate() error {
+	errs := []error{}
+	if config.K < 1 {
+		errs = append(errs, errors.New("Kademlia.K should be at least 1"))
+	}
+	if config.Alpha < 1 || config.Alpha > config.K {
+		errs = append(errs, errors.New("Kademlia.Alpha should be between 1 and Kademlia.K"))
+	}
+	if config.RepublishCircleTime <= 0 {
+		errs = append(errs, errors.New("Kademlia.RepublishCircleTime should be positive"))
+	}
+	if config.RepublishTime <= 0 {
+		errs = append(errs, errors.New("Kademlia.RepublishTime should be positive"))
+	}
+	if config.AbandonTime <= config.RepublishTime+config.RepublishCircleTime { //否则数据在重新发布之前就被舍弃
+		errs = append(errs, errors.New("Kademlia.AbandonTime should be longer than Kademlia.RepublishTime plus Kademlia.RepublishCircleTime"))
+	}
+	return errors.Join(errs...)
+}
+
+// 解析JSON格式的配置，未给出的项使用默认值，不认识的项视为错误
+func Parse(data []byte) (Config, error) {
+	config := Default()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return Config{}, err
+	}
+	if err := config.Validate(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// 读入YAML格式的配置，与Parse相同，不认识的项与不合法的取值都会被拒绝
+func ParseYAML(data []byte) (Config, error) {
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return Config{}, err
+	}
+	if value == nil { //空文件，使用默认配置
+		return Parse([]byte("{}"))
+	}
+	data, err := json.Marshal(value) //键不是字符串时无法转为JSON
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid YAML config: %w", err)
+	}
+	return Parse(data)
+}
+
+// 读入配置文件，扩展名为.yaml或.yml时按YAML解析，否则按JSON解析
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	parse := Parse
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		parse = ParseYAML
+	}
+	config, err := parse(data)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
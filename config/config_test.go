@@ -0,0 +1,108 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	config, err := Parse([]byte(`{"RPC": {"CallTimeOut": "3s"}, "Chord": {"Replica": 3, "MaxStabilizeInterval": "1s", "IterativeLookup": true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Default()
+	want.RPC.CallTimeOut = Duration(3 * time.Second)
+	want.Chord.Replica = 3
+	want.Chord.MaxStabilizeInterval = Duration(time.Second)
+	want.Chord.IterativeLookup = true
+	if config != want {
+		t.Fatalf("got %+v, want %+v", config, want)
+	}
+	for _, data := range []string{
+		`{"RPC": {"Timeout": "3s"}}`,    //不认识的项
+		`{"RPC": {"CallTimeOut": "3"}}`, //缺少单位
+		`{"RPC": {"CallTimeOut": 3}}`,   //数字没有单位
+		`{"Kademlia": {"Alpha": 100}}`,  //不合法的取值
+		`{"Chord": {"Replica": 0}}`,
+	} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("%s should be rejected", data)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wan.json")
+	if err := os.WriteFile(path, []byte(`{"Kademlia": {"K": 20}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Kademlia.K != 20 {
+		t.Fatalf("got K = %d, want 20", config.Kademlia.K)
+	}
+	for _, name := range []string{"wan.yaml", "wan.YML"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("Kademlia:\n  K: 20\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		config, err := Load(path)
+		if err != nil {
+			t.Fatalf("loading %s: %v", name, err)
+		}
+		if config.Kademlia.K != 20 {
+			t.Fatalf("got K = %d loading %s, want 20", config.Kademlia.K, name)
+		}
+	}
+	path = filepath.Join(dir, "wrong.yaml")
+	if err := os.WriteFile(path, []byte("Kademlia:\n  Size: 20\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Fatalf("got %v loading a YAML config with an unknown field", err)
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	config, err := ParseYAML([]byte(`
+RPC:
+  CallTimeOut: 3s
+Chord:
+  Replica: 3
+  MaxStabilizeInterval: 1s
+  IterativeLookup: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Default()
+	want.RPC.CallTimeOut = Duration(3 * time.Second)
+	want.Chord.Replica = 3
+	want.Chord.MaxStabilizeInterval = Duration(time.Second)
+	want.Chord.IterativeLookup = true
+	if config != want {
+		t.Fatalf("got %+v, want %+v", config, want)
+	}
+	if config, err := ParseYAML(nil); err != nil || config != Default() {
+		t.Fatalf("empty YAML: %+v, %v", config, err)
+	}
+	for _, data := range []string{
+		"RPC:\n  Timeout: 3s\n",      //不认识的项
+		"RPC:\n  CallTimeOut: 3x\n",  //不合法的时长
+		"RPC:\n  CallTimeOut: 1.5\n", //缺少单位(不能当作1.5纳秒)
+		"Kademlia:\n  Alpha: 100\n",  //不合法的取值
+		"Chord: [1, 2]\n",            //类型不对
+		"1: 2\n",                     //键不是字符串
+		"RPC: {\n",                   //语法错误
+	} {
+		if _, err := ParseYAML([]byte(data)); err == nil {
+			t.Errorf("%q should be rejected", data)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package daemon
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"dht/client"
+)
+
+const DialTimeOut = time.Second
+
+// 承载一个节点的守护进程：通过Unix域套接字为本机的多个应用提供读写，应用共用该节点在DHT中的成员身份
+type Daemon struct {
+	path   string
+	server *client.Server
+}
+
+// 在path处创建Unix域套接字，以Client服务(服务名为client.ServiceName)提供c的读写；
+// path处遗留的套接字文件(之前的守护进程未正常退出)会被删去，仍有守护进程在监听时返回错误
+func Listen(path string, c client.Client) (*Daemon, error) {
+	if _, err := os.Stat(path); err == nil {
+		conn, err := net.DialTimeout("unix", path, DialTimeOut)
+		if err == nil {
+			conn.Close()
+			return nil, errors.New("A daemon is already listening on " + path + ".")
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := listenPrivate(path)
+	if err != nil {
+		return nil, err
+	}
+	server, err := client.Serve(listener, c)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &Daemon{path: path, server: server}, nil
+}
+
+// 在path所在目录下仅当前用户可访问(0700)的临时目录中创建套接字，改为0600后移到path处，
+// 套接字自创建起就不能被其他用户连接(不依赖umask，也不修改整个进程的umask)
+func listenPrivate(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".daemon-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "socket")
+	listener, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false) //套接字文件由Close删去
+	if err := os.Chmod(tmp, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// 套接字的路径
+func (daemon *Daemon) Path() string {
+	return daemon.path
+}
+
+// 当前连接的应用数
+func (daemon *Daemon) Clients() int {
+	return daemon.server.Clients()
+}
+
+// 停止监听并断开所有应用，删去套接字文件(不影响承载的节点)
+func (daemon *Daemon) Close() error {
+	err := daemon.server.Close()
+	os.Remove(daemon.path)
+	return err
+}
+
+// 连接path处的守护进程，得到的客户端可被多个协程同时使用
+func Dial(path string) (*client.Remote, error) {
+	conn, err := net.DialTimeout("unix", path, DialTimeOut)
+	if err != nil {
+		return nil, client.ErrOffline
+	}
+	return client.NewRemote(conn), nil
+}
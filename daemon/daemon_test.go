@@ -0,0 +1,143 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"dht/chord"
+	"dht/client"
+	"dht/logging"
+	"dht/rpc"
+)
+
+// 在进程内启动一个chord节点，作为守护进程承载的节点
+func newClient(t *testing.T) client.Client {
+	t.Helper()
+	logger, _ := logging.New(logging.Options{Output: io.Discard})
+	node := new(chord.Node)
+	node.SetLogger(logger)
+	if !node.Init("node-0") {
+		t.Fatal("initializing node error")
+	}
+	node.RPC.Transport = rpc.NewMemoryTransport()
+	node.Run()
+	node.Create()
+	t.Cleanup(node.ForceQuit)
+	return client.NewChord(node)
+}
+
+func TestDaemon(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dht.sock")
+	daemon, err := Listen(path, newClient(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("socket mode %v, want 0600", info.Mode().Perm())
+	}
+
+	remote, err := Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	ctx := context.Background()
+	if err := remote.Put(ctx, "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := remote.Get(ctx, "key"); err != nil || value != "value" {
+		t.Fatalf("got %q, %v, want value", value, err)
+	}
+	if err := remote.Delete(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := remote.Get(ctx, "key"); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("getting a deleted key: %v, want %v", err, client.ErrNotFound)
+	}
+	if daemon.Clients() != 1 {
+		t.Fatalf("%d clients, want 1", daemon.Clients())
+	}
+
+	if _, err := Listen(path, newClient(t)); err == nil { //仍有守护进程在监听
+		t.Fatal("started a second daemon on a live socket")
+	}
+	if value, err := remote.Get(ctx, "missing"); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("the first daemon stopped serving after the second one failed: %q, %v", value, err)
+	}
+
+	daemon.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket not removed after closing: %v", err)
+	}
+	if _, err := Dial(path); !errors.Is(err, client.ErrOffline) {
+		t.Fatalf("dialing a closed daemon: %v, want %v", err, client.ErrOffline)
+	}
+}
+
+// 之前的守护进程未正常退出，遗留的套接字文件被删去
+func TestListenRemovesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dht.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("no stale socket: %v", err)
+	}
+	daemon, err := Listen(path, newClient(t))
+	if err != nil {
+		t.Fatalf("listening on a stale socket: %v", err)
+	}
+	defer daemon.Close()
+	remote, err := Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	if err := remote.Put(context.Background(), "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 进程的umask宽松时，套接字同样只允许同一用户连接，所在目录中不留下临时目录
+func TestListenIgnoresUmask(t *testing.T) {
+	old := syscall.Umask(0)
+	defer syscall.Umask(old)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dht.sock")
+	daemon, err := Listen(path, newClient(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("socket mode %v, want 0600", info.Mode().Perm())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "dht.sock" {
+		t.Fatalf("directory holds %v, want only dht.sock", entries)
+	}
+	daemon.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket left after closing: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package kademlia
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"dht/admin"
+)
+
+// 一个非空的bucket(Nodes按最近联系的先后排列)
+type BucketState struct {
+	Index int
+	Nodes []string
+}
+
+// 节点状态
+type NodeState struct {
+	IP         string
+	ID         string //十六进制
+	Online     bool
+	Buckets    []BucketState
+	Contacts   int //路由表中的节点总数
+	DataKeys   int
+	Tombstones int //只剩墓碑的键
+}
+
+// 一个存储的键
+type KeyInfo struct {
+	Key        string
+	Versions   int        //并发写入产生的版本数(包括墓碑)
+	Deleted    bool       //只剩墓碑
+	ExpireTime *time.Time `json:",omitempty"`
+}
+
+// 节点当前的状态(不进行远端调用)
+func (node *Node) State() NodeState {
+	state := NodeState{IP: node.IP, Online: node.Online}
+	if node.ID != nil {
+		state.ID = fmt.Sprintf("%040x", node.ID)
+	}
+	for i := range node.buckets {
+		nodes := node.buckets[i].getAll()
+		if len(nodes) > 0 {
+			state.Buckets = append(state.Buckets, BucketState{i, nodes})
+			state.Contacts += len(nodes)
+		}
+	}
+	state.DataKeys, state.Tombstones = node.data.size()
+	return state
+}
+
+// 存储的键(不包括已过期的)，按键排序
+func (node *Node) storedKeys() []KeyInfo {
+	data := node.data.getAllList()
+	out := make([]KeyInfo, 0, len(data))
+	for _, dataPair := range data {
+		info := KeyInfo{Key: dataPair.Key, Versions: len(dataPair.Versions), Deleted: len(dataPair.Versions.Live()) == 0}
+		if !dataPair.ExpireTime.IsZero() {
+			expireTime := dataPair.ExpireTime
+			info.ExpireTime = &expireTime
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+// 管理接口(均为GET，返回JSON)：
+// /state 节点状态与各bucket；/keys?offset=&limit= 分页给出存储的键
+func (node *Node) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/state", admin.Get(func(w http.ResponseWriter, r *http.Request) {
+		admin.WriteJSON(w, node.State())
+	}))
+	mux.HandleFunc("/keys", admin.Get(func(w http.ResponseWriter, r *http.Request) {
+		keys := node.storedKeys()
+		begin, end, err := admin.PageRange(r, len(keys))
+		if err != nil {
+			admin.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		admin.WriteJSON(w, admin.Page{Total: len(keys), Offset: begin, Items: keys[begin:end]})
+	}))
+	return mux
+}
+
+// 在addr上启动管理接口的HTTP服务，节点退出时停止，返回实际监听的地址
+func (node *Node) ServeAdmin(addr string) (net.Addr, error) {
+	listener, err := admin.Serve(addr, node.AdminHandler())
+	if err != nil {
+		node.log.WithField("address", addr).WithError(err).Error("Serving admin error.")
+		return nil, err
+	}
+	node.httpLock.Lock()
+	node.httpListeners = append(node.httpListeners, listener)
+	node.httpLock.Unlock()
+	return listener.Addr(), nil
+}
@@ -0,0 +1,101 @@
+package kademlia
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"dht/admin"
+)
+
+// 经管理接口读取，应答解析到out中，返回状态码
+func adminGet(t *testing.T, node *Node, path string, out interface{}) int {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	node.AdminHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	if recorder.Code == http.StatusOK {
+		if err := json.Unmarshal(recorder.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	return recorder.Code
+}
+
+func TestAdminState(t *testing.T) {
+	nodes := newTestNetwork(t, 4)
+	state := NodeState{}
+	if code := adminGet(t, nodes[1], "/state", &state); code != http.StatusOK {
+		t.Fatalf("/state: status %d", code)
+	}
+	if state.IP != nodes[1].IP || !state.Online || state.Contacts != 3 || len(state.Buckets) == 0 {
+		t.Fatalf("/state: %+v", state)
+	}
+	recorder := httptest.NewRecorder()
+	nodes[1].AdminHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/state", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST /state: status %d", recorder.Code)
+	}
+}
+
+func TestAdminKeysPaging(t *testing.T) {
+	nodes := newTestNetwork(t, 1)
+	total := admin.MaxLimit + 10
+	for i := 0; i < total; i++ {
+		if !nodes[0].Put("key"+strconv.Itoa(i), "value") {
+			t.Fatalf("putting key%d error", i)
+		}
+	}
+	//墓碑被列出，已过期但尚未清除的键不列出
+	if !nodes[0].Put("deleted", "value") || !nodes[0].Delete("deleted") {
+		t.Fatal("deleting error")
+	}
+	total++
+	if err := nodes[0].PutTTL(context.Background(), "expired", "value", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	cases := []struct {
+		query        string
+		offset, size int
+	}{
+		{"", 0, admin.DefaultLimit},
+		{"?offset=5&limit=3", 5, 3},
+		{"?offset=" + strconv.Itoa(total-2) + "&limit=10", total - 2, 2},
+		{"?offset=" + strconv.Itoa(total+5), total, 0}, //越过末尾
+		{"?limit=" + strconv.Itoa(admin.MaxLimit+5), 0, admin.MaxLimit},
+	}
+	for _, c := range cases {
+		page := struct {
+			Total  int
+			Offset int
+			Items  []KeyInfo
+		}{}
+		if code := adminGet(t, nodes[0], "/keys"+c.query, &page); code != http.StatusOK {
+			t.Errorf("/keys%s: status %d", c.query, code)
+			continue
+		}
+		if page.Total != total || page.Offset != c.offset || len(page.Items) != c.size {
+			t.Errorf("/keys%s: total %d, offset %d, %d items, want %d, %d, %d", c.query, page.Total, page.Offset, len(page.Items), total, c.offset, c.size)
+		}
+		for i := 1; i < len(page.Items); i++ {
+			if page.Items[i-1].Key >= page.Items[i].Key {
+				t.Errorf("/keys%s: keys not sorted", c.query)
+				break
+			}
+		}
+		for _, info := range page.Items {
+			if info.Key == "expired" || info.Deleted != (info.Key == "deleted") {
+				t.Errorf("/keys%s: %+v", c.query, info)
+			}
+		}
+	}
+	for _, query := range []string{"?limit=-1", "?offset=-3", "?limit=x"} {
+		if code := adminGet(t, nodes[0], "/keys"+query, nil); code != http.StatusBadRequest {
+			t.Errorf("/keys%s: status %d, want %d", query, code, http.StatusBadRequest)
+		}
+	}
+}
@@ -40,7 +40,7 @@ func (bucket *Bucket) init(ip string, node *Node) error {
 func (bucket *Bucket) insertToHead(ip string) error {
 	bucket.lock.Lock()
 	defer bucket.lock.Unlock()
-	if bucket.size >= k {
+	if bucket.size >= bucket.node.config.K {
 		return errors.New("Max size.")
 	}
 	bucket.size++
@@ -113,7 +113,7 @@ func (bucket *Bucket) flush(ip string, online bool) {
 			bucket.lock.RLock()
 			size := bucket.size
 			bucket.lock.RUnlock()
-			if size < k {
+			if size < bucket.node.config.K {
 				bucket.insertToHead(ip)
 			} else {
 				for i := 1; i <= size; i++ {
@@ -1,31 +1,52 @@
 package kademlia
 
 import (
+	"os"
 	"sync"
 	"time"
+
+	"dht/config"
+	"dht/version"
 )
 
 type DataPair struct {
-	Key   string
-	Value string
+	Key        string
+	Value      string
+	Versions   version.Siblings //为空表示盲写(覆盖已有的所有版本)，否则与已有版本合并
+	Delete     bool             //盲删：写入覆盖已有的所有版本的墓碑
+	ExpireTime time.Time        //过期时间(绝对时间，随重新发布一同传递)，零值表示永不过期
 }
 
 type Data struct {
-	dataPair      map[string]string
-	dataLock      sync.RWMutex
-	republishTime map[string]time.Time //重新发布时间
-	abandonTime   map[string]time.Time //舍弃时间
+	dataPair       map[string]version.Siblings
+	dataLock       sync.RWMutex
+	republishTime  map[string]time.Time //重新发布时间
+	abandonTime    map[string]time.Time //舍弃时间
+	expireTime     map[string]time.Time //过期时间(只记录设置了ttl的数据)
+	tombstoneGrace time.Duration        //墓碑的保留时长
+	republishAfter time.Duration        //存入后到重新发布的时长
+	abandonAfter   time.Duration        //存入后到舍弃的时长
+	spreadTime     time.Duration        //重启时已到期的数据分散重新发布的时长
+	dir            string               //磁盘存储目录，未启用时log为空
+	log            *os.File
+	logSize        int
 }
 
-const RepublishTime = 1200 * time.Second
-const AbandonTime = 2400 * time.Second
+const RepublishTime = config.DefaultRepublishTime //默认值，可由config.Kademlia设置
+const AbandonTime = config.DefaultAbandonTime
+const DefaultTombstoneGrace = AbandonTime //墓碑需保留到未收到删除的节点上的旧数据被舍弃为止
 
-// 初始化
-func (data *Data) Init() {
+// 初始化，重新发布与舍弃的时长由config给出
+func (data *Data) Init(config config.Kademlia) {
 	data.dataLock.Lock()
-	data.dataPair = make(map[string]string)
+	data.dataPair = make(map[string]version.Siblings)
 	data.republishTime = make(map[string]time.Time)
 	data.abandonTime = make(map[string]time.Time)
+	data.expireTime = make(map[string]time.Time)
+	data.republishAfter = time.Duration(config.RepublishTime)
+	data.abandonAfter = time.Duration(config.AbandonTime)
+	data.spreadTime = RepublishSpreadCircles * time.Duration(config.RepublishCircleTime)
+	data.tombstoneGrace = data.abandonAfter
 	data.dataLock.Unlock()
 }
 
@@ -34,8 +55,8 @@ func (data *Data) getRepublishList() []DataPair {
 	republishList := []DataPair{}
 	data.dataLock.RLock()
 	for key, republishTime := range data.republishTime {
-		if time.Now().After(republishTime) {
-			republishList = append(republishList, DataPair{key, data.dataPair[key]})
+		if time.Now().After(republishTime) && !data.expired(key) {
+			republishList = append(republishList, DataPair{key, "", data.dataPair[key], false, data.expireTime[key]})
 		}
 	}
 	data.dataLock.RUnlock()
@@ -46,43 +67,142 @@ func (data *Data) getRepublishList() []DataPair {
 func (data *Data) getAllList() []DataPair {
 	allList := []DataPair{}
 	data.dataLock.RLock()
-	for key, value := range data.dataPair {
-		allList = append(allList, DataPair{key, value})
+	for key, versions := range data.dataPair {
+		if !data.expired(key) {
+			allList = append(allList, DataPair{key, "", versions, false, data.expireTime[key]})
+		}
 	}
 	data.dataLock.RUnlock()
 	return allList
 }
 
-// 查找数据
-func (data *Data) get(key string) (string, bool) {
+// 存储的键数，分为有效数据与只剩墓碑的数据
+func (data *Data) size() (live, tombstones int) {
 	data.dataLock.RLock()
-	value, ok := data.dataPair[key]
+	for key, versions := range data.dataPair {
+		if data.expired(key) {
+			continue
+		}
+		if len(versions.Live()) == 0 {
+			tombstones++
+		} else {
+			live++
+		}
+	}
 	data.dataLock.RUnlock()
-	return value, ok
+	return live, tombstones
 }
 
-// 存入数据
-func (data *Data) put(key, value string) {
+// 查找数据的各个版本
+func (data *Data) get(key string) (version.Siblings, bool) {
+	data.dataLock.RLock()
+	versions, ok := data.dataPair[key]
+	if ok && data.expired(key) { //尚未舍弃的过期数据
+		versions, ok = nil, false
+	}
+	data.dataLock.RUnlock()
+	return versions, ok
+}
+
+// 数据是否已过期(需持有dataLock)
+func (data *Data) expired(key string) bool {
+	expireTime, ok := data.expireTime[key]
+	return ok && time.Now().After(expireTime)
+}
+
+// 合并版本后的过期时间：沿用版本覆盖另一方的一方的过期时间，并发时取较晚者(需持有dataLock)
+func (data *Data) mergeExpire(key string, dataPair DataPair) time.Time {
+	versionsOld, expireOld := data.dataPair[key], data.expireTime[key]
+	if dataPair.Versions.Covers(versionsOld) {
+		return dataPair.ExpireTime
+	}
+	if versionsOld.Covers(dataPair.Versions) {
+		return expireOld
+	}
+	if expireOld.IsZero() || dataPair.ExpireTime.IsZero() {
+		return time.Time{}
+	}
+	if expireOld.After(dataPair.ExpireTime) {
+		return expireOld
+	}
+	return dataPair.ExpireTime
+}
+
+// 设置过期时间(需持有dataLock)
+func (data *Data) setExpire(key string, expireTime time.Time) {
+	if expireTime.IsZero() {
+		delete(data.expireTime, key)
+	} else {
+		data.expireTime[key] = expireTime
+	}
+}
+
+// 存入数据：盲写(盲删)时以writer的名义覆盖已有的所有版本，否则与已有版本合并(被覆盖或删除的旧版本不会因重新发布而复活)。
+// 返回存入前是否有未删除、未过期的版本
+func (data *Data) put(dataPair DataPair, writer string) (found bool) {
+	key := dataPair.Key
+	data.dataLock.Lock()
+	if data.expired(key) { //过期数据视为不存在
+		delete(data.dataPair, key)
+		delete(data.expireTime, key)
+	}
+	found = len(data.dataPair[key].Live()) > 0
+	republishTime := time.Now().Add(data.republishAfter)
+	if dataPair.Delete {
+		data.dataPair[key] = data.dataPair[key].Tombstone(writer, time.Now().UnixNano())
+		data.setExpire(key, time.Time{})
+	} else if len(dataPair.Versions) == 0 {
+		data.dataPair[key] = data.dataPair[key].Overwrite(dataPair.Value, writer, time.Now().UnixNano())
+		data.setExpire(key, dataPair.ExpireTime)
+	} else {
+		data.setExpire(key, data.mergeExpire(key, dataPair))
+		data.dataPair[key] = data.dataPair[key].Merge(dataPair.Versions)
+		if !dataPair.Versions.Covers(data.dataPair[key]) { //写入者缺少本节点的版本(如未收到删除的节点重新发布旧数据)，下一轮即重新发布
+			republishTime = time.Now()
+		}
+	}
+	data.republishTime[key] = republishTime
+	data.abandonTime[key] = time.Now().Add(data.abandonAfter)
+	data.appendLog(dataRecord{false, key, data.dataPair[key], data.republishTime[key], data.abandonTime[key], data.expireTime[key]})
+	data.dataLock.Unlock()
+	return found
+}
+
+// 设置墓碑的保留时长
+func (data *Data) setTombstoneGrace(grace time.Duration) {
 	data.dataLock.Lock()
-	data.dataPair[key] = value
-	data.republishTime[key] = time.Now().Add(RepublishTime)
-	data.abandonTime[key] = time.Now().Add(AbandonTime)
+	data.tombstoneGrace = grace
 	data.dataLock.Unlock()
 }
 
-// 舍弃过期数据
+// 舍弃到达舍弃时间或过期时间的数据，以及超过保留时长的墓碑
 func (data *Data) abandon() {
 	keyList := []string{}
 	data.dataLock.Lock()
 	for key, abandonTime := range data.abandonTime {
-		if time.Now().After(abandonTime) {
+		if time.Now().After(abandonTime) || data.expired(key) {
+			keyList = append(keyList, key)
+		}
+	}
+	deadline := time.Now().Add(-data.tombstoneGrace).UnixNano()
+	for key, versions := range data.dataPair {
+		versionsLeft := versions.Expire(deadline)
+		if len(versionsLeft) == len(versions) || time.Now().After(data.abandonTime[key]) || data.expired(key) {
+			continue
+		}
+		if len(versionsLeft) == 0 {
 			keyList = append(keyList, key)
+		} else {
+			data.dataPair[key] = versionsLeft
+			data.appendLog(dataRecord{false, key, versionsLeft, data.republishTime[key], data.abandonTime[key], data.expireTime[key]})
 		}
 	}
 	for _, key := range keyList {
 		delete(data.dataPair, key)
 		delete(data.republishTime, key)
 		delete(data.abandonTime, key)
+		delete(data.expireTime, key)
+		data.appendLog(dataRecord{Delete: true, Key: key})
 	}
 	data.dataLock.Unlock()
 }
@@ -0,0 +1,136 @@
+package kademlia
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+
+	"dht/logging"
+	"dht/rpc"
+)
+
+const identifyUsage = "Kademlia.Identify"
+const findNodeUsage = "Kademlia.FindNode"
+
+// 磁盘存储的目录中保存私钥的文件
+const KeyFile = "node.key"
+
+type FindNodeReply struct {
+	List     []string
+	Identity rpc.Identity //应答者对 随机数+目标ID+List 的签名
+}
+
+// 以key为节点的私钥，ID由公钥确定
+func (node *Node) setKey(key ed25519.PrivateKey) {
+	node.key = key
+	node.ID = rpc.IdFromPublicKey(key.Public().(ed25519.PublicKey))
+	node.log = logging.ForNode(node.logger, node.IP, node.ID)
+	node.RPC.Log = node.log
+}
+
+// 对请求方给出的随机数签名，证明自身持有ID对应的私钥
+func (node *Node) Identify(nonce []byte) rpc.Identity {
+	return rpc.Sign(node.key, rpc.SignMessage(identifyUsage, nonce, node.IP))
+}
+
+// 对FindNode的应答签名
+func (node *Node) signFindNode(nonce []byte, id *big.Int, list []string) rpc.Identity {
+	return rpc.Sign(node.key, findNodeMessage(nonce, node.IP, id, list))
+}
+
+// 验证ip节点对FindNode的应答，并记录其ID
+func (node *Node) verifyFindNode(nonce []byte, ip string, id *big.Int, reply FindNodeReply) bool {
+	idFrom, err := reply.Identity.Verify(findNodeMessage(nonce, ip, id, reply.List))
+	if err != nil {
+		node.log.WithField("server", ip).WithError(err).Warn("Verifying FindNode reply error.")
+		return false
+	}
+	return node.rememberId(ip, idFrom)
+}
+
+func findNodeMessage(nonce []byte, ip string, id *big.Int, list []string) []byte {
+	return rpc.SignMessage(findNodeUsage, nonce, ip, id.Bytes(), []byte(strings.Join(list, "\n")))
+}
+
+// 得到节点ip对应的ID(要求对方签名验证后缓存)，无法验证时返回错误，视为节点不可达
+func (node *Node) getId(ip string) (*big.Int, error) {
+	if ip == node.IP {
+		return node.ID, nil
+	}
+	if id, ok := node.cachedId(ip); ok {
+		return id, nil
+	}
+	if ip == "" {
+		return nil, rpc.ErrOffline
+	}
+	nonce := rpc.NewNonce()
+	identity := rpc.Identity{}
+	err := node.RPC.RemoteCall(ip, "Kademlia.Identify", nonce, &identity)
+	if err != nil {
+		node.log.WithField("target", ip).WithError(err).Debug("Identify error.")
+		return nil, err
+	}
+	id, err := identity.Verify(rpc.SignMessage(identifyUsage, nonce, ip))
+	if err != nil {
+		node.log.WithField("target", ip).WithError(err).Warn("Verifying identity error.")
+		return nil, rpc.UnverifiedError(err)
+	}
+	if !node.rememberId(ip, id) {
+		return nil, rpc.UnverifiedError(errors.New("ID conflicts with the cached one"))
+	}
+	return id, nil
+}
+
+// 并行验证一组节点(已缓存的不再发起远端调用)，给出通过验证的节点ID
+func (node *Node) getIds(ips []string) map[string]*big.Int {
+	ids := make(map[string]*big.Int, len(ips))
+	seen := make(map[string]bool, len(ips))
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, ip := range ips {
+		if seen[ip] {
+			continue
+		}
+		seen[ip] = true
+		wg.Add(1)
+		go func(ip string) {
+			defer wg.Done()
+			id, err := node.getId(ip)
+			if err == nil {
+				lock.Lock()
+				ids[ip] = id
+				lock.Unlock()
+			}
+		}(ip)
+	}
+	wg.Wait()
+	return ids
+}
+
+// 得到已缓存的节点ID
+func (node *Node) cachedId(ip string) (*big.Int, bool) {
+	node.idLock.RLock()
+	id, ok := node.ids[ip]
+	node.idLock.RUnlock()
+	return id, ok
+}
+
+// 缓存经过验证的节点ID，若与已缓存的ID冲突则拒绝
+func (node *Node) rememberId(ip string, id *big.Int) bool {
+	node.idLock.Lock()
+	defer node.idLock.Unlock()
+	if old, ok := node.ids[ip]; ok && old.Cmp(id) != 0 {
+		return false
+	}
+	node.ids[ip] = id
+	return true
+}
+
+// 节点下线后删去缓存的ID(该地址上的新节点可能持有不同的私钥)
+func (node *Node) forgetId(ip string) {
+	node.idLock.Lock()
+	delete(node.ids, ip)
+	node.idLock.Unlock()
+}
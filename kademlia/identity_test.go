@@ -0,0 +1,101 @@
+package kademlia
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"dht/rpc"
+)
+
+// 冒充者：应答Ping，但签名使用的不是自身地址，FindNode的应答签名与请求不符
+type impostor struct {
+	key ed25519.PrivateKey
+}
+
+func (impostor) Ping(_ Null, _ *Null) error {
+	return nil
+}
+
+func (impostor impostor) Identify(nonce []byte, identity *rpc.Identity) error {
+	*identity = rpc.Sign(impostor.key, rpc.SignMessage(identifyUsage, nonce, "node-victim"))
+	return nil
+}
+
+func (impostor impostor) FindNode(pair IpIdPairs, reply *FindNodeReply) error {
+	list := []string{"node-fake", "node-victim"}
+	*reply = FindNodeReply{list, rpc.Sign(impostor.key, findNodeMessage(rpc.NewNonce(), "node-fake", pair.IdTo, list))}
+	return nil
+}
+
+func TestImpostorIsRejected(t *testing.T) {
+	nodes := newTestNetwork(t, 2)
+	node := nodes[0]
+	if id, ok := node.cachedId(nodes[1].IP); !ok || id.Cmp(nodes[1].ID) != 0 {
+		t.Fatalf("cached id of the honest node %v, %v", id, ok)
+	}
+
+	key, _ := rpc.GenerateKey()
+	fake := &rpc.NodeRpc{Transport: node.RPC.Transport}
+	fake.Register("Kademlia", impostor{key})
+	start, quit := make(chan bool), make(chan bool)
+	go fake.Serve("node-fake", start, quit)
+	defer close(quit)
+	select {
+	case <-start:
+	case <-time.After(time.Second):
+		t.Fatal("serving time out")
+	}
+
+	if id, err := node.getId("node-fake"); err == nil || !errors.Is(err, rpc.ErrOffline) {
+		t.Fatalf("impostor got id %v, error %v", id, err)
+	}
+	if _, ok := node.cachedId("node-fake"); ok {
+		t.Fatal("impostor id cached")
+	}
+
+	//签名不是对本次请求的应答
+	target := big.NewInt(42)
+	nonce := rpc.NewNonce()
+	reply := FindNodeReply{}
+	if err := node.RPC.RemoteCall("node-fake", "Kademlia.FindNode", IpIdPairs{node.IP, target, nonce}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if node.verifyFindNode(nonce, "node-fake", target, reply) {
+		t.Fatal("FindNode reply with a bad signature accepted")
+	}
+	if _, ok := node.cachedId("node-fake"); ok {
+		t.Fatal("impostor id cached from a FindNode reply")
+	}
+
+	//应答被篡改
+	reply = FindNodeReply{}
+	if err := node.RPC.RemoteCall(nodes[1].IP, "Kademlia.FindNode", IpIdPairs{node.IP, target, nonce}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if !node.verifyFindNode(nonce, nodes[1].IP, target, reply) {
+		t.Fatal("honest FindNode reply rejected")
+	}
+	reply.List = append(reply.List, "node-fake")
+	if node.verifyFindNode(nonce, nodes[1].IP, target, reply) {
+		t.Fatal("tampered FindNode reply accepted")
+	}
+
+	//冒充者以自己的私钥为已知节点的地址签名，其ID与缓存的ID冲突
+	forged := FindNodeReply{[]string{"node-fake"}, rpc.Sign(key, findNodeMessage(nonce, nodes[1].IP, target, []string{"node-fake"}))}
+	if node.verifyFindNode(nonce, nodes[1].IP, target, forged) {
+		t.Fatal("reply signed by an impostor for a known address accepted")
+	}
+	impostorId := rpc.IdFromPublicKey(key.Public().(ed25519.PublicKey))
+	if node.rememberId(nodes[1].IP, impostorId) {
+		t.Fatal("impostor rebound a known address to its own id")
+	}
+	if id, _ := node.cachedId(nodes[1].IP); id.Cmp(nodes[1].ID) != 0 {
+		t.Fatalf("cached id of the honest node changed to %v", id)
+	}
+	if !node.rememberId(nodes[1].IP, nodes[1].ID) {
+		t.Fatal("same id rejected")
+	}
+}
@@ -1,16 +1,27 @@
 package kademlia
 
 import (
+	"context"
+	"crypto/ed25519"
+	"dht/config"
+	"dht/logging"
+	"dht/metrics"
 	"dht/rpc"
+	"dht/trace"
+	"dht/version"
+	"errors"
 	"math/big"
 	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
-const k = 30 //bucket的大小
-const a = 3  //NodeLookup中的alpha大小
-const RepulishCircleTime = 15 * time.Second
+const RepulishCircleTime = config.DefaultRepublishCircleTime //默认值，可由config.Kademlia设置
 const AbandonCircleTime = 60 * time.Second
 const RefreshCircleTime = 3 * time.Second
 const LookupTimeOut = 5 * time.Second
@@ -30,42 +41,142 @@ type IpDataPairs struct {
 	Datas  DataPair
 }
 
+type IpDataLists struct {
+	IpFrom string
+	Datas  []DataPair
+}
+
+type IpKeyLists struct {
+	IpFrom string
+	Keys   []string
+}
+
 type IpIdPairs struct {
 	IpFrom string
 	IdTo   *big.Int
+	Nonce  []byte //应答者需对其签名
 }
 
 type Node struct {
-	Online       bool
-	RPC          rpc.NodeRpc
-	IP           string
-	ID           *big.Int
-	buckets      [160]Bucket
-	refreshIndex int
-	data         Data
-	start        chan bool
-	quit         chan bool
+	Online        bool
+	RPC           rpc.NodeRpc
+	IP            string
+	ID            *big.Int
+	buckets       [160]Bucket
+	refreshIndex  int
+	config        config.Kademlia //bucket大小、alpha与重新发布、舍弃的时长
+	data          Data
+	key           ed25519.PrivateKey  //节点私钥，ID由其公钥确定
+	ids           map[string]*big.Int //已验证的其他节点ID
+	idLock        sync.RWMutex
+	merge         version.MergeFunc
+	hops          trace.Histogram //由当前节点发起的NodeLookup的跳数
+	republishTime metrics.Summary
+	refreshTime   metrics.Summary
+	registry      *metrics.Registry
+	httpListeners []net.Listener //指标与管理接口的HTTP服务，退出时关闭
+	httpLock      sync.Mutex
+	logger        *logrus.Logger //为空时使用logrus的标准日志
+	log           *logrus.Entry  //带有节点地址与ID的日志
+	start         chan bool
+	quit          chan bool
 }
 
 // 整体初始化
 func init() {
-	//f, _ := os.Create("dht-test.//log")
-	//logrus.SetOutput(f)
 	initCal()
 }
 
-// 节点初始化
+// 节点初始化(生成新的私钥作为节点身份)
 func (node *Node) Init(ip string) error {
+	key, err := rpc.GenerateKey()
+	if err != nil {
+		return err
+	}
+	return node.InitWithKey(ip, key)
+}
+
+// 使用给定私钥初始化节点，节点ID由公钥确定，与地址无关
+func (node *Node) InitWithKey(ip string, key ed25519.PrivateKey) error {
+	return node.InitWithConfig(ip, key, config.Default())
+}
+
+// 使用给定私钥与配置初始化节点(配置中的RPC与Kademlia部分)，配置不合法时返回错误
+func (node *Node) InitWithConfig(ip string, key ed25519.PrivateKey, config config.Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	node.config = config.Kademlia
+	node.RPC.Config = config.RPC
 	node.Online = false
 	node.IP = ip
-	node.ID = getHash(ip)
+	node.setKey(key)
+	node.idLock.Lock()
+	node.ids = make(map[string]*big.Int)
+	node.idLock.Unlock()
 	for i := range node.buckets {
 		node.buckets[i].init(node.IP, node)
 	}
 	node.refreshIndex = 150
-	node.data.Init()
+	node.data.Init(node.config)
+	node.merge = version.LastWriteWins
 	node.start = make(chan bool, 1)
 	node.quit = make(chan bool, 1)
+	node.registry = node.newRegistry()
+	return nil
+}
+
+// 设置合并函数(需在Init之后调用)，用于由并发写入产生的多个版本得到一个值，默认取写入时间最晚的值
+func (node *Node) SetMerge(merge version.MergeFunc) {
+	node.merge = merge
+}
+
+// 设置节点的日志(Init之前或之后调用均可)，日志带有节点地址与ID字段，为空时使用logrus的标准日志
+func (node *Node) SetLogger(logger *logrus.Logger) {
+	node.logger = logger
+	if node.ID != nil {
+		node.log = logging.ForNode(logger, node.IP, node.ID)
+		node.RPC.Log = node.log
+	}
+}
+
+// 节点的日志
+func (node *Node) Logger() *logrus.Entry {
+	return logging.OrDefault(node.log)
+}
+
+// 由当前节点发起的NodeLookup的跳数(轮数)直方图
+func (node *Node) Hops() *trace.Histogram {
+	return &node.hops
+}
+
+// 设置墓碑的保留时长(默认为配置的AbandonTime)，应长于数据在未收到删除的节点上存活的时间，否则旧数据可能复活
+func (node *Node) SetTombstoneGrace(grace time.Duration) {
+	node.data.setTombstoneGrace(grace)
+}
+
+// 使用dir中的磁盘存储(需在Init之后、Run之前调用)，节点重启后重新载入数据，并按原定时间继续重新发布。
+// 私钥同样保存在dir中(不存在时生成)，重启后ID不变
+func (node *Node) UseDiskStorage(dir string) error {
+	if node.ID == nil {
+		return errors.New("Node should be initialized before using disk storage.")
+	}
+	if node.Online {
+		return errors.New("Disk storage should be used before Run.")
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	key, err := rpc.LoadOrGenerateKey(filepath.Join(dir, KeyFile))
+	if err != nil {
+		return err
+	}
+	err = node.data.Open(dir)
+	if err != nil {
+		return err
+	}
+	node.setKey(key)
 	return nil
 }
 
@@ -79,10 +190,10 @@ func (node *Node) Run() {
 // 创建DHT网络(加入第一个节点)
 func (node *Node) Create() {
 	rand.Seed(time.Now().UnixNano())
-	//logrus.Infof("Create a new DHT net.")
+	node.log.Info("Create a new DHT net.")
 	select { // 阻塞直至run()完成
 	case <-node.start:
-		//logrus.Infof("New node (IP = %s, ID = %v) joins in.", node.IP, node.ID)
+		node.log.Info("New node joins in.")
 	}
 	node.maintain()
 }
@@ -91,76 +202,375 @@ func (node *Node) Create() {
 func (node *Node) Join(ip string) bool {
 	select { // 阻塞直至run()完成
 	case <-node.start:
-		//logrus.Infof("New node (IP = %s, ID = %v) joins in.", node.IP, node.ID)
+		node.log.Info("New node joins in.")
+	}
+	id, err := node.getId(ip)
+	if err != nil {
+		node.log.WithField("bootstrap", ip).WithError(err).Error("Joining error.")
+		return false
 	}
-	i := belong(node.ID, getHash(ip))
-	node.buckets[i].insertToHead(ip)
-	node.nodeLookup(node.ID) //通过查找自身，更新路由表
+	node.buckets[belong(node.ID, id)].insertToHead(ip)
+	node.nodeLookup(context.Background(), node.ID) //通过查找自身，更新路由表
 	node.maintain()
 	return true
 }
 
 // 存入数据
 func (node *Node) Put(key string, value string) bool {
-	nodeList := node.nodeLookup(getHash(key))
-	flag := false
+	return node.PutContext(context.Background(), key, value) == nil
+}
+
+// 存入数据，整个操作服从ctx的截止时间与取消(至少一个节点存入成功即视为成功)
+func (node *Node) PutContext(ctx context.Context, key string, value string) error {
+	return node.PutConsistency(ctx, key, value, rpc.ONE)
+}
+
+// 存入数据，最近的k个节点中至少有level要求数量的节点存入成功才视为成功。
+// 不满足时返回错误，但已存入的节点不会回滚，该值之后仍可能被读到并随重新发布传播
+func (node *Node) PutConsistency(ctx context.Context, key string, value string, level rpc.Consistency) error {
+	return node.putVersions(ctx, DataPair{key, value, nil, false, time.Time{}}, level)
+}
+
+// 存入数据，经过ttl后过期(ttl不为正时永不过期)，过期时间随重新发布传递，各节点同时舍弃
+func (node *Node) PutTTL(ctx context.Context, key string, value string, ttl time.Duration) error {
+	expireTime := time.Time{}
+	if ttl > 0 {
+		expireTime = time.Now().Add(ttl)
+	}
+	return node.putVersions(ctx, DataPair{key, value, nil, false, expireTime}, rpc.ONE)
+}
+
+// 基于读取时得到的时钟context写入新版本，与其他节点的并发写入互不覆盖，读取时得到多个版本
+func (node *Node) PutVersion(ctx context.Context, key string, value string, context version.Clock) error {
+	versions := version.Siblings{{Value: value, Clock: context.Increment(node.IP), Time: time.Now().UnixNano()}}
+	return node.putVersions(ctx, DataPair{key, value, versions, false, time.Time{}}, rpc.ONE)
+}
+
+// 将数据(盲写、盲删或带版本)存入最近的k个节点
+func (node *Node) putVersions(ctx context.Context, dataPair DataPair, level rpc.Consistency) error {
+	nodeList := node.nodeLookup(ctx, getHash(dataPair.Key))
+	acks, found := 0, false
+	var lastErr error = rpc.ErrNoRoute
+	var lock sync.Mutex
 	var wg sync.WaitGroup
 	wg.Add(len(nodeList))
 	for _, ip := range nodeList {
 		go func(ip string) {
 			defer wg.Done()
 			if node.IP == ip {
-				node.data.put(key, value)
-				flag = true
+				ok := node.data.put(dataPair, node.IP)
+				lock.Lock()
+				acks++
+				found = found || ok
+				lock.Unlock()
 			} else {
-				err := node.RPC.RemoteCall(ip, "Kademlia.PutIn", IpDataPairs{node.IP, DataPair{key, value}}, &Null{})
+				ok := false
+				err := node.RPC.RemoteCallContext(ctx, ip, "Kademlia.PutIn", IpDataPairs{node.IP, dataPair}, &ok)
 				if err != nil {
-					//logrus.Errorf("Putting in error, IP = %s: %v", ip, err)
+					node.log.WithField("target", ip).WithError(err).Warn("Putting in error.")
 				}
-				node.flush(ip, err == nil)
+				if err == nil || ctx.Err() == nil { //被取消的调用不代表对方下线
+					node.flush(ip, err == nil)
+				}
+				lock.Lock()
 				if err == nil {
-					flag = true
+					acks++
+					found = found || ok
+				} else {
+					lastErr = err
 				}
+				lock.Unlock()
 			}
 		}(ip)
 	}
 	wg.Wait()
-	return flag
+	if acks > 0 && acks >= level.Required(len(nodeList)) {
+		if dataPair.Delete && !found { //应答的节点都没有这条数据(墓碑仍然写入)
+			return rpc.ErrNotFound
+		}
+		return nil
+	}
+	if acks > 0 {
+		return rpc.ErrUnavailable
+	}
+	return lastErr
 }
 
 // 查找数据
 func (node *Node) Get(key string) (bool, string) {
-	order := Order{}
-	order.init(getHash(key))
-	list := node.FindNode(getHash(key))
-	for _, ipFind := range list {
-		order.insert(ipFind)
+	value, err := node.GetContext(context.Background(), key)
+	return err == nil, value
+}
+
+// 查找数据，整个操作服从ctx的截止时间与取消(多个版本按合并函数得到一个值)
+func (node *Node) GetContext(ctx context.Context, key string) (string, error) {
+	versions, err := node.GetVersions(ctx, key)
+	if err != nil {
+		return "", err
 	}
-	for {
+	return versions.Resolve(node.merge), nil
+}
+
+// 查找数据的各个版本(找到第一个存有该数据的节点即返回)，其时钟的合并可作为PutVersion的context
+func (node *Node) GetVersions(ctx context.Context, key string) (version.Siblings, error) {
+	order := Order{}
+	order.init(node, getHash(key))
+	order.flush(node.FindNode(getHash(key)))
+	for ctx.Err() == nil {
 		callList := order.getUndoneAlpha()
-		findList, value := node.findValueList(&order, callList, key)
-		if value != "" {
-			return true, value
+		findList, versions := node.findValueList(ctx, &order, callList, key)
+		if len(versions) != 0 {
+			return liveVersions(versions)
 		}
 		flag := order.flush(findList) //更新order
 		if !flag {
 			callList = order.getUndoneAll()
-			findList, value = node.findValueList(&order, callList, key)
-			if value != "" {
-				return true, value
+			findList, versions = node.findValueList(ctx, &order, callList, key)
+			if len(versions) != 0 {
+				return liveVersions(versions)
 			}
 			flag = order.flush(findList) //更新order
 		}
 		if !flag {
-			break
+			return nil, rpc.ErrNotFound
 		}
 	}
-	return false, ""
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, rpc.ErrTimeout
+	}
+	return nil, ctx.Err()
+}
+
+// 找到的版本中去除墓碑，只剩墓碑时视为不存在
+func liveVersions(versions version.Siblings) (version.Siblings, error) {
+	versions = versions.Live()
+	if len(versions) == 0 {
+		return nil, rpc.ErrNotFound
+	}
+	return versions, nil
+}
+
+// 查找数据，询问最近的k个节点，至少有level要求数量的节点应答才视为成功，合并各节点返回的所有版本，按合并函数给出结果
+func (node *Node) GetConsistency(ctx context.Context, key string, level rpc.Consistency) (string, error) {
+	if level == rpc.ONE {
+		return node.GetContext(ctx, key)
+	}
+	nodeList := node.nodeLookup(ctx, getHash(key))
+	if len(nodeList) == 0 {
+		return "", rpc.ErrNoRoute
+	}
+	versions := version.Siblings{}
+	acks := 0
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(len(nodeList))
+	for _, ip := range nodeList {
+		go func(ip string) {
+			defer wg.Done()
+			versionsFound := version.Siblings{}
+			if node.IP == ip {
+				_, versionsFound = node.Getout(key)
+			} else {
+				err := node.RPC.RemoteCallContext(ctx, ip, "Kademlia.Getout", IpPairs{node.IP, key}, &versionsFound)
+				if err != nil && !errors.Is(err, rpc.ErrNotFound) {
+					node.log.WithField("target", ip).WithError(err).Debug("Getting out error.")
+					if ctx.Err() == nil { //被取消的调用不代表对方下线
+						node.flush(ip, false)
+					}
+					return
+				}
+				node.flush(ip, true)
+			}
+			lock.Lock()
+			acks++ //不存在也算应答
+			versions = versions.Merge(versionsFound)
+			lock.Unlock()
+		}(ip)
+	}
+	wg.Wait()
+	if acks < level.Required(len(nodeList)) {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", rpc.ErrTimeout
+		}
+		return "", rpc.ErrUnavailable
+	}
+	if len(versions.Live()) == 0 {
+		return "", rpc.ErrNotFound
+	}
+	return versions.Resolve(node.merge), nil
 }
 
 // 删除数据
 func (node *Node) Delete(key string) bool {
-	return true //由于Kademlia协议本身并不支持删除，因此删除操作是无效的
+	return node.DeleteContext(context.Background(), key) == nil
+}
+
+// 删除数据：向最近的k个节点写入墓碑(至少一个节点存入成功即视为成功)，墓碑随重新发布传播，超过保留时长后被舍弃。
+// 应答的节点都没有这条数据时返回rpc.ErrNotFound(与chord相同)
+func (node *Node) DeleteContext(ctx context.Context, key string) error {
+	return node.putVersions(ctx, DataPair{key, "", nil, true, time.Time{}}, rpc.ONE)
+}
+
+// 批量存入数据，按最近的k个节点分组，每个节点只调用一次，返回每个键的结果(成功为nil)
+func (node *Node) MultiPut(ctx context.Context, data map[string]string) map[string]error {
+	pairs := []DataPair{}
+	for key, value := range data {
+		pairs = append(pairs, DataPair{key, value, nil, false, time.Time{}})
+	}
+	return node.putMulti(ctx, pairs)
+}
+
+// 批量查找数据，询问各键最近的k个节点(每个节点只调用一次)，返回查到的值与每个键的结果(成功为nil)
+func (node *Node) MultiGet(ctx context.Context, keys []string) (map[string]string, map[string]error) {
+	groups, lists := node.groupByClosest(ctx, keys)
+	found := make(map[string]version.Siblings)
+	acks := make(map[string]int)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(len(groups))
+	for ip, group := range groups {
+		go func(ip string, group []string) {
+			defer wg.Done()
+			versionsList := []version.Siblings{}
+			if node.IP == ip {
+				versionsList = node.GetoutMulti(group)
+			} else {
+				err := node.RPC.RemoteCallContext(ctx, ip, "Kademlia.GetoutMulti", IpKeyLists{node.IP, group}, &versionsList)
+				if err == nil || ctx.Err() == nil { //被取消的调用不代表对方下线
+					node.flush(ip, err == nil)
+				}
+				if err != nil {
+					node.log.WithField("target", ip).WithError(err).Debug("Getting out error.")
+					return
+				}
+			}
+			lock.Lock()
+			for i, key := range group {
+				acks[key]++
+				if i < len(versionsList) {
+					found[key] = found[key].Merge(versionsList[i])
+				}
+			}
+			lock.Unlock()
+		}(ip, group)
+	}
+	wg.Wait()
+	values := make(map[string]string)
+	errs := make(map[string]error)
+	for key := range lists {
+		if acks[key] == 0 {
+			errs[key] = rpc.ErrNoRoute
+			if ctx.Err() == context.DeadlineExceeded {
+				errs[key] = rpc.ErrTimeout
+			}
+			continue
+		}
+		versions, err := liveVersions(found[key])
+		errs[key] = err
+		if err == nil {
+			values[key] = versions.Resolve(node.merge)
+		}
+	}
+	return values, errs
+}
+
+// 批量删除数据：向各键最近的k个节点写入墓碑，返回每个键的结果(成功为nil，不存在为rpc.ErrNotFound)
+func (node *Node) MultiDelete(ctx context.Context, keys []string) map[string]error {
+	pairs := []DataPair{}
+	for _, key := range keys {
+		pairs = append(pairs, DataPair{key, "", nil, true, time.Time{}})
+	}
+	return node.putMulti(ctx, pairs)
+}
+
+// 将多条数据存入各自最近的k个节点，每个节点只调用一次，每条至少一个节点存入成功即视为成功
+func (node *Node) putMulti(ctx context.Context, pairs []DataPair) map[string]error {
+	keys := []string{}
+	data := make(map[string]DataPair)
+	for _, dataPair := range pairs {
+		keys = append(keys, dataPair.Key)
+		data[dataPair.Key] = dataPair
+	}
+	groups, lists := node.groupByClosest(ctx, keys)
+	acks := make(map[string]int)
+	found := make(map[string]bool)
+	lastErr := make(map[string]error)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(len(groups))
+	for ip, group := range groups {
+		go func(ip string, group []string) {
+			defer wg.Done()
+			datas := []DataPair{}
+			for _, key := range group {
+				datas = append(datas, data[key])
+			}
+			var err error
+			foundList := []bool{}
+			if node.IP == ip {
+				foundList = node.PutInMulti(datas, node.IP)
+			} else {
+				err = node.RPC.RemoteCallContext(ctx, ip, "Kademlia.PutInMulti", IpDataLists{node.IP, datas}, &foundList)
+				if err != nil {
+					node.log.WithField("target", ip).WithError(err).Warn("Putting in error.")
+				}
+				if err == nil || ctx.Err() == nil { //被取消的调用不代表对方下线
+					node.flush(ip, err == nil)
+				}
+			}
+			lock.Lock()
+			for i, key := range group {
+				if err == nil {
+					acks[key]++
+					found[key] = found[key] || (i < len(foundList) && foundList[i])
+				} else {
+					lastErr[key] = err
+				}
+			}
+			lock.Unlock()
+		}(ip, group)
+	}
+	wg.Wait()
+	out := make(map[string]error)
+	for key := range lists {
+		if acks[key] > 0 && data[key].Delete && !found[key] {
+			out[key] = rpc.ErrNotFound
+		} else if acks[key] > 0 {
+			out[key] = nil
+		} else if lastErr[key] != nil {
+			out[key] = lastErr[key]
+		} else {
+			out[key] = rpc.ErrNoRoute
+		}
+	}
+	return out
+}
+
+// 并行地查找各个键最近的k个节点(重复的键只查找一次)，按节点将键分组
+func (node *Node) groupByClosest(ctx context.Context, keys []string) (groups map[string][]string, lists map[string][]string) {
+	groups = make(map[string][]string)
+	lists = make(map[string][]string)
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		if _, ok := lists[key]; ok {
+			continue
+		}
+		lists[key] = nil
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			nodeList := node.nodeLookup(ctx, getHash(key))
+			lock.Lock()
+			lists[key] = nodeList
+			for _, ip := range nodeList {
+				groups[ip] = append(groups[ip], key)
+			}
+			lock.Unlock()
+		}(key)
+	}
+	wg.Wait()
+	return groups, lists
 }
 
 // 正常退出(可通知外界)
@@ -171,7 +581,9 @@ func (node *Node) Quit() {
 	node.republish(node.data.getAllList(), RepublishAllTimeOut)
 	node.Online = false
 	close(node.quit)
-	//logrus.Infof("Node (IP = %s, ID = %v) quits.", node.IP, node.ID)
+	node.data.Close()
+	node.closeHTTP()
+	node.log.Info("Node quits.")
 }
 
 // 异常退出(不通知外界)
@@ -181,7 +593,9 @@ func (node *Node) ForceQuit() {
 	}
 	node.Online = false
 	close(node.quit)
-	//logrus.Infof("Node (IP = %s, ID = %v) force quits.", node.IP, node.ID)
+	node.data.Close()
+	node.closeHTTP()
+	node.log.Info("Node force quits.")
 }
 
 // 测试节点是否上线
@@ -195,7 +609,22 @@ func (node *Node) Ping(ipTo string) bool {
 
 // 刷新节点的bucket(用于remote call之后)
 func (node *Node) flush(ip string, online bool) {
-	i := belong(node.ID, getHash(ip))
+	var id *big.Int
+	if online {
+		var err error
+		id, err = node.getId(ip)
+		if err != nil { //身份无法验证的节点不加入bucket
+			return
+		}
+	} else {
+		ok := false
+		id, ok = node.cachedId(ip)
+		if !ok { //未验证过ID的节点不会在bucket中
+			return
+		}
+		node.forgetId(ip)
+	}
+	i := belong(node.ID, id)
 	if i != -1 {
 		node.buckets[i].flush(ip, online) //online表示目标节点是否上线
 	}
@@ -214,7 +643,7 @@ func (node *Node) FindNode(id *big.Int) (nodeList []string) {
 				nodeList = append(nodeList, ipNode)
 			}
 		}
-		if len(nodeList) == k {
+		if len(nodeList) == node.config.K {
 			done <- true
 			return
 		}
@@ -222,7 +651,7 @@ func (node *Node) FindNode(id *big.Int) (nodeList []string) {
 			tmpList := node.buckets[j].getAll()
 			for _, ipNode := range tmpList {
 				nodeList = append(nodeList, ipNode)
-				if len(nodeList) == k {
+				if len(nodeList) == node.config.K {
 					done <- true
 					return
 				}
@@ -232,7 +661,7 @@ func (node *Node) FindNode(id *big.Int) (nodeList []string) {
 			tmpList := node.buckets[j].getAll()
 			for _, ipNode := range tmpList {
 				nodeList = append(nodeList, ipNode)
-				if len(nodeList) == k {
+				if len(nodeList) == node.config.K {
 					done <- true
 					return
 				}
@@ -248,29 +677,38 @@ func (node *Node) FindNode(id *big.Int) (nodeList []string) {
 	case <-done:
 		return nodeList
 	case <-time.After(FindNodeTimeOut):
-		//logrus.Errorf("Time out: func findNode, IP = %s.", node.IP)
+		node.log.Warn("Time out: func findNode.")
 		return nodeList
 	}
 
 }
 
 // 找到系统中距离目标最近的k个节点ip
-func (node *Node) nodeLookup(id *big.Int) (findList []string) {
+func (node *Node) nodeLookup(ctx context.Context, id *big.Int) (findList []string) {
+	findList, _ = node.NodeLookupTrace(ctx, id)
+	return findList
+}
+
+// 找到系统中距离目标最近的k个节点ip，并给出查找中的各次询问(同一轮并行的询问轮次相同)，跳数(轮数)记入Hops直方图
+func (node *Node) NodeLookupTrace(ctx context.Context, id *big.Int) ([]string, *trace.Trace) {
 	order := Order{}
-	order.init(id)
+	order.init(node, id)
+	tr := trace.New()
+	ctx, cancel := context.WithTimeout(ctx, LookupTimeOut)
+	defer cancel()
 	done := make(chan bool, 1)
 	go func() {
-		list := node.FindNode(id)
-		for _, ipFind := range list {
-			order.insert(ipFind)
-		}
-		for {
+		order.flush(node.FindNode(id))
+		round := 1
+		for ctx.Err() == nil {
 			callList := order.getUndoneAlpha()
-			findList := node.findNodeList(&order, callList, id)
+			findList := node.findNodeList(ctx, &order, callList, id, tr, round)
+			round++
 			flag := order.flush(findList) //更新order
 			if !flag {
 				callList = order.getUndoneAll()
-				findList = node.findNodeList(&order, callList, id)
+				findList = node.findNodeList(ctx, &order, callList, id, tr, round)
+				round++
 				flag = order.flush(findList) //更新order
 			}
 			if !flag {
@@ -282,34 +720,42 @@ func (node *Node) nodeLookup(id *big.Int) (findList []string) {
 	}()
 	select {
 	case <-done:
-		return order.getClosest()
-	case <-time.After(LookupTimeOut):
-		//logrus.Errorf("Time out: func nodeLookup, IP = %s", node.IP)
-		return order.getClosest()
+	case <-ctx.Done():
+		node.log.Warn("Time out: func nodeLookup.")
 	}
-
+	tr.Finish()
+	node.hops.Observe(tr.HopCount())
+	return order.getClosest(), tr
 }
 
-// 给出可能的最近k个的目标的候补列表（用于NodeLookup）
-func (node *Node) findNodeList(order *Order, callList []*orderUnit, idTarget *big.Int) []string {
+// 给出可能的最近k个的目标的候补列表（用于NodeLookup），各次询问记入tr
+func (node *Node) findNodeList(ctx context.Context, order *Order, callList []*orderUnit, idTarget *big.Int, tr *trace.Trace, round int) []string {
 	findList := []string{}
 	var lock sync.Mutex
 	var wg sync.WaitGroup
 	wg.Add(len(callList))
 	for _, p := range callList {
 		go func(q *orderUnit) {
-			subFindList := []string{}
+			reply := FindNodeReply{}
 			defer wg.Done()
 			q.done = true
-			err := node.RPC.RemoteCall(q.ip, "Kademlia.FindNode", IpIdPairs{node.IP, idTarget}, &subFindList)
-			node.flush(q.ip, err == nil)
+			nonce := rpc.NewNonce()
+			start := time.Now()
+			err := node.RPC.RemoteCallContext(ctx, q.ip, "Kademlia.FindNode", IpIdPairs{node.IP, idTarget, nonce}, &reply)
+			if err == nil && !node.verifyFindNode(nonce, q.ip, idTarget, reply) {
+				err = errors.New("Invalid FindNode reply.")
+			}
+			tr.Add(trace.Hop{IP: q.ip, Round: round, Latency: time.Since(start), Err: rpc.EncodeError(err)})
+			if err == nil || ctx.Err() == nil { //被取消的调用不代表对方下线
+				node.flush(q.ip, err == nil)
+			}
 			if err != nil {
-				//logrus.Errorf("FindNode error, server IP = %s", q.ip)
+				node.log.WithField("server", q.ip).WithError(err).Debug("FindNode error.")
 				order.delete(q)
 				return
 			}
 			lock.Lock()
-			findList = append(findList, subFindList...)
+			findList = append(findList, reply.List...)
 			lock.Unlock()
 		}(p)
 	}
@@ -321,8 +767,8 @@ func (node *Node) findNodeList(order *Order, callList []*orderUnit, idTarget *bi
 func (node *Node) republish(republishList []DataPair, timeOut time.Duration) {
 	done := make(chan bool, 1)
 	var wg sync.WaitGroup
+	wg.Add(len(republishList))
 	go func() {
-		wg.Add(len(republishList))
 		for _, dataPair := range republishList {
 			doneRepub := make(chan bool, 1)
 			go node.republishData(dataPair, &wg, doneRepub)
@@ -338,28 +784,28 @@ func (node *Node) republish(republishList []DataPair, timeOut time.Duration) {
 	}()
 	select {
 	case <-done:
-		//logrus.Infof("Node (IP = %s) republishes the data.", node.IP)
+		node.log.Debug("Node republishes the data.")
 		return
 	case <-time.After(timeOut):
-		//logrus.Errorf("Time out: func republish, IP = %s", node.IP)
+		node.log.Warn("Time out: func republish.")
 		return
 	}
 }
 
 // 发布一条数据
 func (node *Node) republishData(dataPair DataPair, wg *sync.WaitGroup, done chan bool) {
-	nodeList := node.nodeLookup(getHash(dataPair.Key))
+	nodeList := node.nodeLookup(context.Background(), getHash(dataPair.Key))
 	var wgPut sync.WaitGroup
 	wgPut.Add(len(nodeList))
 	for _, ip := range nodeList {
 		go func(ip string) {
 			defer wgPut.Done()
 			if ip == node.IP {
-				node.PutIn(dataPair)
+				node.PutIn(dataPair, node.IP)
 			} else {
-				err := node.RPC.RemoteCall(ip, "Kademlia.PutIn", IpDataPairs{node.IP, dataPair}, &Null{})
+				err := node.RPC.RemoteCall(ip, "Kademlia.PutIn", IpDataPairs{node.IP, dataPair}, new(bool))
 				if err != nil {
-					//logrus.Errorf("Republishing error, IP = %s: %v", ip, err)
+					node.log.WithField("target", ip).WithError(err).Warn("Republishing error.")
 				}
 				node.flush(ip, err == nil)
 			}
@@ -370,40 +816,67 @@ func (node *Node) republishData(dataPair DataPair, wg *sync.WaitGroup, done chan
 	done <- true
 }
 
-// 将某条数据存入该节点
-func (node *Node) PutIn(dataPair DataPair) {
-	node.data.put(dataPair.Key, dataPair.Value)
+// 将某条数据存入该节点，盲写时以writer的名义覆盖已有的所有版本，返回存入前该节点是否有这条数据
+func (node *Node) PutIn(dataPair DataPair, writer string) bool {
+	return node.data.put(dataPair, writer)
 }
 
-// 查找某个节点的某条数据
-func (node *Node) Getout(key string) (bool, string) {
+// 将多条数据存入该节点，返回各条存入前是否存在
+func (node *Node) PutInMulti(data []DataPair, writer string) []bool {
+	found := make([]bool, len(data))
+	for i, dataPair := range data {
+		found[i] = node.data.put(dataPair, writer)
+	}
+	return found
+}
+
+// 查找某个节点的多条数据的各个版本，不存在的为空
+func (node *Node) GetoutMulti(keys []string) []version.Siblings {
+	out := make([]version.Siblings, len(keys))
+	for i, key := range keys {
+		out[i], _ = node.data.get(key)
+	}
+	return out
+}
+
+// 查找某个节点的某条数据的各个版本
+func (node *Node) Getout(key string) (bool, version.Siblings) {
 	value, ok := node.data.get(key)
 	return ok, value
 }
 
 // 给出可能的最近k个的目标的候补列表，若找到数据值，直接结束（用于Get）
-func (node *Node) findValueList(order *Order, callList []*orderUnit, key string) (findList []string, value string) {
+func (node *Node) findValueList(ctx context.Context, order *Order, callList []*orderUnit, key string) (findList []string, versions version.Siblings) {
 	for _, p := range callList {
-		subFindList := []string{}
+		if ctx.Err() != nil {
+			break
+		}
+		reply := FindNodeReply{}
 		p.done = true
-		err := node.RPC.RemoteCall(p.ip, "Kademlia.FindNode", IpIdPairs{node.IP, getHash(key)}, &subFindList)
-		node.flush(p.ip, err == nil)
+		nonce := rpc.NewNonce()
+		err := node.RPC.RemoteCallContext(ctx, p.ip, "Kademlia.FindNode", IpIdPairs{node.IP, getHash(key), nonce}, &reply)
+		if err == nil && !node.verifyFindNode(nonce, p.ip, getHash(key), reply) {
+			err = errors.New("Invalid FindNode reply.")
+		}
+		if err == nil || ctx.Err() == nil { //被取消的调用不代表对方下线
+			node.flush(p.ip, err == nil)
+		}
 		if err != nil {
-			//logrus.Errorf("FindNode error, server IP = %s", p.ip)
+			node.log.WithField("server", p.ip).WithError(err).Debug("FindNode error.")
 			order.delete(p)
 			continue
 		}
-		err = node.RPC.RemoteCall(p.ip, "Kademlia.Getout", IpPairs{node.IP, key}, &value)
+		err = node.RPC.RemoteCallContext(ctx, p.ip, "Kademlia.Getout", IpPairs{node.IP, key}, &versions)
 		if err != nil {
-			//logrus.Errorf("findValueList error, server IP = %s", p.ip)
+			node.log.WithField("server", p.ip).WithError(err).Debug("findValueList error.")
 			continue
 		}
-		if value != "" {
-			return []string{}, value
+		if len(versions) != 0 {
+			return []string{}, versions
 		}
-		findList = append(findList, subFindList...)
+		findList = append(findList, reply.List...)
 	}
-	return findList, ""
+	return findList, nil
 }
 
 // 舍弃过期数据
@@ -415,7 +888,7 @@ func (node *Node) abandon() {
 func (node *Node) refresh() {
 	node.buckets[node.refreshIndex].check()
 	if node.refreshIndex >= 150 && node.buckets[node.refreshIndex].getSize() < 2 {
-		node.nodeLookup(exp[node.refreshIndex])
+		node.nodeLookup(context.Background(), exp[node.refreshIndex])
 	}
 	node.refreshIndex = (node.refreshIndex-149)%10 + 150
 }
@@ -424,23 +897,27 @@ func (node *Node) refresh() {
 func (node *Node) maintain() {
 	go func() {
 		for node.Online {
+			start := time.Now()
 			node.republish(node.data.getRepublishList(), RepulishTimeOut)
-			time.Sleep(RepulishCircleTime)
+			node.republishTime.Since(start)
+			time.Sleep(time.Duration(node.config.RepublishCircleTime))
 		}
-		//logrus.Infof("Node (IP = %s) stops republishing.", node.IP)
+		node.log.Info("Node stops republishing.")
 	}()
 	go func() {
 		for node.Online {
 			node.abandon()
 			time.Sleep(AbandonCircleTime)
 		}
-		//logrus.Infof("Node (IP = %s) stops abandoning.", node.IP)
+		node.log.Info("Node stops abandoning.")
 	}()
 	go func() {
 		for node.Online {
+			start := time.Now()
 			node.refresh()
+			node.refreshTime.Since(start)
 			time.Sleep(RefreshCircleTime)
 		}
-		//logrus.Infof("Node (IP = %s) stops refreshing.", node.IP)
+		node.log.Info("Node stops refreshing.")
 	}()
 }
@@ -0,0 +1,76 @@
+package kademlia
+
+import (
+	"net"
+	"strconv"
+
+	"dht/metrics"
+)
+
+// 注册节点的指标(在Init时调用)
+func (node *Node) newRegistry() *metrics.Registry {
+	registry := metrics.NewRegistry()
+	registry.Gauge("dht_online", "Whether the node is online.", func() []metrics.Sample {
+		online := 0.0
+		if node.Online {
+			online = 1
+		}
+		return []metrics.Sample{{Value: online}}
+	})
+	registry.Counter("dht_rpc_calls_total", "Remote calls sent by the node, by method and outcome.", node.RPC.Calls(), "method", "outcome")
+	registry.Gauge("dht_rpc_client_pool_idle", "Idle clients in the client pool of each peer.", func() []metrics.Sample {
+		out := []metrics.Sample{}
+		for ip, size := range node.RPC.PoolSizes() {
+			out = append(out, metrics.Sample{Labels: map[string]string{"peer": ip}, Value: float64(size)})
+		}
+		return out
+	})
+	registry.Gauge("dht_stored_keys", "Keys stored by the node, live data and tombstones.", func() []metrics.Sample {
+		live, tombstones := node.data.size()
+		return []metrics.Sample{
+			{Labels: map[string]string{"store": "data"}, Value: float64(live)},
+			{Labels: map[string]string{"store": "tombstone"}, Value: float64(tombstones)},
+		}
+	})
+	registry.Gauge("dht_bucket_size", "Nodes in each non-empty k-bucket.", func() []metrics.Sample {
+		out := []metrics.Sample{}
+		for i := range node.buckets {
+			if size := node.buckets[i].getSize(); size > 0 {
+				out = append(out, metrics.Sample{Labels: map[string]string{"bucket": strconv.Itoa(i)}, Value: float64(size)})
+			}
+		}
+		return out
+	})
+	registry.Summary("dht_republish_duration_seconds", "Time spent in each republish round.", &node.republishTime)
+	registry.Summary("dht_refresh_duration_seconds", "Time spent in each bucket refresh round.", &node.refreshTime)
+	registry.Histogram("dht_lookup_hops", "Rounds of node lookups started by the node.", node.hops.Counts)
+	return registry
+}
+
+// 节点的指标
+func (node *Node) Metrics() *metrics.Registry {
+	return node.registry
+}
+
+// 在addr(如"127.0.0.1:9100")上启动HTTP服务，于/metrics给出节点的指标，节点退出时停止，返回实际监听的地址
+func (node *Node) ServeMetrics(addr string) (net.Addr, error) {
+	listener, err := metrics.Serve(addr, node.registry)
+	if err != nil {
+		node.log.WithField("address", addr).WithError(err).Error("Serving metrics error.")
+		return nil, err
+	}
+	node.httpLock.Lock()
+	node.httpListeners = append(node.httpListeners, listener)
+	node.httpLock.Unlock()
+	return listener.Addr(), nil
+}
+
+// 停止指标与管理接口的HTTP服务
+func (node *Node) closeHTTP() {
+	node.httpLock.Lock()
+	for _, listener := range node.httpListeners {
+		listener.Close()
+	}
+	node.httpListeners = nil
+	node.httpLock.Unlock()
+}
@@ -0,0 +1,85 @@
+package kademlia
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// 经HTTP读取节点的指标
+func scrape(t *testing.T, node *Node) string {
+	t.Helper()
+	server := httptest.NewServer(node.Metrics())
+	defer server.Close()
+	response, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// 取值为正的样本，name含标签
+func hasPositive(output, name string) bool {
+	match := regexp.MustCompile("(?m)^" + regexp.QuoteMeta(name) + " ([0-9.e+]+)$").FindStringSubmatch(output)
+	if match == nil {
+		return false
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	return err == nil && value > 0
+}
+
+func TestMetrics(t *testing.T) {
+	nodes := newTestNetwork(t, 5)
+	for i := 0; i < 20; i++ {
+		if !nodes[0].Put("key"+strconv.Itoa(i), "value") {
+			t.Fatalf("putting key%d error", i)
+		}
+	}
+	if ok, _ := nodes[0].Get("key0"); !ok {
+		t.Fatal("getting key0 error")
+	}
+	output := scrape(t, nodes[0])
+	for _, name := range []string{
+		`dht_online`,
+		`dht_rpc_calls_total{method="Kademlia.Identify",outcome="ok"}`,
+		`dht_rpc_calls_total{method="Kademlia.FindNode",outcome="ok"}`,
+		`dht_lookup_hops_count`,
+	} {
+		if !hasPositive(output, name) {
+			t.Errorf("%s not positive in:\n%s", name, output)
+		}
+	}
+	for _, name := range []string{
+		"# TYPE dht_rpc_calls_total counter",
+		"# TYPE dht_bucket_size gauge",
+		"# TYPE dht_republish_duration_seconds summary",
+		"# TYPE dht_refresh_duration_seconds summary",
+		"# TYPE dht_lookup_hops histogram",
+	} {
+		if !regexp.MustCompile("(?m)^" + regexp.QuoteMeta(name) + "$").MatchString(output) {
+			t.Errorf("%q not found", name)
+		}
+	}
+	if !regexp.MustCompile(`(?m)^dht_bucket_size\{bucket="\d+"\} [1-9]\d*$`).MatchString(output) {
+		t.Errorf("no non-empty bucket in:\n%s", output)
+	}
+	stored := 0
+	for _, node := range nodes {
+		match := regexp.MustCompile(`(?m)^dht_stored_keys\{store="data"\} (\d+)$`).FindStringSubmatch(scrape(t, node))
+		if match != nil {
+			count, _ := strconv.Atoi(match[1])
+			stored += count
+		}
+	}
+	if stored < 20 {
+		t.Fatalf("%d keys stored, want at least 20", stored)
+	}
+}
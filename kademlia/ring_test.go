@@ -0,0 +1,322 @@
+package kademlia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"dht/config"
+	"dht/logging"
+	"dht/rpc"
+)
+
+// 在进程内的传输层上启动size个节点(节点0创建网络，其余依次加入)
+func newTestNetwork(t *testing.T, size int) []*Node {
+	t.Helper()
+	return newTestNetworkWith(t, size, config.Default())
+}
+
+// 同newTestNetwork，各节点使用给定的配置
+func newTestNetworkWith(t *testing.T, size int, config config.Config) []*Node {
+	t.Helper()
+	transport := rpc.NewMemoryTransport()
+	logger, _ := logging.New(logging.Options{Output: io.Discard})
+	nodes := make([]*Node, size)
+	for i := range nodes {
+		node := new(Node)
+		node.SetLogger(logger)
+		key, err := rpc.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := node.InitWithConfig(fmt.Sprintf("node-%d", i), key, config); err != nil {
+			t.Fatal(err)
+		}
+		node.RPC.Transport = transport
+		node.Run()
+		nodes[i] = node
+	}
+	t.Cleanup(func() {
+		for _, node := range nodes {
+			node.ForceQuit()
+		}
+	})
+	nodes[0].Create()
+	for i := 1; i < size; i++ {
+		if !nodes[i].Join(nodes[0].IP) {
+			t.Fatalf("node %d joining error", i)
+		}
+	}
+	return nodes
+}
+
+func TestNetworkOverMemoryTransport(t *testing.T) {
+	size := 30
+	if testing.Short() {
+		size = 10
+	}
+	nodes := newTestNetwork(t, size)
+	for i := 0; i < 100; i++ {
+		if !nodes[i%size].Put("key"+strconv.Itoa(i), "value"+strconv.Itoa(i)) {
+			t.Fatalf("putting key%d error", i)
+		}
+	}
+	check := func(stage string) {
+		t.Helper()
+		fail := 0
+		for i := 0; i < 100; i++ {
+			node := nodes[(i*7)%size]
+			if !node.Online {
+				node = nodes[0]
+			}
+			if ok, value := node.Get("key" + strconv.Itoa(i)); !ok || value != "value"+strconv.Itoa(i) {
+				fail++
+			}
+		}
+		if fail != 0 {
+			t.Fatalf("%d keys not found %s", fail, stage)
+		}
+	}
+	check("after putting")
+	for i := 1; i < size; i += 3 { //数据存于最近的k个节点，少数节点强制退出不影响读取
+		nodes[i].ForceQuit()
+	}
+	time.Sleep(100 * time.Millisecond)
+	check("after force quitting")
+	for i := 0; i < 100; i += 2 {
+		if !nodes[0].Delete("key" + strconv.Itoa(i)) {
+			t.Fatalf("deleting key%d error", i)
+		}
+	}
+	if ok, _ := nodes[size-1].Get("key0"); ok {
+		t.Fatal("deleted key still found")
+	}
+	if ok, _ := nodes[size-1].Get("key1"); !ok {
+		t.Fatal("key not deleted is lost")
+	}
+}
+
+// 有节点强制退出时QUORUM的读写成功；删除不存在的键返回rpc.ErrNotFound
+func TestNetworkConsistencyWithNodeDown(t *testing.T) {
+	nodes := newTestNetwork(t, 8)
+	ctx := context.Background()
+	if err := nodes[0].PutConsistency(ctx, "key", "value1", rpc.ALL); err != nil {
+		t.Fatalf("putting with all nodes online: %v", err)
+	}
+	nodes[3].ForceQuit()
+	if err := nodes[1].PutConsistency(ctx, "key", "value2", rpc.QUORUM); err != nil {
+		t.Fatalf("putting at quorum with a node down: %v", err)
+	}
+	if value, err := nodes[2].GetConsistency(ctx, "key", rpc.QUORUM); err != nil || value != "value2" {
+		t.Fatalf("getting at quorum with a node down: %q, %v", value, err)
+	}
+	//下线的节点仍可能由其他节点的路由表给出，此时ALL的写入返回rpc.ErrUnavailable，但已写入的节点不回滚
+	if err := nodes[1].PutConsistency(ctx, "key", "value3", rpc.ALL); err != nil && !errors.Is(err, rpc.ErrUnavailable) {
+		t.Fatalf("putting at all with a node down: %v", err)
+	}
+	if value, err := nodes[4].GetConsistency(ctx, "key", rpc.QUORUM); err != nil || value != "value3" {
+		t.Fatalf("getting at quorum after putting at all: %q, %v", value, err)
+	}
+	if err := nodes[0].DeleteContext(ctx, "missing"); !errors.Is(err, rpc.ErrNotFound) {
+		t.Fatalf("deleting a missing key: %v, want %v", err, rpc.ErrNotFound)
+	}
+	if err := nodes[0].DeleteContext(ctx, "key"); err != nil {
+		t.Fatalf("deleting a key: %v", err)
+	}
+	if err := nodes[0].DeleteContext(ctx, "key"); !errors.Is(err, rpc.ErrNotFound) {
+		t.Fatalf("deleting a deleted key: %v, want %v", err, rpc.ErrNotFound)
+	}
+}
+
+// 未收到删除的节点重新发布旧数据后，键仍被删除；墓碑超过保留时长(默认与舍弃时长相同)后被舍弃
+func TestNetworkDeleteAfterRepublish(t *testing.T) {
+	cfg := config.Default()
+	cfg.Kademlia.RepublishCircleTime = config.Duration(100 * time.Millisecond)
+	cfg.Kademlia.RepublishTime = config.Duration(300 * time.Millisecond)
+	cfg.Kademlia.AbandonTime = config.Duration(2 * time.Second)
+	nodes := newTestNetworkWith(t, 6, cfg)
+	ctx := context.Background()
+	if err := nodes[0].PutContext(ctx, "key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes[0].PutContext(ctx, "other", "value"); err != nil {
+		t.Fatal(err)
+	}
+	stale := nodes[2]
+	old, ok := stale.data.get("key")
+	if !ok {
+		t.Fatal("key not stored on every node")
+	}
+	deleted := time.Now()
+	if err := nodes[1].DeleteContext(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	stale.data.dataLock.Lock() //模拟未收到删除的节点，旧数据立即到期重新发布
+	stale.data.dataPair["key"] = old
+	stale.data.republishTime["key"] = time.Now()
+	stale.data.dataLock.Unlock()
+	deadline := time.Now().Add(10 * time.Duration(cfg.Kademlia.RepublishTime)) //其他节点重新发布墓碑后，未收到删除的节点也删去旧数据
+	for {
+		versions, _ := stale.data.get("key")
+		if len(versions.Live()) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stale node still holds %v", versions)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	for i, node := range nodes {
+		if ok, value := node.Get("key"); ok {
+			t.Fatalf("deleted key revived on node %d: %q", i, value)
+		}
+		if ok, value := node.Get("other"); !ok || value != "value" {
+			t.Fatalf("key lost after republishing on node %d", i)
+		}
+	}
+	for _, node := range nodes { //保留时长内墓碑不被舍弃
+		node.abandon()
+	}
+	if _, tombstones := stale.data.size(); tombstones != 1 {
+		t.Fatalf("tombstone abandoned within the grace period: %d tombstones", tombstones)
+	}
+	time.Sleep(time.Until(deleted.Add(time.Duration(cfg.Kademlia.AbandonTime))))
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		tombstones := 0
+		for _, node := range nodes {
+			node.abandon()
+			_, n := node.data.size()
+			tombstones += n
+		}
+		if tombstones == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d tombstones left after the grace period", tombstones)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	for i, node := range nodes {
+		if ok, _ := node.Get("key"); ok {
+			t.Fatalf("deleted key revived on node %d after abandoning tombstones", i)
+		}
+		if live, _ := node.data.size(); live != 1 {
+			t.Fatalf("node %d holds %d live keys, want 1", i, live)
+		}
+	}
+}
+
+// PutTTL的过期时间随重新发布传递而不被推迟，过期后读取不到，并在abandon时舍弃
+func TestNetworkPutTTL(t *testing.T) {
+	cfg := config.Default()
+	cfg.Kademlia.RepublishCircleTime = config.Duration(100 * time.Millisecond)
+	cfg.Kademlia.RepublishTime = config.Duration(200 * time.Millisecond)
+	cfg.Kademlia.AbandonTime = config.Duration(2 * time.Second)
+	nodes := newTestNetworkWith(t, 5, cfg)
+	ctx := context.Background()
+	ttl := 800 * time.Millisecond
+	if err := nodes[0].PutTTL(ctx, "key", "value", ttl); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes[0].PutTTL(ctx, "forever", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	if ok, value := nodes[3].Get("key"); !ok || value != "value" {
+		t.Fatal("key with ttl not found before expiring")
+	}
+	nodes[0].data.dataLock.RLock()
+	expireTime := nodes[0].data.expireTime["key"]
+	nodes[0].data.dataLock.RUnlock()
+	time.Sleep(ttl / 2) //期间重新发布过，各节点的过期时间不变
+	for i, node := range nodes {
+		node.data.dataLock.RLock()
+		got := node.data.expireTime["key"]
+		node.data.dataLock.RUnlock()
+		if !got.Equal(expireTime) {
+			t.Fatalf("node %d expires the key at %v, want %v", i, got, expireTime)
+		}
+	}
+	time.Sleep(time.Until(expireTime) + 50*time.Millisecond)
+	for i, node := range nodes {
+		if ok, _ := node.Get("key"); ok {
+			t.Fatalf("expired key found on node %d", i)
+		}
+		node.abandon()
+		node.data.dataLock.RLock()
+		_, ok := node.data.dataPair["key"]
+		node.data.dataLock.RUnlock()
+		if ok {
+			t.Fatalf("expired key still stored on node %d", i)
+		}
+		if ok, _ := node.Get("forever"); !ok {
+			t.Fatalf("key without ttl lost on node %d", i)
+		}
+	}
+}
+
+// 批量读写最近节点各不相同的键，不存在的键在结果中给出rpc.ErrNotFound
+func TestNetworkMulti(t *testing.T) {
+	cfg := config.Default()
+	cfg.Kademlia.K = 3 //每个键只存入3个节点，使不同的键落在不同的节点上
+	nodes := newTestNetworkWith(t, 10, cfg)
+	ctx := context.Background()
+	data := map[string]string{}
+	keys := []string{}
+	for i := 0; i < 40; i++ {
+		key := "multi" + strconv.Itoa(i)
+		data[key] = "value" + strconv.Itoa(i)
+		keys = append(keys, key)
+	}
+	for key, err := range nodes[0].MultiPut(ctx, data) {
+		if err != nil {
+			t.Fatalf("putting %s: %v", key, err)
+		}
+	}
+	holders := map[int]bool{}
+	for i, node := range nodes {
+		node.data.dataLock.RLock()
+		for _, key := range keys {
+			if _, ok := node.data.dataPair[key]; ok {
+				holders[i] = true
+			}
+		}
+		node.data.dataLock.RUnlock()
+	}
+	if len(holders) <= cfg.Kademlia.K {
+		t.Fatalf("keys are stored on nodes %v, want more than %d", holders, cfg.Kademlia.K)
+	}
+
+	values, errs := nodes[4].MultiGet(ctx, append([]string{"missing"}, keys...))
+	for _, key := range keys {
+		if errs[key] != nil || values[key] != data[key] {
+			t.Fatalf("got %q, %v for %s, want %q", values[key], errs[key], key, data[key])
+		}
+	}
+	if !errors.Is(errs["missing"], rpc.ErrNotFound) {
+		t.Fatalf("getting a missing key: %v, want %v", errs["missing"], rpc.ErrNotFound)
+	}
+
+	errs = nodes[7].MultiDelete(ctx, append([]string{"missing"}, keys[:20]...))
+	for _, key := range keys[:20] {
+		if errs[key] != nil {
+			t.Fatalf("deleting %s: %v", key, errs[key])
+		}
+	}
+	if !errors.Is(errs["missing"], rpc.ErrNotFound) {
+		t.Fatalf("deleting a missing key: %v, want %v", errs["missing"], rpc.ErrNotFound)
+	}
+	values, errs = nodes[9].MultiGet(ctx, keys)
+	for i, key := range keys {
+		if i < 20 && !errors.Is(errs[key], rpc.ErrNotFound) {
+			t.Fatalf("deleted key %s: %q, %v", key, values[key], errs[key])
+		}
+		if i >= 20 && (errs[key] != nil || values[key] != data[key]) {
+			t.Fatalf("kept key %s: %q, %v", key, values[key], errs[key])
+		}
+	}
+}
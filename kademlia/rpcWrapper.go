@@ -1,9 +1,8 @@
 package kademlia
 
 import (
-	"errors"
-
-	"github.com/sirupsen/logrus"
+	"dht/rpc"
+	"dht/version"
 )
 
 type RPCWrapper struct {
@@ -14,35 +13,56 @@ func (wrapper *RPCWrapper) Ping(_Null, _ *Null) error {
 	if wrapper.node.Online {
 		return nil
 	}
-	return errors.New("Offline node.")
+	return rpc.ErrOffline
+}
+
+func (wrapper *RPCWrapper) Identify(nonce []byte, identity *rpc.Identity) error {
+	*identity = wrapper.node.Identify(nonce)
+	return nil
 }
 
-func (wrapper *RPCWrapper) FindNode(pair IpIdPairs, findList *[]string) error {
+func (wrapper *RPCWrapper) FindNode(pair IpIdPairs, reply *FindNodeReply) error {
 	list := wrapper.node.FindNode(pair.IdTo)
-	*findList = list
+	*reply = FindNodeReply{list, wrapper.node.signFindNode(pair.Nonce, pair.IdTo, list)}
+	if pair.IpFrom != wrapper.node.IP {
+		wrapper.node.flush(pair.IpFrom, true)
+	}
+	return nil
+}
+
+func (wrapper *RPCWrapper) PutIn(pair IpDataPairs, found *bool) error {
+	*found = wrapper.node.PutIn(pair.Datas, pair.IpFrom)
+	if pair.IpFrom != wrapper.node.IP {
+		wrapper.node.flush(pair.IpFrom, true)
+	}
+	return nil
+}
+
+func (wrapper *RPCWrapper) PutInMulti(pair IpDataLists, found *[]bool) error {
+	*found = wrapper.node.PutInMulti(pair.Datas, pair.IpFrom)
 	if pair.IpFrom != wrapper.node.IP {
 		wrapper.node.flush(pair.IpFrom, true)
 	}
 	return nil
 }
 
-func (wrapper *RPCWrapper) PutIn(pair IpDataPairs, _ *Null) error {
-	wrapper.node.PutIn(pair.Datas)
+func (wrapper *RPCWrapper) GetoutMulti(pair IpKeyLists, versions *[]version.Siblings) error {
+	*versions = wrapper.node.GetoutMulti(pair.Keys)
 	if pair.IpFrom != wrapper.node.IP {
 		wrapper.node.flush(pair.IpFrom, true)
 	}
 	return nil
 }
 
-func (wrapper *RPCWrapper) Getout(pair IpPairs, value *string) error {
+func (wrapper *RPCWrapper) Getout(pair IpPairs, versions *version.Siblings) error {
 	ok := false
-	ok, *value = wrapper.node.Getout(pair.IpTo)
+	ok, *versions = wrapper.node.Getout(pair.IpTo)
 	if pair.IpFrom != wrapper.node.IP {
 		wrapper.node.flush(pair.IpFrom, true)
 	}
 	if !ok {
-		logrus.Errorf("Getting out error, IP = %s.", wrapper.node.IP)
-		return errors.New("Fail to get out data.")
+		wrapper.node.log.WithField("key", pair.IpTo).Debug("Getting out error: not found.")
+		return rpc.ErrNotFound
 	}
 	return nil
 }
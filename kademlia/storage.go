@@ -0,0 +1,198 @@
+package kademlia
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"dht/version"
+)
+
+const snapshotFile = "snapshot"
+const logFile = "log"
+const compactLogSize = 10000                                            //日志记录数超过该值(且超过数据量)时，写入快照并清空日志
+const RepublishSpreadCircles = 4                                        //重启时已到期的数据分散在该数量的重新发布周期内重新发布
+const RepublishSpreadTime = RepublishSpreadCircles * RepulishCircleTime //默认配置下的分散时长
+
+// 持久化的数据记录(包含重新发布时间、舍弃时间与过期时间)
+type dataRecord struct {
+	Delete        bool
+	Key           string
+	Versions      version.Siblings
+	RepublishTime time.Time
+	AbandonTime   time.Time
+	ExpireTime    time.Time
+}
+
+// 打开dir中的磁盘存储(不存在时创建)，由快照与日志恢复数据及其重新发布、舍弃时间。
+// 需在Init之后调用：Init会重新分配存放数据的map，并给出重新发布与舍弃的时长
+func (data *Data) Open(dir string) error {
+	data.dataLock.Lock()
+	defer data.dataLock.Unlock()
+	if data.dataPair == nil {
+		return errors.New("Data should be initialized before opening disk storage.")
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	data.dir = dir
+	records := []dataRecord{}
+	snapshot, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	if err == nil {
+		err = json.Unmarshal(snapshot, &records)
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	for _, record := range records {
+		data.load(record)
+	}
+	log, err := os.Open(filepath.Join(dir, logFile))
+	if err == nil {
+		scanner := bufio.NewScanner(log)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			record := dataRecord{}
+			if json.Unmarshal(scanner.Bytes(), &record) != nil {
+				break //最后一条记录可能因异常退出而不完整
+			}
+			data.load(record)
+			data.logSize++
+		}
+		log.Close()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	data.spreadRepublish()
+	data.log, err = os.OpenFile(filepath.Join(dir, logFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+// 关闭磁盘存储
+func (data *Data) Close() error {
+	data.dataLock.Lock()
+	defer data.dataLock.Unlock()
+	if data.log == nil {
+		return nil
+	}
+	err := data.log.Sync()
+	data.log.Close()
+	data.log = nil
+	return err
+}
+
+// 载入一条记录(需持有dataLock)
+func (data *Data) load(record dataRecord) {
+	expired := !record.ExpireTime.IsZero() && time.Now().After(record.ExpireTime)
+	if record.Delete || expired || time.Now().After(record.AbandonTime) {
+		delete(data.dataPair, record.Key)
+		delete(data.republishTime, record.Key)
+		delete(data.abandonTime, record.Key)
+		delete(data.expireTime, record.Key)
+		return
+	}
+	data.dataPair[record.Key] = record.Versions
+	data.republishTime[record.Key] = record.RepublishTime
+	data.abandonTime[record.Key] = record.AbandonTime
+	data.setExpire(record.Key, record.ExpireTime)
+}
+
+// 重启时已到期的数据不在同一时刻重新发布，而是分散在RepublishSpreadCircles个重新发布周期内(需持有dataLock)
+func (data *Data) spreadRepublish() {
+	now := time.Now()
+	for key, republishTime := range data.republishTime {
+		if now.After(republishTime) {
+			data.republishTime[key] = now.Add(time.Duration(rand.Int63n(int64(data.spreadTime))))
+		}
+	}
+}
+
+// 追加一条日志记录(需持有dataLock)
+func (data *Data) appendLog(record dataRecord) error {
+	if data.log == nil {
+		return nil //未启用磁盘存储
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = data.log.Write(append(line, '\n'))
+	if err == nil {
+		err = data.log.Sync() //写入磁盘后才返回
+	}
+	if err != nil {
+		return err
+	}
+	data.logSize++
+	if data.logSize > compactLogSize && data.logSize > len(data.dataPair) {
+		return data.compact()
+	}
+	return nil
+}
+
+// 写入快照并清空日志(需持有dataLock)
+func (data *Data) compact() error {
+	records := []dataRecord{}
+	for key, versions := range data.dataPair {
+		records = append(records, dataRecord{false, key, versions, data.republishTime[key], data.abandonTime[key], data.expireTime[key]})
+	}
+	snapshot, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(data.dir, snapshotFile+".tmp")
+	err = writeFileSync(tmpPath, snapshot)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpPath, filepath.Join(data.dir, snapshotFile))
+	if err != nil {
+		return err
+	}
+	err = syncDir(data.dir) //改名写入目录后才清空日志，否则断电后可能只剩旧快照与已清空的日志
+	if err != nil {
+		return err
+	}
+	err = data.log.Truncate(0)
+	if err != nil {
+		return err
+	}
+	data.logSize = 0
+	return nil
+}
+
+// 写入文件并同步到磁盘(快照先写入临时文件再改名，改名前需确保内容已落盘)
+func writeFileSync(path string, content []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(content)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// 将目录(其中的改名、新建)同步到磁盘
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,44 @@
+package kademlia
+
+import "testing"
+
+func TestOpenBeforeInit(t *testing.T) {
+	data := Data{}
+	if err := data.Open(t.TempDir()); err == nil {
+		t.Fatal("opening uninitialized data should fail")
+	}
+	node := new(Node)
+	if err := node.UseDiskStorage(t.TempDir()); err == nil {
+		t.Fatal("using disk storage before Init should fail")
+	}
+}
+
+// 以相同的目录重启后ID不变，并重新载入数据
+func TestRestartWithDiskStorage(t *testing.T) {
+	dir := t.TempDir()
+	node := new(Node)
+	if err := node.Init("node-0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.UseDiskStorage(dir); err != nil {
+		t.Fatal(err)
+	}
+	id := node.ID.String()
+	node.PutIn(DataPair{Key: "key", Value: "value"}, "node-1")
+	node.data.Close()
+
+	node = new(Node)
+	if err := node.Init("node-0"); err != nil { //新生成的私钥被目录中保存的私钥替换
+		t.Fatal(err)
+	}
+	if err := node.UseDiskStorage(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer node.data.Close()
+	if node.ID.String() != id {
+		t.Fatal("node got a new ID after restarting")
+	}
+	if ok, versions := node.Getout("key"); !ok || versions.Resolve(node.merge) != "value" {
+		t.Fatalf("reloaded %v, %v", versions, ok)
+	}
+}
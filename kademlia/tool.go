@@ -27,6 +27,7 @@ type orderUnit struct {
 
 // 从头部至尾部，按找离目标距离从小到大排序(用于NodeLookup和Get)
 type Order struct {
+	node     *Node
 	head     *orderUnit
 	tail     *orderUnit
 	lock     sync.RWMutex
@@ -35,8 +36,9 @@ type Order struct {
 }
 
 // 初始化
-func (order *Order) init(id *big.Int) {
+func (order *Order) init(node *Node, id *big.Int) {
 	order.lock.Lock()
+	order.node = node
 	order.head = new(orderUnit)
 	order.tail = new(orderUnit)
 	order.head.next, order.head.prev = order.tail, nil
@@ -60,9 +62,9 @@ func (order *Order) find(ip string) *orderUnit {
 	return nil
 }
 
-// 按序插入order
-func (order *Order) insert(ip string) {
-	dis := xor(getHash(ip), order.idTarget)
+// 按序插入order(id为经过验证的节点ID)
+func (order *Order) insert(ip string, id *big.Int) {
+	dis := xor(id, order.idTarget)
 	order.lock.Lock()
 	defer order.lock.Unlock()
 	p := order.head.next
@@ -78,7 +80,7 @@ func (order *Order) insert(ip string) {
 	p.prev = &newUnit
 }
 
-// 得到order中的前a个未执行查找的节点
+// 得到order中的前alpha个未执行查找的节点
 func (order *Order) getUndoneAlpha() []*orderUnit {
 	getList := []*orderUnit{}
 	size := 0
@@ -89,7 +91,7 @@ func (order *Order) getUndoneAlpha() []*orderUnit {
 			getList = append(getList, p)
 			size++
 		}
-		if size == a {
+		if size == order.node.config.Alpha {
 			break
 		}
 		p = p.next
@@ -122,7 +124,7 @@ func (order *Order) getClosest() []string {
 	for p != order.tail {
 		getList = append(getList, p.ip)
 		size++
-		if size == k {
+		if size == order.node.config.K {
 			break
 		}
 		p = p.next
@@ -140,14 +142,21 @@ func (order *Order) delete(p *orderUnit) {
 	order.lock.Unlock()
 }
 
-// 根据找到的节点列表，刷新order
+// 根据找到的节点列表，刷新order：新节点并行验证身份(不持有order的锁)，无法验证的节点不加入
 func (order *Order) flush(findList []string) bool {
-	flag := false
+	newList := []string{}
 	for _, ipFind := range findList {
-		p := order.find(ipFind)
-		if p == nil {
+		if order.find(ipFind) == nil {
+			newList = append(newList, ipFind)
+		}
+	}
+	flag := false
+	ids := order.node.getIds(newList)
+	for _, ipFind := range newList {
+		id, ok := ids[ipFind]
+		if ok && order.find(ipFind) == nil {
 			flag = true
-			order.insert(ipFind)
+			order.insert(ipFind, id)
 		}
 	}
 	return flag
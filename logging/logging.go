@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 日志配置
+type Options struct {
+	Level  string    //debug、info、warn、error等，为空时为info
+	JSON   bool      //以JSON格式输出(每行一个对象)，否则为文本格式
+	Output io.Writer //为空时输出到标准错误
+}
+
+// 按配置创建日志
+func New(options Options) (*logrus.Logger, error) {
+	logger := logrus.New()
+	if options.Level != "" {
+		level, err := logrus.ParseLevel(options.Level)
+		if err != nil {
+			return nil, err
+		}
+		logger.SetLevel(level)
+	}
+	if options.JSON {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	}
+	logger.SetOutput(os.Stderr)
+	if options.Output != nil {
+		logger.SetOutput(options.Output)
+	}
+	return logger, nil
+}
+
+// 节点的日志，带有节点地址与ID(前8位十六进制)字段；logger为空时使用logrus的标准日志
+func ForNode(logger *logrus.Logger, ip string, id *big.Int) *logrus.Entry {
+	if logger == nil {
+		logger = logrus.StandardLogger()
+	}
+	entry := logger.WithField("ip", ip)
+	if id != nil {
+		entry = entry.WithField("id", fmt.Sprintf("%040x", id)[:8])
+	}
+	return entry
+}
+
+// 未设置日志时使用logrus的标准日志
+func OrDefault(entry *logrus.Entry) *logrus.Entry {
+	if entry == nil {
+		return logrus.NewEntry(logrus.StandardLogger())
+	}
+	return entry
+}
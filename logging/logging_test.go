@@ -0,0 +1,115 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 将每行解析为一个JSON对象
+func parseLines(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	records := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		record := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestJSON(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger, err := New(Options{JSON: true, Output: out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.WithField("key", "a \"quoted\"\nvalue").Info("first")
+	logger.Warn("second")
+	records := parseLines(t, out)
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2", len(records))
+	}
+	if records[0]["msg"] != "first" || records[0]["level"] != "info" || records[0]["key"] != "a \"quoted\"\nvalue" {
+		t.Fatalf("first record %v", records[0])
+	}
+	if records[1]["msg"] != "second" || records[1]["level"] != "warning" {
+		t.Fatalf("second record %v", records[1])
+	}
+
+	out.Reset()
+	logger, _ = New(Options{Output: out})
+	logger.Info("text")
+	if json.Valid(out.Bytes()) || !strings.Contains(out.String(), "msg=text") {
+		t.Fatalf("text output %q", out.String())
+	}
+}
+
+func TestLevel(t *testing.T) {
+	for _, tt := range []struct {
+		level string
+		want  logrus.Level
+	}{
+		{"", logrus.InfoLevel},
+		{"debug", logrus.DebugLevel},
+		{"warn", logrus.WarnLevel},
+		{"ERROR", logrus.ErrorLevel},
+	} {
+		out := &bytes.Buffer{}
+		logger, err := New(Options{Level: tt.level, JSON: true, Output: out})
+		if err != nil {
+			t.Fatalf("level %q: %v", tt.level, err)
+		}
+		if logger.GetLevel() != tt.want {
+			t.Fatalf("level %q: got %v, want %v", tt.level, logger.GetLevel(), tt.want)
+		}
+		logger.Debug("debug")
+		logger.Info("info")
+		logger.Warn("warn")
+		logger.Error("error")
+		levels := []string{}
+		for _, record := range parseLines(t, out) {
+			levels = append(levels, record["msg"].(string))
+		}
+		want := []string{"debug", "info", "warn", "error"}[logrus.DebugLevel-tt.want:] //低于所设级别的记录被丢弃
+		if strings.Join(levels, ",") != strings.Join(want, ",") {
+			t.Fatalf("level %q: logged %v, want %v", tt.level, levels, want)
+		}
+	}
+	if _, err := New(Options{Level: "verbose"}); err == nil {
+		t.Fatal("invalid level accepted")
+	}
+}
+
+func TestForNode(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger, _ := New(Options{Level: "debug", JSON: true, Output: out})
+	id, _ := new(big.Int).SetString("0123456789abcdef0123456789abcdef01234567", 16)
+	entry := ForNode(logger, "node-1", id)
+	entry.Debug("first")
+	entry.WithField("key", "value").Info("second")
+	entry.WithError(errors.New("boom")).Error("third")
+	ForNode(logger, "node-2", nil).Info("no id")
+	records := parseLines(t, out)
+	if len(records) != 4 {
+		t.Fatalf("got %d records, want 4", len(records))
+	}
+	for _, record := range records[:3] {
+		if record["ip"] != "node-1" || record["id"] != "01234567" {
+			t.Fatalf("record without node fields: %v", record)
+		}
+	}
+	if _, ok := records[3]["id"]; records[3]["ip"] != "node-2" || ok {
+		t.Fatalf("record of a node without ID: %v", records[3])
+	}
+	if OrDefault(nil).Logger != logrus.StandardLogger() || ForNode(nil, "node-3", nil).Logger != logrus.StandardLogger() {
+		t.Fatal("nil logger should fall back to the standard logger")
+	}
+}
@@ -0,0 +1,266 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 一个取值及其标签
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// 带标签的计数器，零值可直接使用
+type CounterVec struct {
+	values map[string]float64
+	labels map[string][]string
+	lock   sync.Mutex
+}
+
+// 将标签取值为values的计数加上delta
+func (counter *CounterVec) Add(delta float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	counter.lock.Lock()
+	if counter.values == nil {
+		counter.values = make(map[string]float64)
+		counter.labels = make(map[string][]string)
+	}
+	counter.values[key] += delta
+	counter.labels[key] = values
+	counter.lock.Unlock()
+}
+
+func (counter *CounterVec) Inc(values ...string) {
+	counter.Add(1, values...)
+}
+
+// 标签取值为values的计数
+func (counter *CounterVec) Get(values ...string) float64 {
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+	return counter.values[strings.Join(values, "\xff")]
+}
+
+func (counter *CounterVec) samples(names []string) []Sample {
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+	out := []Sample{}
+	for key, value := range counter.values {
+		labels := make(map[string]string)
+		for i, name := range names {
+			if i < len(counter.labels[key]) {
+				labels[name] = counter.labels[key][i]
+			}
+		}
+		out = append(out, Sample{labels, value})
+	}
+	return out
+}
+
+// 耗时的统计(总次数与总耗时)，零值可直接使用
+type Summary struct {
+	count int64
+	sum   float64
+	lock  sync.Mutex
+}
+
+// 记录一次耗时
+func (summary *Summary) Observe(duration time.Duration) {
+	summary.lock.Lock()
+	summary.count++
+	summary.sum += duration.Seconds()
+	summary.lock.Unlock()
+}
+
+// 记录从start至今的耗时
+func (summary *Summary) Since(start time.Time) {
+	summary.Observe(time.Since(start))
+}
+
+func (summary *Summary) Count() int64 {
+	summary.lock.Lock()
+	defer summary.lock.Unlock()
+	return summary.count
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	collect func() []Sample
+}
+
+// 一个节点的全部指标，按注册顺序以文本格式(Prometheus text exposition format)输出
+type Registry struct {
+	families []family
+	lock     sync.Mutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (registry *Registry) add(name, help, kind string, collect func() []Sample) {
+	registry.lock.Lock()
+	registry.families = append(registry.families, family{name, help, kind, collect})
+	registry.lock.Unlock()
+}
+
+// 注册计数器，labels为标签名(与Add时给出的取值一一对应)
+func (registry *Registry) Counter(name, help string, counter *CounterVec, labels ...string) {
+	registry.add(name, help, "counter", func() []Sample {
+		return counter.samples(labels)
+	})
+}
+
+// 注册耗时统计，输出name_count与name_sum(秒)
+func (registry *Registry) Summary(name, help string, summary *Summary) {
+	registry.add(name, help, "summary", func() []Sample {
+		summary.lock.Lock()
+		defer summary.lock.Unlock()
+		return []Sample{
+			{map[string]string{"__name__": name + "_count"}, float64(summary.count)},
+			{map[string]string{"__name__": name + "_sum"}, summary.sum},
+		}
+	})
+}
+
+// 注册在输出时计算的取值
+func (registry *Registry) Gauge(name, help string, collect func() []Sample) {
+	registry.add(name, help, "gauge", collect)
+}
+
+// 注册直方图，counts[i]为取值为i的次数，输出累计的name_bucket{le="i"}、name_count与name_sum
+func (registry *Registry) Histogram(name, help string, counts func() []int64) {
+	registry.add(name, help, "histogram", func() []Sample {
+		out := []Sample{}
+		total, sum := int64(0), int64(0)
+		for i, count := range counts() {
+			total += count
+			sum += int64(i) * count
+			out = append(out, Sample{map[string]string{"__name__": name + "_bucket", "le": strconv.Itoa(i)}, float64(total)})
+		}
+		return append(out,
+			Sample{map[string]string{"__name__": name + "_bucket", "le": "+Inf"}, float64(total)},
+			Sample{map[string]string{"__name__": name + "_count"}, float64(total)},
+			Sample{map[string]string{"__name__": name + "_sum"}, float64(sum)})
+	})
+}
+
+// 以文本格式输出全部指标
+func (registry *Registry) WriteTo(w io.Writer) (int64, error) {
+	registry.lock.Lock()
+	families := make([]family, len(registry.families))
+	copy(families, registry.families)
+	registry.lock.Unlock()
+	counter := &countWriter{w: w}
+	buf := bufio.NewWriter(counter)
+	for _, f := range families {
+		fmt.Fprintf(buf, "# HELP %s %s\n", f.name, escape(f.help, false))
+		fmt.Fprintf(buf, "# TYPE %s %s\n", f.name, f.kind)
+		samples := f.collect()
+		if f.kind != "histogram" && f.kind != "summary" {
+			sort.SliceStable(samples, func(i, j int) bool {
+				return formatLabels(samples[i].Labels) < formatLabels(samples[j].Labels)
+			})
+		}
+		for _, sample := range samples {
+			name := f.name
+			if sample.Labels["__name__"] != "" {
+				name = sample.Labels["__name__"]
+			}
+			fmt.Fprintf(buf, "%s%s %s\n", name, formatLabels(sample.Labels), formatValue(sample.Value))
+		}
+	}
+	err := buf.Flush()
+	return counter.n, err
+}
+
+// 在/metrics给出指标
+func (registry *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	registry.WriteTo(w)
+}
+
+// 在addr上启动HTTP服务，于/metrics给出registry中的指标，关闭返回的listener即停止服务
+func Serve(addr string, registry *Registry) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	return &serverListener{listener, server}, nil
+}
+
+// 关闭时同时关闭已建立的连接(包括keep-alive的连接)
+type serverListener struct {
+	net.Listener
+	server *http.Server
+}
+
+func (listener *serverListener) Close() error {
+	return listener.server.Close()
+}
+
+func formatLabels(labels map[string]string) string {
+	names := []string{}
+	for name := range labels {
+		if name != "__name__" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	pairs := []string{}
+	for _, name := range names {
+		pairs = append(pairs, name+"=\""+escape(labels[name], true)+"\"")
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func escape(s string, quote bool) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, "\n", "\\n")
+	if quote {
+		s = strings.ReplaceAll(s, "\"", "\\\"")
+	}
+	return s
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (writer *countWriter) Write(p []byte) (int, error) {
+	n, err := writer.w.Write(p)
+	writer.n += int64(n)
+	return n, err
+}
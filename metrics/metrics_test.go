@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeHTTP(t *testing.T) {
+	registry := NewRegistry()
+	calls := &CounterVec{}
+	calls.Inc("Chord.Ping", "ok")
+	calls.Add(2, "Chord.Ping", "timeout")
+	calls.Inc("Chord.Put\"\\\n", "ok")
+	registry.Counter("dht_rpc_calls_total", "Remote calls.\nSecond line.", calls, "method", "outcome")
+	summary := &Summary{}
+	summary.Observe(500 * time.Millisecond)
+	summary.Observe(time.Second)
+	registry.Summary("dht_stabilize_duration_seconds", "Time spent.", summary)
+	registry.Gauge("dht_online", "Whether the node is online.", func() []Sample {
+		return []Sample{{Value: 1}}
+	})
+	registry.Histogram("dht_lookup_hops", "Hops.", func() []int64 {
+		return []int64{0, 2, 1}
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, request)
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Fatalf("wrong content type %q", contentType)
+	}
+	want := `# HELP dht_rpc_calls_total Remote calls.\nSecond line.
+# TYPE dht_rpc_calls_total counter
+dht_rpc_calls_total{method="Chord.Ping",outcome="ok"} 1
+dht_rpc_calls_total{method="Chord.Ping",outcome="timeout"} 2
+dht_rpc_calls_total{method="Chord.Put\"\\\n",outcome="ok"} 1
+# HELP dht_stabilize_duration_seconds Time spent.
+# TYPE dht_stabilize_duration_seconds summary
+dht_stabilize_duration_seconds_count 2
+dht_stabilize_duration_seconds_sum 1.5
+# HELP dht_online Whether the node is online.
+# TYPE dht_online gauge
+dht_online 1
+# HELP dht_lookup_hops Hops.
+# TYPE dht_lookup_hops histogram
+dht_lookup_hops_bucket{le="0"} 0
+dht_lookup_hops_bucket{le="1"} 2
+dht_lookup_hops_bucket{le="2"} 3
+dht_lookup_hops_bucket{le="+Inf"} 3
+dht_lookup_hops_count 3
+dht_lookup_hops_sum 4
+`
+	if got := recorder.Body.String(); got != want {
+		t.Fatalf("wrong output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestServe(t *testing.T) {
+	registry := NewRegistry()
+	registry.Gauge("dht_online", "Whether the node is online.", func() []Sample {
+		return []Sample{{Value: 1}}
+	})
+	listener, err := Serve("127.0.0.1:0", registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := http.Get("http://" + listener.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if !strings.Contains(string(body), "\ndht_online 1\n") {
+		t.Fatalf("gauge not found in:\n%s", body)
+	}
+	listener.Close()
+	if _, err := http.Get("http://" + listener.Addr().String() + "/metrics"); err == nil {
+		t.Fatal("serving after closing")
+	}
+}
@@ -11,22 +11,12 @@ package naive
 import (
 	"net"
 	"net/rpc"
-	"os"
 	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
-// Note: The init() function will be executed when this package is imported.
-// See https://golang.org/doc/effective_go.html#init for more details.
-func init() {
-	// You can use the logrus package to print pretty logs.
-	// Here we set the log output to a file.
-	f, _ := os.Create("dht-test.log")
-	logrus.SetOutput(f)
-}
-
 type Node struct {
 	Addr   string // address and port number of the node, e.g., "localhost:1234"
 	online bool
@@ -0,0 +1,142 @@
+package rpc
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// 时钟：传输层与NodeRpc的时限(拨号、建立连接、等待客户端、默认的调用时限)都由时钟计时，
+// 模拟时可换成手动推进的SimClock
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func()) (stop func() bool) //d之后调用f，stop在f被调用前取消
+}
+
+// 系统时钟
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (SystemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (SystemClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+// 手动推进的时钟：时间只在调用Advance时前进，到期的定时器按(到期时间, 创建顺序)依次触发，
+// 因此同样的推进序列总是以同样的顺序、在同样的(模拟)时间触发定时器
+type SimClock struct {
+	now    time.Time
+	timers simTimers
+	seq    uint64
+	lock   sync.Mutex
+}
+
+type simTimer struct {
+	when    time.Time
+	seq     uint64
+	f       func()
+	stopped bool
+}
+
+// 按到期时间排序的定时器(同时到期时先创建的在前)
+type simTimers []*simTimer
+
+func (timers simTimers) Len() int {
+	return len(timers)
+}
+
+func (timers simTimers) Less(i, j int) bool {
+	if !timers[i].when.Equal(timers[j].when) {
+		return timers[i].when.Before(timers[j].when)
+	}
+	return timers[i].seq < timers[j].seq
+}
+
+func (timers simTimers) Swap(i, j int) {
+	timers[i], timers[j] = timers[j], timers[i]
+}
+
+func (timers *simTimers) Push(x interface{}) {
+	*timers = append(*timers, x.(*simTimer))
+}
+
+func (timers *simTimers) Pop() interface{} {
+	old := *timers
+	timer := old[len(old)-1]
+	*timers = old[:len(old)-1]
+	return timer
+}
+
+// 创建从start开始的手动时钟
+func NewSimClock(start time.Time) *SimClock {
+	return &SimClock{now: start}
+}
+
+func (clock *SimClock) Now() time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	return clock.now
+}
+
+func (clock *SimClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	clock.AfterFunc(d, func() {
+		ch <- clock.Now()
+	})
+	return ch
+}
+
+func (clock *SimClock) AfterFunc(d time.Duration, f func()) func() bool {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	clock.seq++
+	timer := &simTimer{when: clock.now.Add(d), seq: clock.seq, f: f}
+	heap.Push(&clock.timers, timer)
+	return func() bool {
+		clock.lock.Lock()
+		defer clock.lock.Unlock()
+		stopped := !timer.stopped
+		timer.stopped = true
+		return stopped
+	}
+}
+
+// 将时间推进d，依次触发其间到期的定时器(触发时的Now为该定时器的到期时间)，
+// 定时器的函数在当前协程中调用，其中新建的定时器若在d之内到期也会被触发
+func (clock *SimClock) Advance(d time.Duration) {
+	clock.lock.Lock()
+	end := clock.now.Add(d)
+	for len(clock.timers) > 0 && !clock.timers[0].when.After(end) {
+		timer := heap.Pop(&clock.timers).(*simTimer)
+		if timer.stopped {
+			continue
+		}
+		timer.stopped = true
+		clock.now = timer.when
+		clock.lock.Unlock()
+		timer.f()
+		clock.lock.Lock()
+	}
+	clock.now = end
+	clock.lock.Unlock()
+}
+
+// 尚未触发的定时器数
+func (clock *SimClock) Pending() int {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	out := 0
+	for _, timer := range clock.timers {
+		if !timer.stopped {
+			out++
+		}
+	}
+	return out
+}
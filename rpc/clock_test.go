@@ -0,0 +1,58 @@
+package rpc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSimClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewSimClock(start)
+	fired := []string{}
+	clock.AfterFunc(20*time.Millisecond, func() {
+		fired = append(fired, "b")
+	})
+	clock.AfterFunc(10*time.Millisecond, func() {
+		fired = append(fired, "a")
+		clock.AfterFunc(5*time.Millisecond, func() { //触发时新建的定时器在推进的范围内也会触发
+			fired = append(fired, "a2")
+		})
+	})
+	clock.AfterFunc(20*time.Millisecond, func() { //同时到期时按创建顺序
+		fired = append(fired, "c")
+	})
+	stop := clock.AfterFunc(15*time.Millisecond, func() {
+		fired = append(fired, "stopped")
+	})
+	if !stop() || stop() {
+		t.Fatal("stop should succeed exactly once")
+	}
+	after := clock.After(30 * time.Millisecond)
+
+	clock.Advance(9 * time.Millisecond)
+	if len(fired) != 0 || !clock.Now().Equal(start.Add(9*time.Millisecond)) {
+		t.Fatalf("fired %v at %v", fired, clock.Now())
+	}
+	clock.Advance(11 * time.Millisecond)
+	if got := fmt.Sprint(fired); got != "[a a2 b c]" {
+		t.Fatalf("fired %s, want [a a2 b c]", got)
+	}
+	select {
+	case <-after:
+		t.Fatal("After fired early")
+	default:
+	}
+	if clock.Pending() != 1 {
+		t.Fatalf("%d pending timers, want 1", clock.Pending())
+	}
+	clock.Advance(10 * time.Millisecond)
+	select {
+	case now := <-after:
+		if !now.Equal(start.Add(30 * time.Millisecond)) {
+			t.Fatalf("After fired at %v", now)
+		}
+	default:
+		t.Fatal("After did not fire")
+	}
+}
@@ -0,0 +1,50 @@
+package rpc
+
+import (
+	"errors"
+)
+
+// 一致性级别：操作需要多少个副本应答才视为成功
+type Consistency int
+
+const (
+	ONE    Consistency = iota //一个副本应答即可(默认，延迟最低)
+	QUORUM                    //多数副本应答，QUORUM写入后QUORUM读取可读到最新写入
+	ALL                       //全部副本应答
+)
+
+// 共有n个副本时，需要应答的副本数
+func (level Consistency) Required(n int) int {
+	switch level {
+	case QUORUM:
+		return n/2 + 1
+	case ALL:
+		return n
+	}
+	return 1
+}
+
+func (level Consistency) String() string {
+	switch level {
+	case ONE:
+		return "one"
+	case QUORUM:
+		return "quorum"
+	case ALL:
+		return "all"
+	}
+	return "unknown"
+}
+
+// 由名称(one/quorum/all)得到一致性级别
+func ParseConsistency(name string) (Consistency, error) {
+	switch name {
+	case "one":
+		return ONE, nil
+	case "quorum":
+		return QUORUM, nil
+	case "all":
+		return ALL, nil
+	}
+	return ONE, errors.New("Unknown consistency level: " + name + ".")
+}
@@ -0,0 +1,133 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/rpc"
+	"strings"
+)
+
+// 超时错误，同时视作context.DeadlineExceeded
+type timeoutError struct{}
+
+func (timeoutError) Error() string {
+	return "Call time out."
+}
+
+func (timeoutError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// 可区分的错误值，经远端调用返回后仍可通过errors.Is判断
+var (
+	ErrNotFound              = errors.New("Key not found.")
+	ErrTimeout         error = timeoutError{}
+	ErrNoRoute               = errors.New("No route to the responsible node.")
+	ErrOffline               = errors.New("Offline node.")
+	ErrUnavailable           = errors.New("Not enough replicas responded.")
+	ErrConditionFailed       = errors.New("Condition not satisfied.")
+	ErrModeConflict          = errors.New("Mode conflicts with the stored data.")
+	ErrUnsupported           = errors.New("Operation not supported by the protocol.")
+)
+
+var typedErrors = []error{ErrNotFound, ErrTimeout, ErrNoRoute, ErrOffline, ErrUnavailable, ErrConditionFailed, ErrModeConflict, ErrUnsupported}
+
+// 将远端返回的错误还原为对应的错误值(net/rpc只传递错误信息字符串)
+func ParseError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == rpc.ErrShutdown {
+		return ErrOffline
+	}
+	serverError, ok := err.(rpc.ServerError)
+	if !ok {
+		if connectionLost(err) { //对方在调用中途下线，连接被关闭或中断
+			return ErrOffline
+		}
+		return err
+	}
+	for _, typedError := range typedErrors {
+		if strings.HasPrefix(string(serverError), typedError.Error()) {
+			return typedError
+		}
+	}
+	return err
+}
+
+// 连接层面的错误(而非远端返回的错误)
+func connectionLost(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, net.ErrClosed) || (errors.As(err, &netErr) && !netErr.Timeout())
+}
+
+// 调用失败是否因为对方没有应答(连接失败或中断、超时、已下线)，而不是对方处理请求后返回了错误
+func Unanswered(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrOffline) || errors.Is(err, ErrTimeout) {
+		return true
+	}
+	for _, typedError := range typedErrors {
+		if errors.Is(err, typedError) {
+			return false
+		}
+	}
+	_, answered := err.(rpc.ServerError)
+	return !answered
+}
+
+// 查找负责节点失败时给出的错误(超时与取消保持原样)
+func RouteError(err error) error {
+	if err == nil || errors.Is(err, ErrTimeout) || errors.Is(err, context.Canceled) {
+		return err
+	}
+	return ErrNoRoute
+}
+
+// 将错误转为可经远端调用传递的字符串(nil为空串)，用于按条给出结果的批量调用
+func EncodeError(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// 还原EncodeError得到的错误，可区分的错误还原为对应的错误值
+func DecodeError(message string) error {
+	if message == "" {
+		return nil
+	}
+	return ParseError(rpc.ServerError(message))
+}
+
+// 远端调用的结果，用于按结果统计调用次数
+func Outcome(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrOffline):
+		return "offline"
+	case errors.Is(err, ErrNoRoute):
+		return "no_route"
+	case errors.Is(err, ErrUnavailable):
+		return "unavailable"
+	case errors.Is(err, ErrConditionFailed):
+		return "condition_failed"
+	case errors.Is(err, ErrModeConflict):
+		return "mode_conflict"
+	case errors.Is(err, ErrUnsupported):
+		return "unsupported"
+	}
+	return "error"
+}
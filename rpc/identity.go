@@ -0,0 +1,108 @@
+package rpc
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+const NonceSize = 16
+
+// 节点身份证明：公钥及其对消息的签名(节点ID由公钥确定)
+type Identity struct {
+	PublicKey ed25519.PublicKey
+	Signature []byte
+}
+
+// 身份无法验证的节点视为下线，不为其给出可路由的ID
+func UnverifiedError(err error) error {
+	return fmt.Errorf("%w Identity not verified: %v", ErrOffline, err)
+}
+
+// 生成新的节点私钥
+func GenerateKey() (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	return key, err
+}
+
+// 从PEM文件读取节点私钥，文件不存在时生成新私钥并写入(使节点更换地址后ID不变)
+func LoadOrGenerateKey(path string) (ed25519.PrivateKey, error) {
+	keyPEM, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(keyPEM)
+		if block == nil {
+			return nil, errors.New("No private key found in " + path + ".")
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		edKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("Not an Ed25519 private key (" + path + ").")
+		}
+		return edKey, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// 由公钥得到节点ID(sha1，与原先的地址hash同为160位)
+func IdFromPublicKey(publicKey ed25519.PublicKey) *big.Int {
+	hash := sha1.Sum(publicKey)
+	return new(big.Int).SetBytes(hash[:])
+}
+
+// 生成随机数，用于要求对方签名，防止重放
+func NewNonce() []byte {
+	nonce := make([]byte, NonceSize)
+	rand.Read(nonce)
+	return nonce
+}
+
+// 待签名的消息：用途 + 随机数 + 应答者地址 + 其他内容
+func SignMessage(usage string, nonce []byte, ip string, content ...[]byte) []byte {
+	message := append([]byte(usage+"\x00"), nonce...)
+	message = append(message, []byte(ip)...)
+	for _, part := range content {
+		message = append(message, 0)
+		message = append(message, part...)
+	}
+	return message
+}
+
+// 用私钥对消息签名
+func Sign(key ed25519.PrivateKey, message []byte) Identity {
+	return Identity{key.Public().(ed25519.PublicKey), ed25519.Sign(key, message)}
+}
+
+// 验证签名，成功时返回公钥对应的节点ID
+func (identity Identity) Verify(message []byte) (*big.Int, error) {
+	if len(identity.PublicKey) != ed25519.PublicKeySize {
+		return nil, errors.New("Invalid public key.")
+	}
+	if !ed25519.Verify(identity.PublicKey, message, identity.Signature) {
+		return nil, errors.New("Invalid signature.")
+	}
+	return IdFromPublicKey(identity.PublicKey), nil
+}
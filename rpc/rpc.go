@@ -1,14 +1,24 @@
 package rpc
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"net"
 	"net/rpc"
 	"sync"
 	"time"
+
+	"dht/config"
+	"dht/logging"
+	"dht/metrics"
+
+	"github.com/sirupsen/logrus"
 )
 
 type NodeRpc struct {
+	Transport   Transport   //传输层，为空时使用TCP
+	TLSConfig   *tls.Config //不为空时，节点间连接使用TLS双向认证
 	server      *rpc.Server
 	listener    net.Listener
 	clientPool  map[string]chan *rpc.Client //容纳可用客户端
@@ -18,12 +28,25 @@ type NodeRpc struct {
 	connLock    sync.RWMutex
 	listening   bool
 	serveName   []string
+	calls       metrics.CounterVec //发出的远端调用，按方法与结果计数
+	Log         *logrus.Entry      //节点的日志，为空时使用logrus的标准日志
+	Config      config.RPC         //超时与连接池的配置，为零值时使用默认配置
 }
 
 type Null struct{}
 
-const CallTimeOut = 10 * time.Second
+const CallTimeOut = config.DefaultCallTimeOut //默认的远端调用时限，可由Config设置
 const PendClientTimeOut = 500 * time.Millisecond
+const DialTimeOut = time.Second //新建连接时每次拨号的时限
+
+// 得到节点使用的传输层
+func (nodeRpc *NodeRpc) transport() Transport {
+	var transport Transport = TCPTransport{}
+	if nodeRpc.Transport != nil {
+		transport = nodeRpc.Transport
+	}
+	return NewTLSTransport(transport, nodeRpc.TLSConfig)
+}
 
 // 为节点类registerNode注册rpc服务，其中第一个注册的服务应该有Ping函数
 func (nodeRpc *NodeRpc) Register(serveName string, registerNode interface{}) error {
@@ -36,10 +59,10 @@ func (nodeRpc *NodeRpc) Register(serveName string, registerNode interface{}) err
 	nodeRpc.serveName = append(nodeRpc.serveName, serveName)
 	err := nodeRpc.server.RegisterName(serveName, registerNode) // registerNode是需要注册的节点的指针
 	if err != nil {
-		//logrus.Errorf("Registing error: %v.", err)
+		nodeRpc.log().WithField("name", serveName).WithError(err).Error("Registing error.")
 		return err
 	} else {
-		//logrus.Infof("Regist done (name = %s).", serveName)
+		nodeRpc.log().WithField("name", serveName).Debug("Regist done.")
 		return nil
 	}
 }
@@ -54,19 +77,19 @@ func (nodeRpc *NodeRpc) Serve(ip string, start, quit chan bool) error {
 	nodeRpc.AcceptConns = make(chan net.Conn, 10000)
 	nodeRpc.clientLock.Unlock()
 	nodeRpc.connLock.Unlock()
-	nodeRpc.listener, err = net.Listen("tcp", ip)
+	nodeRpc.listener, err = nodeRpc.transport().Listen(ip)
 	if err != nil {
-		//logrus.Errorf("Listening error (server IP = %s): %v.", ip, err)
+		nodeRpc.log().WithError(err).Error("Listening error.")
 		return err
 	} else {
 		nodeRpc.listening = true
-		//logrus.Infof("Listen done (server IP = %s, network = tcp).", ip)
+		nodeRpc.log().Debug("Listen done.")
 	}
 	go func() {
 		for nodeRpc.listening {
 			err := nodeRpc.Accept()
-			if err != nil {
-				//logrus.Errorf("Accepting error (server IP = %s): %v.", ip, err)
+			if err != nil && nodeRpc.listening {
+				nodeRpc.log().WithError(err).Warn("Accepting error.")
 			}
 		}
 	}()
@@ -77,19 +100,66 @@ func (nodeRpc *NodeRpc) Serve(ip string, start, quit chan bool) error {
 		nodeRpc.listener.Close()
 		nodeRpc.closeConn() //结束服务
 	}
-	//logrus.Infof("Node stops serving (server IP = %s).", ip)
+	nodeRpc.log().Info("Node stops serving.")
 	return nil
 }
 
+// 得到使用的配置
+func (nodeRpc *NodeRpc) config() config.RPC {
+	if nodeRpc.Config == (config.RPC{}) {
+		return config.Default().RPC
+	}
+	return nodeRpc.Config
+}
+
+func (nodeRpc *NodeRpc) log() *logrus.Entry {
+	return logging.OrDefault(nodeRpc.Log)
+}
+
 // 远端调用
 func (nodeRpc *NodeRpc) RemoteCall(ip string, serviceMethod string, args interface{}, reply interface{}) error {
-	//logrus.Infof("Remote call (server IP = %s, serviceMethod = %s).", ip, serviceMethod)
+	return nodeRpc.RemoteCallContext(context.Background(), ip, serviceMethod, args, reply)
+}
+
+// 远端调用(服从ctx的截止时间与取消，ctx未设截止时间时使用配置的CallTimeOut)
+func (nodeRpc *NodeRpc) RemoteCallContext(ctx context.Context, ip string, serviceMethod string, args interface{}, reply interface{}) error {
+	nodeRpc.log().WithFields(logrus.Fields{"server": ip, "method": serviceMethod}).Trace("Remote call.")
+	err := nodeRpc.remoteCall(ctx, ip, serviceMethod, args, reply)
+	nodeRpc.calls.Inc(serviceMethod, Outcome(err))
+	return err
+}
+
+// 发出的远端调用的计数(标签为方法与结果)
+func (nodeRpc *NodeRpc) Calls() *metrics.CounterVec {
+	return &nodeRpc.calls
+}
+
+// 各连接池中可用客户端的数量
+func (nodeRpc *NodeRpc) PoolSizes() map[string]int {
+	out := make(map[string]int)
+	nodeRpc.clientLock.RLock()
+	for ip, clients := range nodeRpc.clientPool {
+		out[ip] = len(clients)
+	}
+	nodeRpc.clientLock.RUnlock()
+	return out
+}
+
+func (nodeRpc *NodeRpc) remoteCall(ctx context.Context, ip string, serviceMethod string, args interface{}, reply interface{}) error {
 	if !nodeRpc.listening {
-		return errors.New("Offline node server (IP = " + ip + ").")
+		return ErrOffline
+	}
+	ctx, cancel := nodeRpc.withCallTimeOut(ctx)
+	defer cancel()
+	if ctx.Err() != nil {
+		return contextError(ctx)
+	}
+	client, err := nodeRpc.getClient(ctx, ip)
+	if err != nil && ctx.Err() != nil { // 等待可用用户时被取消，连接池本身无异常
+		return contextError(ctx)
 	}
-	client, err := nodeRpc.getClient(ip)
 	if err != nil {
-		//logrus.Errorf("Getting client error (server IP = %s): %v.", ip, err)
+		nodeRpc.log().WithField("server", ip).WithError(err).Debug("Getting client error.")
 		if client != nil {
 			client.Close()
 			client = nil
@@ -97,44 +167,78 @@ func (nodeRpc *NodeRpc) RemoteCall(ip string, serviceMethod string, args interfa
 		nodeRpc.returnClient(ip, client)
 		return err
 	}
-	done := make(chan error, 1)
-	go func() {
-		done <- client.Call(serviceMethod, args, reply)
-	}()
+	call := client.Go(serviceMethod, args, reply, make(chan *rpc.Call, 1))
 	select {
-	case err := <-done:
-		if err != nil {
-			//logrus.Errorf("Calling error (server IP = %s): %v.", ip, err)
+	case <-call.Done:
+		if call.Error != nil {
+			nodeRpc.log().WithFields(logrus.Fields{"server": ip, "method": serviceMethod}).WithError(call.Error).Debug("Calling error.")
 			if serviceMethod[len(serviceMethod)-4:] == "Ping" {
 				client.Close()
 				client = nil
 			}
 			nodeRpc.returnClient(ip, client)
-			return err
+			return ParseError(call.Error)
 		} else {
-			//logrus.Infof("Call done (server IP = %s, serviceMethod = %s).", ip, serviceMethod)
 			nodeRpc.returnClient(ip, client)
 			return nil
 		}
-	case <-time.After(CallTimeOut):
-		if client != nil {
-			client.Close()
-			client = nil
-		}
-		nodeRpc.returnClient(ip, client)
-		return errors.New("Call time out.") // 超时
+	case <-ctx.Done(): // 超时或被取消，只关闭该客户端使未完成的调用结束，连接池中的其他客户端不受影响
+		client.Close()
+		go nodeRpc.replenish(ip)
+		return contextError(ctx)
 	}
 }
 
+// 为被关闭的客户端补上一个新的客户端，使连接池不因被取消的调用而逐渐耗尽
+func (nodeRpc *NodeRpc) replenish(ip string) {
+	if !nodeRpc.listening {
+		return
+	}
+	ctx, cancel := nodeRpc.withCallTimeOut(context.Background())
+	defer cancel()
+	nodeRpc.dialClient(ctx, ip)
+}
+
+// 节点的时钟：传输层带有时钟时(如模拟用的MemoryTransport)使用其时钟，否则为系统时钟
+func (nodeRpc *NodeRpc) clock() Clock {
+	if clocked, ok := nodeRpc.Transport.(interface{ Clock() Clock }); ok {
+		return clocked.Clock()
+	}
+	return SystemClock{}
+}
+
+// ctx未设截止时间时加上配置的CallTimeOut，由节点的时钟计时，到时以context.DeadlineExceeded为原因取消
+func (nodeRpc *NodeRpc) withCallTimeOut(ctx context.Context) (context.Context, func()) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := nodeRpc.clock().AfterFunc(time.Duration(nodeRpc.config().CallTimeOut), func() {
+		cancel(context.DeadlineExceeded)
+	})
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+// 将ctx结束的原因转换为对应的错误值
+func contextError(ctx context.Context) error {
+	err := context.Cause(ctx)
+	if err == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	return err
+}
+
 // 得到可用用户
-func (nodeRpc *NodeRpc) getClient(ip string) (*rpc.Client, error) {
+func (nodeRpc *NodeRpc) getClient(ctx context.Context, ip string) (*rpc.Client, error) {
 	nodeRpc.clientLock.RLock()
 	clients, ok := nodeRpc.clientPool[ip]
 	nodeRpc.clientLock.RUnlock()
 	if !ok {
-		err := nodeRpc.createClient(ip)
+		err := nodeRpc.createClient(ctx, ip)
 		if err != nil {
-			//logrus.Errorf("Create clients error (server IP = %s): %v", ip, err)
 			return nil, err
 		}
 		nodeRpc.clientLock.RLock()
@@ -142,53 +246,70 @@ func (nodeRpc *NodeRpc) getClient(ip string) (*rpc.Client, error) {
 		nodeRpc.clientLock.RUnlock()
 	}
 	if clients == nil {
-		return nil, errors.New("Offline node.")
+		return nil, ErrOffline
 	}
 	select {
 	case client := <-clients:
 		if client == nil {
 			nodeRpc.deleteClients(ip)
-			return nil, errors.New("Offline node.")
+			return nil, ErrOffline
 		}
 		return client, nil
-	case <-time.After(PendClientTimeOut):
+	case <-nodeRpc.clock().After(PendClientTimeOut):
 		nodeRpc.deleteClients(ip)
-		return nil, errors.New("Get client time out.") // 超时
+		return nil, ErrTimeout // 超时
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
-// 创建nodeRpc至ip节点的连接池
-func (nodeRpc *NodeRpc) createClient(ip string) error {
+// 创建nodeRpc至ip节点的连接池，拨号与建立连接服从ctx的截止时间与取消
+func (nodeRpc *NodeRpc) createClient(ctx context.Context, ip string) error {
+	config := nodeRpc.config()
 	nodeRpc.clientLock.Lock()
-	nodeRpc.clientPool[ip] = make(chan *rpc.Client, 50)
+	nodeRpc.clientPool[ip] = make(chan *rpc.Client, config.PoolSize)
 	nodeRpc.clientLock.Unlock()
 	flag := false
-	for i := 0; i < 10; i++ {
-		conn, err := net.DialTimeout("tcp", ip, time.Second)
-		if err != nil {
-			//logrus.Errorf("Dialing error (server IP = %s): %v.", ip, err)
-			break
-		}
-		client := rpc.NewClient(conn)
-		err = nodeRpc.connect(ip, client)
-		if err != nil {
-			//logrus.Errorf("Connecting error (server IP = %s): %v.", ip, err)
+	for i := 0; i < config.DialConns && ctx.Err() == nil; i++ {
+		if nodeRpc.dialClient(ctx, ip) != nil {
 			break
 		}
 		flag = true
-		nodeRpc.connLock.Lock()
-		nodeRpc.DialConns <- conn
-		nodeRpc.connLock.Unlock()
 	}
 	if flag {
-		//logrus.Infof("Create 10 clients (server IP = %s).", ip)
+		nodeRpc.log().WithField("server", ip).Debug("Create clients.")
 	} else {
 		nodeRpc.deleteClients(ip)
-		return errors.New("Creating clients error.")
+		return ErrOffline //无法建立连接，视为对方下线
 	}
 	return nil
 }
 
+// 新建一个到ip节点的客户端并放入连接池，拨号与Ping都不超过ctx剩余的时间
+func (nodeRpc *NodeRpc) dialClient(ctx context.Context, ip string) error {
+	timeOut := DialTimeOut
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeOut {
+		timeOut = time.Until(deadline)
+	}
+	conn, err := nodeRpc.transport().Dial(ip, timeOut)
+	if err != nil {
+		nodeRpc.log().WithField("server", ip).WithError(err).Debug("Dialing error.")
+		return err
+	}
+	err = nodeRpc.connect(ctx, ip, rpc.NewClient(conn))
+	if err != nil {
+		nodeRpc.log().WithField("server", ip).WithError(err).Debug("Connecting error.")
+		return err
+	}
+	nodeRpc.connLock.Lock()
+	select {
+	case nodeRpc.DialConns <- conn: //已停止服务(通道为nil)或已满时不记录，连接随客户端关闭
+	default:
+	}
+	nodeRpc.connLock.Unlock()
+	return nil
+}
+
 // 归还用户
 func (nodeRpc *NodeRpc) returnClient(ip string, client *rpc.Client) error {
 	if client == nil {
@@ -197,29 +318,35 @@ func (nodeRpc *NodeRpc) returnClient(ip string, client *rpc.Client) error {
 	}
 	nodeRpc.clientLock.Lock()
 	clients, ok := nodeRpc.clientPool[ip]
-	if ok {
-		clients <- client
-	} else {
+	if !ok || !offer(clients, client) {
 		client.Close()
 	}
 	nodeRpc.clientLock.Unlock()
 	return nil
 }
 
+// 不阻塞地将客户端放入连接池，连接池已满时返回false(同时新建连接池的调用可能使客户端多于容量)
+func offer(clients chan *rpc.Client, client *rpc.Client) bool {
+	select {
+	case clients <- client:
+		return true
+	default:
+		return false
+	}
+}
+
 // 关闭相关连接
 func (nodeRpc *NodeRpc) closeConn() {
 	nodeRpc.connLock.Lock()
 	close(nodeRpc.DialConns)
-	for range nodeRpc.DialConns {
-		conn := <-nodeRpc.DialConns
+	for conn := range nodeRpc.DialConns {
 		if conn != nil {
 			conn.Close()
 		}
 	}
 	nodeRpc.DialConns = nil
 	close(nodeRpc.AcceptConns)
-	for range nodeRpc.AcceptConns {
-		conn := <-nodeRpc.AcceptConns
+	for conn := range nodeRpc.AcceptConns {
 		if conn != nil {
 			conn.Close()
 		}
@@ -230,8 +357,7 @@ func (nodeRpc *NodeRpc) closeConn() {
 	for _, clients := range nodeRpc.clientPool {
 		if clients != nil {
 			close(clients)
-			for range clients {
-				client := <-clients
+			for client := range clients {
 				if client != nil {
 					client.Close()
 				}
@@ -242,8 +368,8 @@ func (nodeRpc *NodeRpc) closeConn() {
 	nodeRpc.clientLock.Unlock()
 }
 
-// 构建客户端与服务器的连接
-func (nodeRpc *NodeRpc) connect(ip string, client *rpc.Client) error {
+// 构建客户端与服务器的连接(服从ctx的截止时间与取消)
+func (nodeRpc *NodeRpc) connect(ctx context.Context, ip string, client *rpc.Client) error {
 	if client == nil {
 		return errors.New("Empty Client.")
 	}
@@ -260,21 +386,25 @@ func (nodeRpc *NodeRpc) connect(ip string, client *rpc.Client) error {
 			return err
 		}
 		nodeRpc.clientLock.Lock()
-		nodeRpc.clientPool[ip] <- client
+		if !offer(nodeRpc.clientPool[ip], client) {
+			client.Close()
+		}
 		nodeRpc.clientLock.Unlock()
 		return nil
-	case <-time.After(CallTimeOut):
+	case <-nodeRpc.clock().After(time.Duration(nodeRpc.config().CallTimeOut)):
 		if client != nil {
 			client.Close()
 		}
 		return errors.New("Build connection time out.") // 超时
+	case <-ctx.Done():
+		client.Close()
+		return contextError(ctx)
 	}
 }
 
 func (nodeRpc *NodeRpc) Accept() error {
 	conn, err := nodeRpc.listener.Accept()
 	if err != nil {
-		//logrus.Errorf("Building connection error: %v", err)
 		return err
 	}
 	go nodeRpc.server.ServeConn(conn) //开始服务
@@ -289,8 +419,7 @@ func (nodeRpc *NodeRpc) deleteClients(ip string) {
 	clients, ok := nodeRpc.clientPool[ip]
 	if ok && clients != nil {
 		close(clients)
-		for range clients {
-			client := <-clients
+		for client := range clients {
 			if client != nil {
 				client.Close()
 			}
@@ -0,0 +1,172 @@
+package rpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+const certValidTime = 365 * 24 * time.Hour
+
+// TLS传输层，包裹另一个传输层，连接双方都需出示由同一CA签发的证书
+type tlsTransport struct {
+	inner  Transport
+	config *tls.Config
+}
+
+// 经过TLS的inner，config为nil时直接返回inner
+func NewTLSTransport(inner Transport, config *tls.Config) Transport {
+	if config == nil {
+		return inner
+	}
+	return &tlsTransport{inner, config}
+}
+
+func (transport *tlsTransport) Listen(ip string) (net.Listener, error) {
+	listener, err := transport.inner.Listen(ip)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(listener, transport.config), nil
+}
+
+func (transport *tlsTransport) Dial(ip string, timeOut time.Duration) (net.Conn, error) {
+	conn, err := transport.inner.Dial(ip, timeOut)
+	if err != nil {
+		return nil, err
+	}
+	config := transport.config.Clone()
+	config.ServerName = ip //校验对方证书是否属于目标地址(没有端口的地址，如进程内传输层的地址，整体作为主机名)
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(conn, config)
+	ctx, cancel := context.WithTimeout(context.Background(), timeOut)
+	defer cancel()
+	err = tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// 由节点证书与CA证书池构建双向认证的TLS配置
+func NewTLSConfig(cert tls.Certificate, caPool *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      caPool,
+		ClientCAs:    caPool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+// 从PEM文件中读取节点证书、私钥与CA证书，构建双向认证的TLS配置
+func LoadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("No CA certificate found in " + caFile + ".")
+	}
+	return NewTLSConfig(cert, caPool), nil
+}
+
+// 生成自签名的CA证书与私钥(PEM格式)
+func GenerateCA(name string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template, err := certTemplate(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encodePEM(der, key)
+}
+
+// 生成由CA签发的节点证书与私钥(PEM格式)，hosts为节点的IP或域名(可带端口)
+func GenerateCertificate(caCertPEM, caKeyPEM []byte, hosts []string) (certPEM, keyPEM []byte, err error) {
+	ca, err := tls.X509KeyPair(caCertPEM, caKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template, err := certTemplate("dht node")
+	if err != nil {
+		return nil, nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	for _, host := range hosts {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, ca.PrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encodePEM(der, key)
+}
+
+// 证书模板
+func certTemplate(name string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(certValidTime),
+	}, nil
+}
+
+// 将证书与私钥编码为PEM格式
+func encodePEM(der []byte, key *ecdsa.PrivateKey) (certPEM, keyPEM []byte, err error) {
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return certPEM, keyPEM, nil
+}
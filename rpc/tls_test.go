@@ -0,0 +1,132 @@
+package rpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+// 测试时生成的CA
+type testCA struct {
+	cert, key []byte
+}
+
+func newTestCA(t *testing.T, name string) testCA {
+	t.Helper()
+	cert, key, err := GenerateCA(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCA{cert, key}
+}
+
+// 由ca为hosts签发证书，并以trusted为受信任的CA构建TLS配置
+func (ca testCA) config(t *testing.T, trusted testCA, hosts ...string) *tls.Config {
+	t.Helper()
+	certPEM, keyPEM, err := GenerateCertificate(ca.cert, ca.key, hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(trusted.cert) {
+		t.Fatal("no CA certificate")
+	}
+	return NewTLSConfig(cert, caPool)
+}
+
+// 在transport上启动使用TLS的NodeRpc
+func serveTLSNode(t *testing.T, transport Transport, ip string, config *tls.Config) *NodeRpc {
+	t.Helper()
+	nodeRpc := &NodeRpc{Transport: transport, TLSConfig: config}
+	quit := serve(t, nodeRpc, ip)
+	t.Cleanup(func() { close(quit) })
+	return nodeRpc
+}
+
+func TestTLSMutualHandshake(t *testing.T) {
+	ca := newTestCA(t, "test CA")
+	transport := NewMemoryTransport()
+	a := serveTLSNode(t, transport, "127.0.0.1:1", ca.config(t, ca, "127.0.0.1:1"))
+	serveTLSNode(t, transport, "127.0.0.1:2", ca.config(t, ca, "127.0.0.1:2"))
+	reply := ""
+	if err := a.RemoteCall("127.0.0.1:2", "Echo.Echo", "hello", &reply); err != nil || reply != "hello" {
+		t.Fatalf("reply %q, %v", reply, err)
+	}
+
+	//直接拨号，检查双方都出示了证书
+	dialer := &tlsTransport{transport, ca.config(t, ca, "127.0.0.1:3")}
+	conn, err := dialer.Dial("127.0.0.1:2", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	state := conn.(*tls.Conn).ConnectionState()
+	if !state.HandshakeComplete || len(state.PeerCertificates) == 0 {
+		t.Fatal("handshake not complete")
+	}
+	if len(state.VerifiedChains) == 0 {
+		t.Fatal("server certificate not verified")
+	}
+}
+
+// 进程内传输层的地址没有端口，整体作为证书校验的主机名
+func TestTLSPortlessAddress(t *testing.T) {
+	ca := newTestCA(t, "test CA")
+	transport := NewMemoryTransport()
+	a := serveTLSNode(t, transport, "node-1", ca.config(t, ca, "node-1"))
+	serveTLSNode(t, transport, "node-2", ca.config(t, ca, "node-2"))
+	serveTLSNode(t, transport, "node-3", ca.config(t, ca, "node-4")) //证书不属于该地址
+	reply := ""
+	if err := a.RemoteCall("node-2", "Echo.Echo", "hello", &reply); err != nil || reply != "hello" {
+		t.Fatalf("reply %q, %v", reply, err)
+	}
+	dialer := &tlsTransport{transport, ca.config(t, ca, "node-1")}
+	if conn, err := dialer.Dial("node-3", time.Second); err == nil {
+		conn.Close()
+		t.Fatal("certificate for another address accepted")
+	}
+}
+
+func TestTLSRejectsUntrustedCertificate(t *testing.T) {
+	ca, other := newTestCA(t, "test CA"), newTestCA(t, "other CA")
+	transport := NewMemoryTransport()
+	serveTLSNode(t, transport, "127.0.0.1:1", ca.config(t, ca, "127.0.0.1:1"))
+	reply := ""
+
+	//客户端的证书由不受信任的CA签发
+	intruder := &NodeRpc{Transport: transport, TLSConfig: other.config(t, ca, "127.0.0.1:2")}
+	if err := intruder.RemoteCall("127.0.0.1:1", "Echo.Echo", "hello", &reply); err == nil {
+		t.Fatal("client with an untrusted certificate should be rejected")
+	}
+
+	//服务端的证书不受客户端信任
+	client := &tlsTransport{transport, other.config(t, other, "127.0.0.1:3")}
+	if conn, err := client.Dial("127.0.0.1:1", time.Second); err == nil {
+		conn.Close()
+		t.Fatal("server with an untrusted certificate should be rejected")
+	}
+
+	//证书有效但不属于目标地址
+	serveTLSNode(t, transport, "127.0.0.2:1", ca.config(t, ca, "127.0.0.3:1"))
+	client = &tlsTransport{transport, ca.config(t, ca, "127.0.0.1:3")}
+	if conn, err := client.Dial("127.0.0.2:1", time.Second); err == nil {
+		conn.Close()
+		t.Fatal("certificate for another address should be rejected")
+	}
+}
+
+func TestPlainClientRejectedByTLSNode(t *testing.T) {
+	ca := newTestCA(t, "test CA")
+	transport := NewMemoryTransport()
+	serveTLSNode(t, transport, "127.0.0.1:1", ca.config(t, ca, "127.0.0.1:1"))
+	plain := &NodeRpc{Transport: transport}
+	reply := ""
+	if err := plain.RemoteCall("127.0.0.1:1", "Echo.Echo", "hello", &reply); err == nil {
+		t.Fatal("plain client should be rejected")
+	}
+}
@@ -0,0 +1,294 @@
+package rpc
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// 传输层接口，NodeRpc通过其监听与拨号(默认为TCP)
+type Transport interface {
+	Listen(ip string) (net.Listener, error)
+	Dial(ip string, timeOut time.Duration) (net.Conn, error)
+}
+
+// 基于TCP的传输层
+type TCPTransport struct{}
+
+func (TCPTransport) Listen(ip string) (net.Listener, error) {
+	return net.Listen("tcp", ip)
+}
+
+func (TCPTransport) Dial(ip string, timeOut time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", ip, timeOut)
+}
+
+// 进程内的传输层，节点之间通过内存中的连接通信，不占用真实端口(用于在单个进程中模拟大量节点)。
+// 拨号的时限与数据到达的时间由时钟决定：NewMemoryTransport使用系统时钟，数据立即到达；
+// NewSimTransport使用给定的时钟(如SimClock)与固定的延迟，写入的数据在延迟之后才能读到，
+// 同一连接上的数据按写入顺序到达，各连接上的到达按时钟中定时器的顺序发生，时间只随时钟的推进而前进
+type MemoryTransport struct {
+	listeners map[string]*memoryListener
+	lock      sync.RWMutex
+	clock     Clock
+	latency   time.Duration
+}
+
+// 进程内监听者，Dial产生的连接通过通道交给Accept
+type memoryListener struct {
+	transport *MemoryTransport
+	ip        string
+	conns     chan net.Conn
+	done      chan bool
+	closeOnce sync.Once
+}
+
+type memoryAddr string
+
+func (addr memoryAddr) Network() string {
+	return "memory"
+}
+
+func (addr memoryAddr) String() string {
+	return string(addr)
+}
+
+// 创建进程内的传输层(同一网络中的节点需共用一个MemoryTransport)
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{listeners: make(map[string]*memoryListener)}
+}
+
+// 创建使用clock计时的进程内传输层，每段数据在latency之后到达对方
+func NewSimTransport(clock Clock, latency time.Duration) *MemoryTransport {
+	return &MemoryTransport{listeners: make(map[string]*memoryListener), clock: clock, latency: latency}
+}
+
+// 传输层使用的时钟，使用该传输层的NodeRpc也以其计时
+func (transport *MemoryTransport) Clock() Clock {
+	if transport.clock == nil {
+		return SystemClock{}
+	}
+	return transport.clock
+}
+
+func (transport *MemoryTransport) Listen(ip string) (net.Listener, error) {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+	if transport.listeners == nil {
+		transport.listeners = make(map[string]*memoryListener)
+	}
+	if _, ok := transport.listeners[ip]; ok {
+		return nil, errors.New("Address already in use (IP = " + ip + ").")
+	}
+	listener := &memoryListener{
+		transport: transport,
+		ip:        ip,
+		conns:     make(chan net.Conn),
+		done:      make(chan bool),
+	}
+	transport.listeners[ip] = listener
+	return listener, nil
+}
+
+func (transport *MemoryTransport) Dial(ip string, timeOut time.Duration) (net.Conn, error) {
+	transport.lock.RLock()
+	listener, ok := transport.listeners[ip]
+	transport.lock.RUnlock()
+	if !ok {
+		return nil, errors.New("Connection refused (IP = " + ip + ").")
+	}
+	toServer, toClient := newMemoryPipe(), newMemoryPipe()
+	serverConn := &memoryConn{transport: transport, read: toServer, write: toClient, local: memoryAddr(ip), remote: memoryAddr("")}
+	clientConn := &memoryConn{transport: transport, read: toClient, write: toServer, local: memoryAddr(""), remote: memoryAddr(ip)}
+	select {
+	case listener.conns <- serverConn:
+		return clientConn, nil
+	case <-listener.done:
+		serverConn.Close()
+		clientConn.Close()
+		return nil, errors.New("Connection refused (IP = " + ip + ").")
+	case <-transport.Clock().After(timeOut):
+		serverConn.Close()
+		clientConn.Close()
+		return nil, errors.New("Dial time out.")
+	}
+}
+
+// 连接的一个方向：写入的数据按到达时间排队，写端关闭且数据读完后读到io.EOF
+type memoryPipe struct {
+	chunks       []memoryChunk
+	writerClosed bool
+	readerClosed bool
+	changed      chan struct{} //有数据到达或状态变化时关闭并替换，唤醒等待的读
+	lock         sync.Mutex
+}
+
+type memoryChunk struct {
+	data []byte
+	at   time.Time //到达时间
+}
+
+func newMemoryPipe() *memoryPipe {
+	return &memoryPipe{changed: make(chan struct{})}
+}
+
+// 唤醒等待的读
+func (pipe *memoryPipe) notify() {
+	pipe.lock.Lock()
+	close(pipe.changed)
+	pipe.changed = make(chan struct{})
+	pipe.lock.Unlock()
+}
+
+// 进程内的连接，写入不阻塞，读取等待数据到达
+type memoryConn struct {
+	transport     *MemoryTransport
+	read, write   *memoryPipe
+	local, remote memoryAddr
+	readDeadline  time.Time
+	writeDeadline time.Time
+	deadlineLock  sync.Mutex
+	closeOnce     sync.Once
+}
+
+func (conn *memoryConn) Read(b []byte) (int, error) {
+	clock := conn.transport.Clock()
+	pipe := conn.read
+	for {
+		pipe.lock.Lock()
+		if pipe.readerClosed {
+			pipe.lock.Unlock()
+			return 0, net.ErrClosed
+		}
+		now := clock.Now()
+		if len(pipe.chunks) > 0 && !pipe.chunks[0].at.After(now) {
+			n := copy(b, pipe.chunks[0].data)
+			pipe.chunks[0].data = pipe.chunks[0].data[n:]
+			if len(pipe.chunks[0].data) == 0 {
+				pipe.chunks = pipe.chunks[1:]
+			}
+			pipe.lock.Unlock()
+			return n, nil
+		}
+		if len(pipe.chunks) == 0 && pipe.writerClosed {
+			pipe.lock.Unlock()
+			return 0, io.EOF
+		}
+		changed := pipe.changed
+		pipe.lock.Unlock()
+		conn.deadlineLock.Lock()
+		deadline := conn.readDeadline
+		conn.deadlineLock.Unlock()
+		var expired <-chan time.Time
+		if !deadline.IsZero() {
+			if !deadline.After(now) {
+				return 0, os.ErrDeadlineExceeded
+			}
+			expired = clock.After(deadline.Sub(now))
+		}
+		select {
+		case <-changed:
+		case <-expired:
+		}
+	}
+}
+
+func (conn *memoryConn) Write(b []byte) (int, error) {
+	clock := conn.transport.Clock()
+	conn.deadlineLock.Lock()
+	deadline := conn.writeDeadline
+	conn.deadlineLock.Unlock()
+	if !deadline.IsZero() && !deadline.After(clock.Now()) {
+		return 0, os.ErrDeadlineExceeded
+	}
+	pipe := conn.write
+	pipe.lock.Lock()
+	if pipe.writerClosed {
+		pipe.lock.Unlock()
+		return 0, net.ErrClosed
+	}
+	if pipe.readerClosed {
+		pipe.lock.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+	latency := conn.transport.latency
+	pipe.chunks = append(pipe.chunks, memoryChunk{append([]byte(nil), b...), clock.Now().Add(latency)})
+	pipe.lock.Unlock()
+	if latency > 0 {
+		clock.AfterFunc(latency, pipe.notify)
+	} else {
+		pipe.notify()
+	}
+	return len(b), nil
+}
+
+// 关闭连接：本端的读写立即失败，对方读完已写入的数据后读到io.EOF
+func (conn *memoryConn) Close() error {
+	conn.closeOnce.Do(func() {
+		conn.write.lock.Lock()
+		conn.write.writerClosed = true
+		conn.write.lock.Unlock()
+		conn.write.notify()
+		conn.read.lock.Lock()
+		conn.read.readerClosed = true
+		conn.read.lock.Unlock()
+		conn.read.notify()
+	})
+	return nil
+}
+
+func (conn *memoryConn) LocalAddr() net.Addr {
+	return conn.local
+}
+
+func (conn *memoryConn) RemoteAddr() net.Addr {
+	return conn.remote
+}
+
+func (conn *memoryConn) SetDeadline(t time.Time) error {
+	conn.SetWriteDeadline(t)
+	return conn.SetReadDeadline(t)
+}
+
+func (conn *memoryConn) SetReadDeadline(t time.Time) error {
+	conn.deadlineLock.Lock()
+	conn.readDeadline = t
+	conn.deadlineLock.Unlock()
+	conn.read.notify() //等待中的读按新的截止时间重新等待
+	return nil
+}
+
+func (conn *memoryConn) SetWriteDeadline(t time.Time) error {
+	conn.deadlineLock.Lock()
+	conn.writeDeadline = t
+	conn.deadlineLock.Unlock()
+	return nil
+}
+
+func (listener *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-listener.conns:
+		return conn, nil
+	case <-listener.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (listener *memoryListener) Close() error {
+	listener.closeOnce.Do(func() {
+		close(listener.done)
+		listener.transport.lock.Lock()
+		if listener.transport.listeners[listener.ip] == listener {
+			delete(listener.transport.listeners, listener.ip)
+		}
+		listener.transport.lock.Unlock()
+	})
+	return nil
+}
+
+func (listener *memoryListener) Addr() net.Addr {
+	return memoryAddr(listener.ip)
+}
@@ -0,0 +1,364 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMemoryTransportListenDial(t *testing.T) {
+	transport := NewMemoryTransport()
+	listener, err := transport.Listen("node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if listener.Addr().Network() != "memory" || listener.Addr().String() != "node-1" {
+		t.Fatalf("unexpected address %s/%s", listener.Addr().Network(), listener.Addr())
+	}
+	if _, err := transport.Listen("node-1"); err == nil {
+		t.Fatal("listening twice on the same address should fail")
+	}
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- conn
+	}()
+	client, err := transport.Dial("node-1", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server := <-accepted
+	defer server.Close()
+	go client.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("read %q, %v", buf, err)
+	}
+}
+
+func TestMemoryTransportRefused(t *testing.T) {
+	transport := NewMemoryTransport()
+	if _, err := transport.Dial("nobody", time.Second); err == nil {
+		t.Fatal("dialing an address without listener should fail")
+	}
+}
+
+func TestMemoryTransportTimeout(t *testing.T) {
+	transport := NewMemoryTransport()
+	listener, err := transport.Listen("node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	start := time.Now()
+	_, err = transport.Dial("node-1", 50*time.Millisecond) //无人Accept
+	if err == nil {
+		t.Fatal("dial should time out")
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("dial returned after %v", elapsed)
+	}
+}
+
+func TestMemoryTransportClose(t *testing.T) {
+	transport := NewMemoryTransport()
+	listener, err := transport.Listen("node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acceptErr := make(chan error, 1)
+	go func() {
+		_, err := listener.Accept()
+		acceptErr <- err
+	}()
+	listener.Close()
+	listener.Close() //重复关闭无影响
+	if err := <-acceptErr; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Accept after Close returned %v", err)
+	}
+	if _, err := transport.Dial("node-1", time.Second); err == nil {
+		t.Fatal("dialing a closed listener should fail")
+	}
+	listener, err = transport.Listen("node-1") //关闭后地址可以重新使用
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+}
+
+type echoService struct{}
+
+func (echoService) Ping(_ Null, _ *Null) error {
+	return nil
+}
+
+func (echoService) Echo(value string, reply *string) error {
+	*reply = value
+	return nil
+}
+
+// 启动使用transport的NodeRpc，返回后已在监听
+func serveNode(t *testing.T, transport Transport, ip string) (*NodeRpc, chan bool) {
+	t.Helper()
+	nodeRpc := &NodeRpc{Transport: transport}
+	return nodeRpc, serve(t, nodeRpc, ip)
+}
+
+// 注册Echo服务并启动nodeRpc，返回用于停止的通道
+func serve(t *testing.T, nodeRpc *NodeRpc, ip string) chan bool {
+	t.Helper()
+	if err := nodeRpc.Register("Echo", echoService{}); err != nil {
+		t.Fatal(err)
+	}
+	start, quit := make(chan bool), make(chan bool)
+	go nodeRpc.Serve(ip, start, quit)
+	select {
+	case <-start:
+	case <-time.After(time.Second):
+		t.Fatal("serving time out")
+	}
+	return quit
+}
+
+func TestRemoteCallOverMemoryTransport(t *testing.T) {
+	transport := NewMemoryTransport()
+	a, quitA := serveNode(t, transport, "a")
+	b, quitB := serveNode(t, transport, "b")
+	defer close(quitA)
+	reply := ""
+	if err := a.RemoteCall("b", "Echo.Echo", "hello", &reply); err != nil || reply != "hello" {
+		t.Fatalf("reply %q, %v", reply, err)
+	}
+	if err := a.RemoteCall("c", "Echo.Echo", "hello", &reply); !errors.Is(err, ErrOffline) {
+		t.Fatalf("calling an unknown address returned %v", err)
+	}
+	close(quitB)
+	time.Sleep(50 * time.Millisecond)
+	if err := b.RemoteCall("a", "Echo.Echo", "hello", &reply); !errors.Is(err, ErrOffline) {
+		t.Fatalf("calling from a stopped node returned %v", err)
+	}
+}
+
+// 直到release关闭才应答的服务
+type blockService struct {
+	release chan bool
+}
+
+func (service blockService) Block(_ Null, _ *Null) error {
+	<-service.release
+	return nil
+}
+
+func TestRemoteCallContextDeadline(t *testing.T) {
+	transport := NewMemoryTransport()
+	a, quitA := serveNode(t, transport, "a")
+	b, quitB := serveNode(t, transport, "b")
+	defer close(quitA)
+	defer close(quitB)
+	release := make(chan bool)
+	defer close(release)
+	if err := b.Register("Block", blockService{release}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := a.RemoteCallContext(ctx, "b", "Block.Block", Null{}, &Null{})
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrTimeout) {
+		t.Fatalf("call past its deadline returned %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("call returned %v after its deadline", elapsed)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	start = time.Now()
+	err = a.RemoteCallContext(ctx, "b", "Block.Block", Null{}, &Null{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled call returned %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("call returned %v after being cancelled", elapsed)
+	}
+	if _, ok := a.PoolSizes()["b"]; !ok { //只关闭被取消的调用使用的客户端，连接池仍在
+		t.Fatal("pool to b torn down by a cancelled call")
+	}
+	reply := ""
+	if err := a.RemoteCall("b", "Echo.Echo", "hello", &reply); err != nil || reply != "hello" { //超时的调用不影响之后的调用
+		t.Fatalf("reply %q, %v", reply, err)
+	}
+}
+
+// 接受连接但从不应答的节点：新建连接池时的拨号与Ping同样服从截止时间
+func TestRemoteCallContextDeadlineHungPeer(t *testing.T) {
+	transport := NewMemoryTransport()
+	a, quitA := serveNode(t, transport, "a")
+	defer close(quitA)
+	listener, err := transport.Listen("hung")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close() //不读不写，直到测试结束
+		}
+	}()
+	for _, deadline := range []time.Duration{50 * time.Millisecond, 200 * time.Millisecond} {
+		ctx, cancel := context.WithTimeout(context.Background(), deadline)
+		start := time.Now()
+		err := a.RemoteCallContext(ctx, "hung", "Echo.Echo", "hello", new(string))
+		elapsed := time.Since(start)
+		cancel()
+		if !errors.Is(err, ErrTimeout) {
+			t.Fatalf("calling a hung peer returned %v", err)
+		}
+		if elapsed > deadline+100*time.Millisecond {
+			t.Fatalf("call with a %v deadline returned after %v", deadline, elapsed)
+		}
+	}
+}
+
+// 使用手动时钟时，数据在延迟之后才能读到，同一连接上按写入顺序到达
+func TestSimTransportLatency(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+	transport := NewSimTransport(clock, 10*time.Millisecond)
+	listener, err := transport.Listen("node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	client, err := transport.Dial("node-1", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+	received := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 4)
+		_, err := io.ReadFull(server, buf)
+		if err != nil {
+			t.Error(err)
+		}
+		received <- string(buf)
+	}()
+	client.Write([]byte("pi"))
+	clock.Advance(5 * time.Millisecond)
+	client.Write([]byte("ng"))
+	clock.Advance(9 * time.Millisecond) //"pi"已到达，"ng"未到达
+	select {
+	case got := <-received:
+		t.Fatalf("read %q before the data arrived", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.Advance(time.Millisecond)
+	select {
+	case got := <-received:
+		if got != "ping" {
+			t.Fatalf("read %q, want ping", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("data not delivered after the latency")
+	}
+	client.Close()
+	if _, err := server.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("read after the peer closed returned %v", err)
+	}
+	if _, err := server.Write([]byte("x")); err == nil {
+		t.Fatal("writing to a closed peer should fail")
+	}
+}
+
+// 推进时钟直到done关闭，返回推进的模拟时间
+func drive(t *testing.T, clock *SimClock, step time.Duration, done chan bool) time.Duration {
+	t.Helper()
+	start := clock.Now()
+	for i := 0; i < 10000; i++ {
+		select {
+		case <-done:
+			return clock.Now().Sub(start)
+		case <-time.After(time.Millisecond):
+			clock.Advance(step)
+		}
+	}
+	t.Fatal("not done after driving the clock")
+	return 0
+}
+
+// 使用手动时钟时，NodeRpc的调用与默认时限都由时钟计时
+func TestRemoteCallOverSimTransport(t *testing.T) {
+	clock := NewSimClock(time.Unix(0, 0))
+	latency := 5 * time.Millisecond
+	transport := NewSimTransport(clock, latency)
+	a, quitA := serveNode(t, transport, "a")
+	_, quitB := serveNode(t, transport, "b")
+	defer close(quitA)
+	defer close(quitB)
+
+	done := make(chan bool)
+	reply := ""
+	var err error
+	go func() {
+		err = a.RemoteCall("b", "Echo.Echo", "hello", &reply)
+		close(done)
+	}()
+	elapsed := drive(t, clock, time.Millisecond, done)
+	if err != nil || reply != "hello" {
+		t.Fatalf("reply %q, %v", reply, err)
+	}
+	if elapsed < 4*latency { //建立连接时的Ping与调用本身各需一个往返
+		t.Fatalf("call took %v of simulated time, want at least %v", elapsed, 4*latency)
+	}
+
+	listener, err := transport.Listen("hung") //接受连接但从不应答
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			if _, err := listener.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+	done = make(chan bool)
+	go func() {
+		err = a.RemoteCall("hung", "Echo.Echo", "hello", &reply)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatalf("call to a hung peer returned %v before the clock advanced", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	clock.Advance(CallTimeOut)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("call did not time out after the clock advanced past CallTimeOut")
+	}
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("call to a hung peer returned %v, want %v", err, ErrTimeout)
+	}
+}
@@ -71,8 +71,8 @@ func forceQuitTest() (bool, int, int) {
 		value := randString(lengthOfKeyValue)
 		kvMap[key] = value
 
-		if !nodes[rand.Intn(forceQuitNodeSize+1)].Put(key, value) {
-			putInfo.fail()
+		if err := nodes[rand.Intn(forceQuitNodeSize+1)].Put(key, value); err != nil {
+			putInfo.failWith(err)
 		} else {
 			putInfo.success()
 		}
@@ -102,9 +102,9 @@ func forceQuitTest() (bool, int, int) {
 		}
 		cyan.Printf("Start getting (round %d)\n", t)
 		for key, value := range kvMap {
-			ok, res := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Get(key)
-			if !ok || res != value {
-				getInfo.fail()
+			res, err := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Get(key)
+			if err := checkGet(res, err, value); err != nil {
+				getInfo.failWith(err)
 			} else {
 				getInfo.success()
 			}
@@ -184,8 +184,8 @@ func quitAndStabilizeTest() (bool, int, int) {
 		value := randString(lengthOfKeyValue)
 		kvMap[key] = value
 
-		if !nodes[rand.Intn(QASNodeSize+1)].Put(key, value) {
-			putInfo.fail()
+		if err := nodes[rand.Intn(QASNodeSize+1)].Put(key, value); err != nil {
+			putInfo.failWith(err)
 		} else {
 			putInfo.success()
 		}
@@ -210,9 +210,9 @@ func quitAndStabilizeTest() (bool, int, int) {
 		/* Get some data. */
 		getCnt := 0
 		for key, value := range kvMap {
-			ok, res := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Get(key)
-			if !ok || res != value {
-				getInfo.fail()
+			res, err := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Get(key)
+			if err := checkGet(res, err, value); err != nil {
+				getInfo.failWith(err)
 			} else {
 				getInfo.success()
 			}
@@ -80,8 +80,8 @@ func basicTest() (bool, int, int) {
 			value := randString(lengthOfKeyValue)
 			kvMap[key] = value
 
-			if !nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Put(key, value) {
-				put1Info.fail()
+			if err := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Put(key, value); err != nil {
+				put1Info.failWith(err)
 			} else {
 				put1Info.success()
 			}
@@ -97,9 +97,9 @@ func basicTest() (bool, int, int) {
 		cyan.Printf("Start getting (round %d, part 1)\n", t)
 		get1Cnt := 0
 		for key, value := range kvMap {
-			ok, res := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Get(key)
-			if !ok || res != value {
-				get1Info.fail()
+			res, err := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Get(key)
+			if err := checkGet(res, err, value); err != nil {
+				get1Info.failWith(err)
 			} else {
 				get1Info.success()
 			}
@@ -121,9 +121,9 @@ func basicTest() (bool, int, int) {
 		for i := 1; i <= basicTestRoundDeleteSize; i++ {
 			for key := range kvMap {
 				delete(kvMap, key)
-				success := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Delete(key)
-				if !success {
-					delete1Info.fail()
+				err := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Delete(key)
+				if err != nil {
+					delete1Info.failWith(err)
 				} else {
 					delete1Info.success()
 				}
@@ -158,8 +158,8 @@ func basicTest() (bool, int, int) {
 			value := randString(lengthOfKeyValue)
 			kvMap[key] = value
 
-			if !nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Put(key, value) {
-				put2Info.fail()
+			if err := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Put(key, value); err != nil {
+				put2Info.failWith(err)
 			} else {
 				put2Info.success()
 			}
@@ -175,9 +175,9 @@ func basicTest() (bool, int, int) {
 		cyan.Printf("Start getting (round %d, part 2)\n", t)
 		get2Cnt := 0
 		for key, value := range kvMap {
-			ok, res := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Get(key)
-			if !ok || res != value {
-				get2Info.fail()
+			res, err := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Get(key)
+			if err := checkGet(res, err, value); err != nil {
+				get2Info.failWith(err)
 			} else {
 				get2Info.success()
 			}
@@ -199,9 +199,9 @@ func basicTest() (bool, int, int) {
 		for i := 1; i <= basicTestRoundDeleteSize; i++ {
 			for key := range kvMap {
 				delete(kvMap, key)
-				success := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Delete(key)
-				if !success {
-					delete2Info.fail()
+				err := nodes[nodesInNetwork[rand.Intn(len(nodesInNetwork))]].Delete(key)
+				if err != nil {
+					delete2Info.failWith(err)
 				} else {
 					delete2Info.success()
 				}
@@ -27,12 +27,12 @@ type dhtNode interface {
 	// Ping(addr string) bool
 
 	// Put a key-value pair into the network (if key exists, update the value).
-	// Return "true" if success, "false" otherwise.
-	Put(key string, value string) bool
+	// Return nil if success, or an error telling why it failed (see package client).
+	Put(key string, value string) error
 	// Get a key-value pair from the network.
-	// Return "true" and the value if success, "false" otherwise.
-	Get(key string) (bool, string)
+	// Return the value if success, client.ErrNotFound if the key does not exist, or another error.
+	Get(key string) (string, error)
 	// Remove a key-value pair identified by KEY from the network.
-	// Return "true" if success, "false" otherwise.
-	Delete(key string) bool
+	// Return nil if success, or an error telling why it failed.
+	Delete(key string) error
 }
@@ -2,9 +2,12 @@ package test
 
 import (
 	"bufio"
+	"errors"
 	"os"
 	"strings"
 	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 var testName string
@@ -34,6 +37,25 @@ func Test() {
 			break
 		}
 	}
+	run()
+}
+
+// 测试协议protocol的part部分(basic/advance/all)，返回是否全部通过
+func Run(protocol, part string) (bool, error) {
+	err := SetProtocol(protocol)
+	if err != nil {
+		return false, err
+	}
+	if part != "basic" && part != "advance" && part != "all" {
+		return false, errors.New("Part name error: " + part + ".")
+	}
+	testName = part
+	return run(), nil
+}
+
+// 按testName进行测试，测试panic时返回false
+func run() bool {
+	setLogFile()
 	yellow.Printf("Welcome to DHT-2023 Test Program!\n\n")
 
 	var basicFailRate float64
@@ -48,7 +70,7 @@ func Test() {
 		basicPanicked, basicFailedCnt, basicTotalCnt := basicTest()
 		if basicPanicked {
 			red.Printf("Basic Test Panicked.")
-			os.Exit(0)
+			return false
 		}
 
 		basicFailRate = float64(basicFailedCnt) / float64(basicTotalCnt)
@@ -70,7 +92,7 @@ func Test() {
 		forceQuitPanicked, forceQuitFailedCnt, forceQuitTotalCnt := forceQuitTest()
 		if forceQuitPanicked {
 			red.Printf("Force Quit Test Panicked.")
-			os.Exit(0)
+			return false
 		}
 
 		forceQuitFailRate = float64(forceQuitFailedCnt) / float64(forceQuitTotalCnt)
@@ -86,7 +108,7 @@ func Test() {
 		QASPanicked, QASFailedCnt, QASTotalCnt := quitAndStabilizeTest()
 		if QASPanicked {
 			red.Printf("Quit & Stabilize Test Panicked.")
-			os.Exit(0)
+			return false
 		}
 
 		QASFailRate = float64(QASFailedCnt) / float64(QASTotalCnt)
@@ -114,4 +136,15 @@ func Test() {
 	} else {
 		green.Printf("Quit & Stabilize test passed with fail rate %.4f\n", QASFailRate)
 	}
+	return basicFailRate <= basicTestMaxFailRate && forceQuitFailRate <= forceQuitMaxFailRate && QASFailRate <= QASMaxFailRate
+}
+
+// 测试中节点的日志(logrus的标准日志)写入dht-test.log，不与测试结果混在一起
+func setLogFile() {
+	f, err := os.Create(logFile)
+	if err != nil {
+		red.Println("Creating log file error:", err)
+		return
+	}
+	logrus.SetOutput(f)
 }
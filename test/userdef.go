@@ -1,9 +1,14 @@
 package test
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"dht/chord"
+	"dht/client"
 	"dht/kademlia"
 	"dht/naive"
+	"dht/rpc"
 	"errors"
 )
 
@@ -15,6 +20,12 @@ import (
 
 var Protocol string
 
+// 节点间的传输层，为空时使用TCP(naive协议不受影响)
+var Transport rpc.Transport
+
+// 测试时生成的CA，不为空时节点间使用TLS双向认证
+var tlsCACert, tlsCAKey []byte
+
 func SetProtocol(protocol string) error {
 	if protocol != "naive" && protocol != "chord" && protocol != "kademlia" {
 		return errors.New("Protocol name false.")
@@ -24,21 +35,94 @@ func SetProtocol(protocol string) error {
 	}
 }
 
+// 设置传输层 tcp/memory，memory使所有节点在进程内通信，不占用端口
+func SetTransport(transport string) error {
+	switch transport {
+	case "tcp":
+		Transport = nil
+	case "memory":
+		Transport = rpc.NewMemoryTransport()
+	default:
+		return errors.New("Transport name false.")
+	}
+	return nil
+}
+
+// 启用/关闭TLS，启用时生成自签名CA，并为每个节点签发证书
+func SetTLS(enable bool) error {
+	if !enable {
+		tlsCACert, tlsCAKey = nil, nil
+		return nil
+	}
+	var err error
+	tlsCACert, tlsCAKey, err = rpc.GenerateCA("dht test CA")
+	return err
+}
+
+// 为节点生成TLS配置
+func newTLSConfig(addr string) *tls.Config {
+	if tlsCACert == nil {
+		return nil
+	}
+	certPEM, keyPEM, err := rpc.GenerateCertificate(tlsCACert, tlsCAKey, []string{addr})
+	if err != nil {
+		panic(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		panic(err)
+	}
+	caPool := x509.NewCertPool()
+	caPool.AppendCertsFromPEM(tlsCACert)
+	return rpc.NewTLSConfig(cert, caPool)
+}
+
+// 节点的生命周期(由节点本身实现)
+type lifecycle interface {
+	Run()
+	Create()
+	Join(addr string) bool
+	Quit()
+	ForceQuit()
+}
+
+// 测试中的节点：数据操作经过client，失败时给出可区分的错误
+type testNode struct {
+	lifecycle
+	client client.Client
+}
+
+func (node testNode) Put(key string, value string) error {
+	return node.client.Put(context.Background(), key, value)
+}
+
+func (node testNode) Get(key string) (string, error) {
+	return node.client.Get(context.Background(), key)
+}
+
+func (node testNode) Delete(key string) error {
+	return node.client.Delete(context.Background(), key)
+}
+
 func NewNode(port int) dhtNode {
 	// Todo: create a node and then return it.
 	switch Protocol {
 	case "naive":
 		node := new(naive.Node)
 		node.Init(portToAddr(localAddress, port))
-		return node
+		return testNode{node, client.NewNaive(node)}
 	case "chord":
 		node := new(chord.Node)
 		node.Init(portToAddr(localAddress, port))
-		return node
+		node.RPC.Transport = Transport
+		node.RPC.TLSConfig = newTLSConfig(node.IP)
+		return testNode{node, client.NewChord(node)}
 	case "kademlia":
 		node := new(kademlia.Node)
 		node.Init(portToAddr(localAddress, port))
-		return node
+		node.RPC.Transport = Transport
+		node.RPC.TLSConfig = newTLSConfig(node.IP)
+		return testNode{node, client.NewKademlia(node)}
 	}
 	return nil
 }
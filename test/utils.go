@@ -1,18 +1,25 @@
 package test
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"dht/rpc"
+
 	"github.com/fatih/color"
 )
 
 const (
 	firstPort int = 20000
 
+	logFile = "dht-test.log"
+
 	lengthOfKeyValue int = 50
 
 	afterTestSleepTime = 30 * time.Second
@@ -132,8 +139,12 @@ type testInfo struct {
 	msg       string
 	failedCnt int
 	totalCnt  int
+	outcomes  map[string]int // 失败的数据操作按错误分类计数
 }
 
+// 读到的值与存入的不同
+var errWrongValue = errors.New("Wrong value.")
+
 func (info *testInfo) success() {
 	info.totalCnt++
 }
@@ -143,6 +154,27 @@ func (info *testInfo) fail() {
 	info.failedCnt++
 }
 
+// 数据操作失败，记录错误的种类(未找到、超时、找不到路由、下线等)
+func (info *testInfo) failWith(err error) {
+	info.fail()
+	if info.outcomes == nil {
+		info.outcomes = make(map[string]int)
+	}
+	outcome := rpc.Outcome(err)
+	if err == errWrongValue {
+		outcome = "wrong_value"
+	}
+	info.outcomes[outcome]++
+}
+
+// 检查Get的结果
+func checkGet(value string, err error, expected string) error {
+	if err == nil && value != expected {
+		return errWrongValue
+	}
+	return err
+}
+
 func (info *testInfo) finish(failedCnt *int, totalCnt *int) {
 	*failedCnt += info.failedCnt
 	*totalCnt += info.totalCnt
@@ -151,9 +183,22 @@ func (info *testInfo) finish(failedCnt *int, totalCnt *int) {
 
 func (info *testInfo) printInfo() {
 	if info.failedCnt > 0 {
-		red.Printf("%s failed with error rate %.4f\n", info.msg,
-			float64(info.failedCnt)/float64(info.totalCnt))
+		red.Printf("%s failed with error rate %.4f%s\n", info.msg,
+			float64(info.failedCnt)/float64(info.totalCnt), info.outcomeSummary())
 	} else {
 		green.Printf("%s passed.\n", info.msg)
 	}
 }
+
+// 失败的分类，如" (timeout 3, not_found 1)"
+func (info *testInfo) outcomeSummary() string {
+	if len(info.outcomes) == 0 {
+		return ""
+	}
+	outcomes := make([]string, 0, len(info.outcomes))
+	for outcome, count := range info.outcomes {
+		outcomes = append(outcomes, fmt.Sprintf("%s %d", outcome, count))
+	}
+	sort.Strings(outcomes)
+	return " (" + strings.Join(outcomes, ", ") + ")"
+}
@@ -0,0 +1,140 @@
+package trace
+
+import (
+	"sync"
+	"time"
+)
+
+// 查找中的一跳：询问的节点、所在轮次(并行的询问属于同一轮)、远端调用的耗时与错误
+type Hop struct {
+	IP      string
+	Round   int
+	Latency time.Duration //只包括该节点自身，递归查找中不包括之后各跳的耗时
+	Err     string        //为空表示成功
+}
+
+// 一次查找的路径
+type Trace struct {
+	Start   time.Time
+	Elapsed time.Duration
+	Hops    []Hop
+	lock    sync.Mutex
+}
+
+func New() *Trace {
+	return &Trace{Start: time.Now()}
+}
+
+// 记录一跳(可并发调用)
+func (trace *Trace) Add(hop Hop) {
+	trace.lock.Lock()
+	trace.Hops = append(trace.Hops, hop)
+	trace.lock.Unlock()
+}
+
+// 查找结束，记录总耗时
+func (trace *Trace) Finish() {
+	trace.lock.Lock()
+	trace.Elapsed = time.Since(trace.Start)
+	trace.lock.Unlock()
+}
+
+// 跳数：成功应答的询问所在的最大轮次
+func (trace *Trace) HopCount() int {
+	trace.lock.Lock()
+	defer trace.lock.Unlock()
+	out := 0
+	for _, hop := range trace.Hops {
+		if hop.Err == "" && hop.Round > out {
+			out = hop.Round
+		}
+	}
+	return out
+}
+
+// 成功应答的节点(按询问顺序，去除重复)
+func (trace *Trace) Visited() []string {
+	trace.lock.Lock()
+	defer trace.lock.Unlock()
+	out := []string{}
+	found := make(map[string]bool)
+	for _, hop := range trace.Hops {
+		if hop.Err == "" && !found[hop.IP] {
+			found[hop.IP] = true
+			out = append(out, hop.IP)
+		}
+	}
+	return out
+}
+
+// 查找中遇到的失败(无应答、超时等)
+func (trace *Trace) Failures() []Hop {
+	trace.lock.Lock()
+	defer trace.lock.Unlock()
+	out := []Hop{}
+	for _, hop := range trace.Hops {
+		if hop.Err != "" {
+			out = append(out, hop)
+		}
+	}
+	return out
+}
+
+// 各跳耗时之和
+func Latency(hops []Hop) time.Duration {
+	var out time.Duration
+	for _, hop := range hops {
+		out += hop.Latency
+	}
+	return out
+}
+
+// 跳数的直方图，用于检验路由是否为O(log N)跳，零值可直接使用
+type Histogram struct {
+	counts []int64
+	lock   sync.Mutex
+}
+
+// 记录一次查找的跳数
+func (histogram *Histogram) Observe(hops int) {
+	if hops < 0 {
+		return
+	}
+	histogram.lock.Lock()
+	for len(histogram.counts) <= hops {
+		histogram.counts = append(histogram.counts, 0)
+	}
+	histogram.counts[hops]++
+	histogram.lock.Unlock()
+}
+
+// 各跳数的查找次数(下标为跳数)
+func (histogram *Histogram) Counts() []int64 {
+	histogram.lock.Lock()
+	defer histogram.lock.Unlock()
+	out := make([]int64, len(histogram.counts))
+	copy(out, histogram.counts)
+	return out
+}
+
+// 查找总次数
+func (histogram *Histogram) Total() int64 {
+	out := int64(0)
+	for _, count := range histogram.Counts() {
+		out += count
+	}
+	return out
+}
+
+// 平均跳数(没有记录时为0)
+func (histogram *Histogram) Mean() float64 {
+	total, sum := int64(0), int64(0)
+	for hops, count := range histogram.Counts() {
+		total += count
+		sum += int64(hops) * count
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(sum) / float64(total)
+}
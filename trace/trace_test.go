@@ -0,0 +1,44 @@
+package trace
+
+import "testing"
+
+func TestHopCountAndVisited(t *testing.T) {
+	trace := New()
+	if trace.HopCount() != 0 || len(trace.Visited()) != 0 {
+		t.Fatal("empty trace has hops")
+	}
+	trace.Add(Hop{IP: "a", Round: 1})
+	trace.Add(Hop{IP: "b", Round: 2, Err: "offline"})
+	trace.Add(Hop{IP: "c", Round: 2})
+	trace.Add(Hop{IP: "a", Round: 3})
+	trace.Add(Hop{IP: "d", Round: 4, Err: "timeout"}) //失败的询问不计入跳数
+	trace.Finish()
+	if got := trace.HopCount(); got != 3 {
+		t.Errorf("HopCount = %d, want 3", got)
+	}
+	if got := trace.Visited(); len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Errorf("Visited = %v, want [a c]", got)
+	}
+	if got := trace.Failures(); len(got) != 2 || got[0].IP != "b" || got[1].IP != "d" {
+		t.Errorf("Failures = %v", got)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	var histogram Histogram
+	if histogram.Mean() != 0 || histogram.Total() != 0 {
+		t.Fatal("empty histogram has records")
+	}
+	for _, hops := range []int{1, 2, 2, 3, -1} { //负数被忽略
+		histogram.Observe(hops)
+	}
+	if got := histogram.Counts(); len(got) != 4 || got[0] != 0 || got[1] != 1 || got[2] != 2 || got[3] != 1 {
+		t.Errorf("Counts = %v, want [0 1 2 1]", got)
+	}
+	if got := histogram.Total(); got != 4 {
+		t.Errorf("Total = %d, want 4", got)
+	}
+	if got := histogram.Mean(); got != 2 {
+		t.Errorf("Mean = %v, want 2", got)
+	}
+}
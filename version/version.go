@@ -0,0 +1,209 @@
+package version
+
+import (
+	"sort"
+)
+
+// 向量时钟：写入者(节点地址) -> 该写入者的写入次数
+type Clock map[string]uint64
+
+// 两个时钟的先后关系
+type Ordering int
+
+const (
+	Equal      Ordering = iota
+	Before              //前者早于后者(被后者覆盖)
+	After               //前者晚于后者(覆盖后者)
+	Concurrent          //并发写入，互不覆盖
+)
+
+// 得到加上一次writer写入后的新时钟(不修改原时钟)
+func (clock Clock) Increment(writer string) Clock {
+	out := clock.Copy()
+	out[writer]++
+	return out
+}
+
+func (clock Clock) Copy() Clock {
+	out := make(Clock, len(clock)+1)
+	for writer, cnt := range clock {
+		out[writer] = cnt
+	}
+	return out
+}
+
+// 得到两个时钟逐项取最大值后的时钟
+func (clock Clock) Merge(other Clock) Clock {
+	out := clock.Copy()
+	for writer, cnt := range other {
+		if cnt > out[writer] {
+			out[writer] = cnt
+		}
+	}
+	return out
+}
+
+// 比较两个时钟的先后
+func (clock Clock) Compare(other Clock) Ordering {
+	before, after := false, false
+	for writer, cnt := range clock {
+		if cnt > other[writer] {
+			after = true
+		} else if cnt < other[writer] {
+			before = true
+		}
+	}
+	for writer, cnt := range other {
+		if _, ok := clock[writer]; !ok && cnt > 0 {
+			before = true
+		}
+	}
+	switch {
+	case before && after:
+		return Concurrent
+	case before:
+		return Before
+	case after:
+		return After
+	}
+	return Equal
+}
+
+// 一个版本的值
+type Value struct {
+	Value   string
+	Clock   Clock
+	Time    int64 //写入时间(纳秒)，用于默认的合并函数
+	Deleted bool  //墓碑：该版本表示数据已被删除
+}
+
+// 互不覆盖的各个版本(并发写入时有多个)
+type Siblings []Value
+
+// 加入一个版本：被其覆盖的版本被删去；若其被已有版本覆盖，则忽略
+func (siblings Siblings) Add(value Value) Siblings {
+	for _, sibling := range siblings {
+		if sibling.same(value) && sibling.Clock.Compare(value.Clock) == Concurrent { //并发写入了相同的值，合并为一个版本
+			value.Clock = value.Clock.Merge(sibling.Clock)
+			if sibling.Time > value.Time {
+				value.Time = sibling.Time
+			}
+		}
+	}
+	out := Siblings{}
+	for _, sibling := range siblings {
+		switch sibling.Clock.Compare(value.Clock) {
+		case After:
+			return siblings
+		case Equal:
+			if sibling.same(value) {
+				return siblings
+			}
+			out = append(out, sibling)
+		case Concurrent:
+			out = append(out, sibling)
+		}
+	}
+	return append(out, value)
+}
+
+// 两个版本的内容是否相同
+func (value Value) same(other Value) bool {
+	return value.Value == other.Value && value.Deleted == other.Deleted
+}
+
+// 合并两组版本(用于副本之间同步)
+func (siblings Siblings) Merge(other Siblings) Siblings {
+	out := siblings
+	for _, value := range other {
+		out = out.Add(value)
+	}
+	return out
+}
+
+// 是否覆盖另一组版本(其时钟不早于另一组版本的时钟)
+func (siblings Siblings) Covers(other Siblings) bool {
+	ordering := siblings.Clock().Compare(other.Clock())
+	return ordering == After || ordering == Equal
+}
+
+// 得到覆盖所有版本的时钟(作为写入的上下文)
+func (siblings Siblings) Clock() Clock {
+	clock := Clock{}
+	for _, sibling := range siblings {
+		clock = clock.Merge(sibling.Clock)
+	}
+	return clock
+}
+
+// 盲写：writer写入的新版本覆盖已知的所有版本
+func (siblings Siblings) Overwrite(value, writer string, time int64) Siblings {
+	return Siblings{{value, siblings.Clock().Increment(writer), time, false}}
+}
+
+// 盲删：writer写入的墓碑覆盖已知的所有版本
+func (siblings Siblings) Tombstone(writer string, time int64) Siblings {
+	return Siblings{{"", siblings.Clock().Increment(writer), time, true}}
+}
+
+// 得到未被删除的版本(与墓碑并发的写入仍然有效)
+func (siblings Siblings) Live() Siblings {
+	out := Siblings{}
+	for _, sibling := range siblings {
+		if !sibling.Deleted {
+			out = append(out, sibling)
+		}
+	}
+	return out
+}
+
+// 删去写入时间早于deadline的墓碑
+func (siblings Siblings) Expire(deadline int64) Siblings {
+	out := Siblings{}
+	for _, sibling := range siblings {
+		if !sibling.Deleted || sibling.Time >= deadline {
+			out = append(out, sibling)
+		}
+	}
+	return out
+}
+
+// 由多个版本得到一个值
+type MergeFunc func(siblings Siblings) string
+
+// 按合并函数得到值(只有一个版本时直接返回)，墓碑不参与合并
+func (siblings Siblings) Resolve(merge MergeFunc) string {
+	siblings = siblings.Live()
+	if len(siblings) == 0 {
+		return ""
+	}
+	if len(siblings) == 1 || merge == nil {
+		return siblings[0].Value
+	}
+	return merge(siblings)
+}
+
+// 默认的合并函数：取写入时间最晚的值(时间相同时取字典序较大的值)
+func LastWriteWins(siblings Siblings) string {
+	out := siblings[0]
+	for _, sibling := range siblings[1:] {
+		if sibling.Time > out.Time || (sibling.Time == out.Time && sibling.Value > out.Value) {
+			out = sibling
+		}
+	}
+	return out.Value
+}
+
+// 合并函数：将各版本的值按字典序排序后拼接(用于只增不减的数据，如append模式)
+func Concat(siblings Siblings) string {
+	values := []string{}
+	for _, sibling := range siblings {
+		values = append(values, sibling.Value)
+	}
+	sort.Strings(values)
+	out := ""
+	for _, value := range values {
+		out += value
+	}
+	return out
+}
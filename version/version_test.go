@@ -0,0 +1,164 @@
+package version
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	cases := []struct {
+		name         string
+		clock, other Clock
+		want         Ordering
+	}{
+		{"empty", Clock{}, Clock{}, Equal},
+		{"nil and empty", nil, Clock{}, Equal},
+		{"zero entry", Clock{"a": 0}, Clock{}, Equal},
+		{"same", Clock{"a": 1, "b": 2}, Clock{"a": 1, "b": 2}, Equal},
+		{"before", Clock{"a": 1}, Clock{"a": 2}, Before},
+		{"before with new writer", Clock{"a": 1}, Clock{"a": 1, "b": 1}, Before},
+		{"after", Clock{"a": 2, "b": 1}, Clock{"a": 1, "b": 1}, After},
+		{"after empty", Clock{"a": 1}, Clock{}, After},
+		{"concurrent", Clock{"a": 2, "b": 1}, Clock{"a": 1, "b": 2}, Concurrent},
+		{"concurrent writers", Clock{"a": 1}, Clock{"b": 1}, Concurrent},
+	}
+	for _, c := range cases {
+		if got := c.clock.Compare(c.other); got != c.want {
+			t.Errorf("%s: Compare = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestIncrementAndMerge(t *testing.T) {
+	clock := Clock{"a": 1}
+	next := clock.Increment("a").Increment("b")
+	if clock["a"] != 1 || len(clock) != 1 {
+		t.Fatalf("Increment modified the original clock: %v", clock)
+	}
+	if next.Compare(Clock{"a": 2, "b": 1}) != Equal {
+		t.Fatalf("Increment = %v", next)
+	}
+	merged := Clock{"a": 3, "b": 1}.Merge(Clock{"b": 2, "c": 1})
+	if merged.Compare(Clock{"a": 3, "b": 2, "c": 1}) != Equal {
+		t.Fatalf("Merge = %v", merged)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	cases := []struct {
+		name   string
+		old    Siblings
+		value  Value
+		values []string //加入后各版本的值
+	}{
+		{"into empty", nil, Value{Value: "x", Clock: Clock{"a": 1}}, []string{"x"}},
+		{"covers old", Siblings{{Value: "x", Clock: Clock{"a": 1}}}, Value{Value: "y", Clock: Clock{"a": 2}}, []string{"y"}},
+		{"covered by old", Siblings{{Value: "x", Clock: Clock{"a": 2}}}, Value{Value: "y", Clock: Clock{"a": 1}}, []string{"x"}},
+		{"concurrent", Siblings{{Value: "x", Clock: Clock{"a": 1}}}, Value{Value: "y", Clock: Clock{"b": 1}}, []string{"x", "y"}},
+		{"covers one of two", Siblings{{Value: "x", Clock: Clock{"a": 1}}, {Value: "y", Clock: Clock{"b": 1}}},
+			Value{Value: "z", Clock: Clock{"a": 2}}, []string{"y", "z"}},
+		{"covers both", Siblings{{Value: "x", Clock: Clock{"a": 1}}, {Value: "y", Clock: Clock{"b": 1}}},
+			Value{Value: "z", Clock: Clock{"a": 1, "b": 1, "c": 1}}, []string{"z"}},
+		{"same version", Siblings{{Value: "x", Clock: Clock{"a": 1}}}, Value{Value: "x", Clock: Clock{"a": 1}}, []string{"x"}},
+		{"concurrent same value", Siblings{{Value: "x", Clock: Clock{"a": 1}}}, Value{Value: "x", Clock: Clock{"b": 1}}, []string{"x"}},
+	}
+	for _, c := range cases {
+		got := c.old.Add(c.value)
+		if !sameValues(got, c.values) {
+			t.Errorf("%s: Add = %v, want values %v", c.name, got, c.values)
+		}
+	}
+}
+
+func TestAddMergesConcurrentSameValue(t *testing.T) {
+	siblings := Siblings{{Value: "x", Clock: Clock{"a": 1}, Time: 2}}.Add(Value{Value: "x", Clock: Clock{"b": 1}, Time: 1})
+	if len(siblings) != 1 || siblings[0].Clock.Compare(Clock{"a": 1, "b": 1}) != Equal || siblings[0].Time != 2 {
+		t.Fatalf("Add = %v, want one version with the merged clock and the later time", siblings)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	replica1 := Siblings{{Value: "x", Clock: Clock{"a": 1}}}.Add(Value{Value: "y", Clock: Clock{"b": 1}})
+	replica2 := Siblings{{Value: "z", Clock: Clock{"a": 2}}}
+	merged := replica1.Merge(replica2)
+	if !sameValues(merged, []string{"y", "z"}) {
+		t.Fatalf("Merge = %v", merged)
+	}
+	if !sameValues(replica2.Merge(replica1), []string{"y", "z"}) {
+		t.Fatalf("Merge is not symmetric: %v", replica2.Merge(replica1))
+	}
+	if !merged.Covers(replica1) || !merged.Covers(replica2) || replica1.Covers(merged) {
+		t.Fatal("wrong Covers after Merge")
+	}
+}
+
+func TestTombstone(t *testing.T) {
+	siblings := Siblings{{Value: "x", Clock: Clock{"a": 1}}, {Value: "y", Clock: Clock{"b": 1}}}
+	deleted := siblings.Tombstone("c", 10)
+	if len(deleted) != 1 || !deleted[0].Deleted || len(deleted.Live()) != 0 {
+		t.Fatalf("Tombstone = %v", deleted)
+	}
+	if !sameValues(deleted.Merge(siblings), []string{""}) { //被墓碑覆盖的旧版本不会复活
+		t.Fatalf("old versions revived: %v", deleted.Merge(siblings))
+	}
+	concurrent := deleted.Add(Value{Value: "z", Clock: Clock{"d": 1}}) //与墓碑并发的写入仍然有效
+	if live := concurrent.Live(); len(live) != 1 || live[0].Value != "z" {
+		t.Fatalf("Live = %v", live)
+	}
+	if got := concurrent.Resolve(LastWriteWins); got != "z" {
+		t.Fatalf("Resolve = %q, want z", got)
+	}
+	if got := deleted.Resolve(LastWriteWins); got != "" {
+		t.Fatalf("Resolve of a tombstone = %q", got)
+	}
+	expired := concurrent.Expire(11)
+	if len(expired) != 1 || expired[0].Value != "z" {
+		t.Fatalf("Expire = %v", expired)
+	}
+	if kept := concurrent.Expire(10); len(kept) != 2 {
+		t.Fatalf("Expire removed a tombstone within the grace period: %v", kept)
+	}
+}
+
+func TestMergeFunc(t *testing.T) {
+	siblings := Siblings{
+		{Value: "b", Clock: Clock{"a": 1}, Time: 3},
+		{Value: "c", Clock: Clock{"b": 1}, Time: 1},
+		{Value: "a", Clock: Clock{"c": 1}, Time: 3},
+		{Value: "", Clock: Clock{"d": 1}, Time: 5, Deleted: true},
+	}
+	cases := []struct {
+		name  string
+		merge MergeFunc
+		want  string
+	}{
+		{"last write wins", LastWriteWins, "b"}, //时间相同时取字典序较大的值，墓碑不参与
+		{"concat", Concat, "abc"},
+		{"nil", nil, "b"},
+	}
+	for _, c := range cases {
+		if got := siblings.Resolve(c.merge); got != c.want {
+			t.Errorf("%s: Resolve = %q, want %q", c.name, got, c.want)
+		}
+	}
+	if got := (Siblings{{Value: "x", Clock: Clock{"a": 1}}}).Resolve(Concat); got != "x" {
+		t.Errorf("Resolve of one version = %q", got)
+	}
+}
+
+// 各版本的值(顺序无关)是否为values
+func sameValues(siblings Siblings, values []string) bool {
+	if len(siblings) != len(values) {
+		return false
+	}
+	count := map[string]int{}
+	for _, value := range values {
+		count[value]++
+	}
+	for _, sibling := range siblings {
+		count[sibling.Value]--
+	}
+	for _, n := range count {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}